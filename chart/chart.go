@@ -0,0 +1,138 @@
+// Package chart draws the small PNG charts the bot sends to users. Labels use
+// a fixed ASCII font, so callers keep Turkish text in the message caption.
+package chart
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Point is one value of a step series. The value holds until the next point.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+const (
+	width        = 800
+	height       = 400
+	marginLeft   = 70
+	marginRight  = 20
+	marginTop    = 20
+	marginBottom = 40
+	gridLines    = 5
+	timeTicks    = 5
+)
+
+var (
+	background = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	axis       = color.RGBA{0x44, 0x44, 0x44, 0xff}
+	grid       = color.RGBA{0xe4, 0xe4, 0xe4, 0xff}
+	line       = color.RGBA{0x1f, 0x6f, 0xc5, 0xff}
+	marker     = color.RGBA{0xd9, 0x3b, 0x2b, 0xff}
+)
+
+// Steps draws points as a step line running until the given time. Times are
+// labelled in loc and values with formatValue.
+func Steps(points []Point, until time.Time, loc *time.Location, formatValue func(float64) string) ([]byte, error) {
+	if len(points) == 0 {
+		return nil, errors.New("chart: no points")
+	}
+
+	start := points[0].Time
+	end := until
+	if last := points[len(points)-1].Time; end.Before(last) {
+		end = last
+	}
+	if !end.After(start) {
+		end = start.Add(time.Hour)
+	}
+
+	low, high := points[0].Value, points[0].Value
+	for _, p := range points {
+		low = math.Min(low, p.Value)
+		high = math.Max(high, p.Value)
+	}
+	pad := (high - low) * 0.1
+	if pad == 0 {
+		pad = math.Max(high*0.1, 1)
+	}
+	low, high = math.Max(low-pad, 0), high+pad
+
+	plot := image.Rect(marginLeft, marginTop, width-marginRight, height-marginBottom)
+	x := func(t time.Time) int {
+		return plot.Min.X + int(float64(plot.Dx())*float64(t.Sub(start))/float64(end.Sub(start)))
+	}
+	y := func(v float64) int {
+		return plot.Max.Y - int(float64(plot.Dy())*(v-low)/(high-low))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill(img, img.Bounds(), background)
+
+	for i := 0; i <= gridLines; i++ {
+		v := low + (high-low)*float64(i)/gridLines
+		fill(img, image.Rect(plot.Min.X, y(v), plot.Max.X, y(v)+1), grid)
+		label(img, formatValue(math.Round(v)), plot.Min.X-8, y(v)+4, true)
+	}
+	for i := 0; i <= timeTicks; i++ {
+		t := start.Add(time.Duration(float64(end.Sub(start)) * float64(i) / timeTicks))
+		fill(img, image.Rect(x(t), plot.Max.Y, x(t)+1, plot.Max.Y+4), axis)
+		label(img, t.In(loc).Format("02.01 15:04"), x(t), plot.Max.Y+18, false)
+	}
+	fill(img, image.Rect(plot.Min.X, plot.Min.Y, plot.Min.X+1, plot.Max.Y+1), axis)
+	fill(img, image.Rect(plot.Min.X, plot.Max.Y, plot.Max.X, plot.Max.Y+1), axis)
+
+	for i, p := range points {
+		next := end
+		if i+1 < len(points) {
+			next = points[i+1].Time
+		}
+		fill(img, image.Rect(x(p.Time), y(p.Value)-1, x(next)+1, y(p.Value)+1), line)
+		if i+1 < len(points) {
+			top, bottom := y(p.Value), y(points[i+1].Value)
+			if top > bottom {
+				top, bottom = bottom, top
+			}
+			fill(img, image.Rect(x(next)-1, top-1, x(next)+1, bottom+1), line)
+		}
+	}
+	for _, p := range points {
+		fill(img, image.Rect(x(p.Time)-3, y(p.Value)-3, x(p.Time)+4, y(p.Value)+4), marker)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func fill(img draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// label draws text with its baseline at y, ending at x when alignRight is set
+// and centered on x otherwise. Labels are kept inside the image.
+func label(img draw.Image, text string, x, y int, alignRight bool) {
+	d := font.Drawer{Dst: img, Src: image.NewUniform(axis), Face: basicfont.Face7x13}
+	textWidth := d.MeasureString(text).Round()
+	if alignRight {
+		x -= textWidth
+	} else {
+		x -= textWidth / 2
+	}
+	x = min(max(x, 0), img.Bounds().Dx()-textWidth)
+	d.Dot = fixed.P(x, y)
+	d.DrawString(text)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"tcddbot/faketcdd"
+)
+
+func main() {
+	addr := flag.String("addr", ":8089", "listen address")
+	fixturePath := flag.String("fixture", "faketcdd/fixtures/example.json", "fixture file")
+	token := flag.String("token", "", "require this Authorization header value")
+	flag.Parse()
+
+	fixture, err := faketcdd.LoadFixture(*fixturePath)
+	if err != nil {
+		log.Fatalf("Failed to load fixture: %v", err)
+	}
+
+	server := faketcdd.New(fixture)
+	if *token != "" {
+		server.RequireToken(*token)
+	}
+
+	log.Printf("Fake TCDD listening on %s, set TCDD_FAKE_URL=http://localhost%s to use it", *addr, *addr)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
@@ -1,66 +1,151 @@
 package main
 
 import (
-    "context"
-    "log"
-    "os"
-    "os/signal"
-    "syscall"
-    "tcddbot/config"
-    "tcddbot/db"
-    "tcddbot/handlers"
-    "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"context"
+	"fmt"
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"tcddbot/config"
+	"tcddbot/db"
+	"tcddbot/handlers"
+	"tcddbot/messenger"
+	"tcddbot/store"
+	"tcddbot/webhook"
 )
 
 func main() {
-    ctx, cancel := context.WithCancel(context.Background())
-    defer cancel()
-
-    // Load configuration
-    cfg, err := config.Load()
-    if err != nil {
-        log.Fatalf("Failed to load config: %v", err)
-    }
-
-    // Initialize database
-    database, err := db.Initialize(cfg.DBPath)
-    if err != nil {
-        log.Fatalf("Failed to initialize database: %v", err)
-    }
-    defer database.Close()
-
-    // Initialize bot
-    bot, err := tgbotapi.NewBotAPI(cfg.BotToken)
-    if err != nil {
-        log.Fatalf("Failed to initialize bot: %v", err)
-    }
-
-    // Initialize handler
-    handler := handlers.NewHandler(bot, database, cfg)
-
-    // Setup signal handling
-    sigChan := make(chan os.Signal, 1)
-    signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-    // Start background tasks
-    go handler.StartPeriodicCheck(ctx)
-    go handler.StartCleanup(ctx)
-
-    // Handle updates
-    updates := bot.GetUpdatesChan(tgbotapi.UpdateConfig{
-        Timeout: 60,
-    })
-
-    for {
-        select {
-        case update := <-updates:
-            handler.HandleUpdate(ctx, update)
-        case <-sigChan:
-            log.Println("Shutting down gracefully...")
-            cancel()
-            return
-        case <-ctx.Done():
-            return
-        }
-    }
-}
\ No newline at end of file
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctx, cfg, os.Args[2:]); err != nil {
+			log.Fatalf("Migrate failed: %v", err)
+		}
+		return
+	}
+
+	// Initialize database
+	dialect, dsn := db.FromConfig(cfg)
+	database, err := db.Initialize(dialect, dsn)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+
+	// Initialize bot
+	bot, err := tgbotapi.NewBotAPI(cfg.BotToken)
+	if err != nil {
+		log.Fatalf("Failed to initialize bot: %v", err)
+	}
+
+	// Initialize handler
+	handler := handlers.NewHandler(messenger.NewTelegram(bot), store.NewSQL(database, dialect), cfg)
+
+	// Setup signal handling
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// Start background tasks. Instances sharing a database take turns
+	// running the checks, the others stand by for the lock.
+	go func() {
+		checkCtx, release, err := db.LockChecks(ctx, database, dialect)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error locking periodic checks: %v", err)
+			}
+			return
+		}
+		defer release()
+
+		handler.StartPeriodicCheck(checkCtx)
+		// The checks cannot move to another instance on their own
+		if ctx.Err() == nil {
+			log.Fatalf("Periodic checks stopped: %v", context.Cause(checkCtx))
+		}
+	}()
+	go handler.StartCleanup(ctx)
+	go handler.StartConversationExpiry(ctx)
+
+	if cfg.UpdateMode == config.UpdateModeWebhook {
+		go func() {
+			<-sigChan
+			log.Println("Shutting down gracefully...")
+			cancel()
+		}()
+
+		if err := webhook.New(bot, cfg, handler.HandleUpdate).Run(ctx); err != nil {
+			log.Fatalf("Webhook server failed: %v", err)
+		}
+		return
+	}
+
+	// getUpdates is refused while a webhook is registered, e.g. after switching modes
+	if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		log.Printf("Error deleting webhook: %v", err)
+	}
+
+	// Handle updates
+	updates := bot.GetUpdatesChan(tgbotapi.UpdateConfig{
+		Timeout: 60,
+	})
+
+	for {
+		select {
+		case update := <-updates:
+			handler.HandleUpdate(ctx, update)
+		case <-sigChan:
+			log.Println("Shutting down gracefully...")
+			cancel()
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runMigrate implements "tcddbot migrate [status|up]"
+func runMigrate(ctx context.Context, cfg *config.Config, args []string) error {
+	dialect, dsn := db.FromConfig(cfg)
+	database, err := db.Open(dialect, dsn)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		if err := db.Migrate(ctx, database, dialect); err != nil {
+			return err
+		}
+	case "status":
+	default:
+		return fmt.Errorf("unknown migrate command %q, use status or up", command)
+	}
+
+	statuses, err := db.Status(ctx, database, dialect)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d  %-30s %s\n", status.Version, status.Name, state)
+	}
+	return nil
+}
@@ -1,38 +1,201 @@
 package config
 
 import (
-    "fmt"
-    "os"
-    "strconv"
-    "time"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
-    "github.com/joho/godotenv"
+	"github.com/joho/godotenv"
+)
+
+const (
+	UpdateModePolling = "polling"
+	UpdateModeWebhook = "webhook"
 )
 
 type Config struct {
-    BotToken          string
-    DBPath            string
-    APIEndpoint       string
-    AuthToken         string
-    UnitID           string
-    CheckInterval     time.Duration
-    CleanupInterval   time.Duration
-    AdminChatID       int64 `envconfig:"ADMIN_CHAT_ID" required:"true"`
+	BotToken           string
+	DBPath             string
+	DatabaseURL        string // PostgreSQL DSN, takes precedence over DBPath
+	StationsPath       string
+	APIEndpoint        string
+	AuthToken          string
+	UnitID             string
+	CheckInterval      time.Duration // Shortest time between two checks of a route
+	CheckBudget        int           // TCDD requests periodic checks may make per minute, 0 for no limit
+	CleanupInterval    time.Duration
+	RequestTimeout     time.Duration
+	CheckTimeout       time.Duration // Deadline of one subscription check, retries and every leg included
+	MaxAttempts        int
+	RetryBaseDelay     time.Duration
+	RetryMaxDelay      time.Duration
+	BreakerThreshold   int
+	BreakerCooldown    time.Duration
+	UpstreamRate       float64       // TCDD requests per second across the bot, 0 for no limit
+	UpstreamBurst      int           // Requests that may go out at once after a quiet spell
+	CacheTTL           time.Duration // How long availability answers are reused, 0 disables the cache
+	WizardTTL          time.Duration // Idle time after which an unfinished /abone wizard is dropped, 0 keeps it
+	AdminChatID        int64         `envconfig:"ADMIN_CHAT_ID" required:"true"`
+	PriceDropThreshold float64       // TL per passenger a fare must fall by since the last alert, 0 disables price alerts
+	RecurringWeeks     int           // Weeks ahead a recurring subscription is checked
+	SaleHorizonDays    int           // Days ahead TCDD sells tickets, later days are not checked
+	MinTransfer        time.Duration // Shortest change between the trains of a connection
+
+	// Telegram update delivery
+	UpdateMode         string
+	WebhookURL         string // Public base URL Telegram posts to, e.g. https://bot.example.com
+	WebhookListenAddr  string
+	WebhookPathSecret  string // Random path segment so the endpoint is not guessable
+	WebhookSecretToken string // Checked against X-Telegram-Bot-Api-Secret-Token, required in webhook mode
+	WebhookCertFile    string // Serve HTTPS directly when set, otherwise plain HTTP behind a proxy
+	WebhookKeyFile     string
+	WebhookWorkers     int
 }
 
 func Load() (*Config, error) {
-    if err := godotenv.Load("../.env"); err != nil {
-        fmt.Println("No .env file found")
-    }
-
-    return &Config{
-        BotToken:        os.Getenv("BOT_TOKEN"),
-        DBPath:         os.Getenv("DB_PATH"),
-        APIEndpoint:    "https://web-api-prod-ytp.tcddtasimacilik.gov.tr/tms/train",
-        AuthToken:      os.Getenv("AUTHORIZATION_TOKEN"),
-        UnitID:        "3895",
-        CheckInterval:  5 * time.Second,
-        CleanupInterval: 1 * time.Hour, // Add default cleanup interval
-        AdminChatID:    func() int64 { id, _ := strconv.ParseInt(os.Getenv("ADMIN_CHAT_ID"), 10, 64); return id }(),
-    }, nil
+	if err := godotenv.Load("../.env"); err != nil {
+		fmt.Println("No .env file found")
+	}
+
+	// Point the bot at a local cmd/fakeTCDD instead of production
+	apiEndpoint := "https://web-api-prod-ytp.tcddtasimacilik.gov.tr/tms/train"
+	if fakeURL := os.Getenv("TCDD_FAKE_URL"); fakeURL != "" {
+		apiEndpoint = strings.TrimSuffix(fakeURL, "/") + "/tms/train"
+	}
+
+	updateMode := os.Getenv("UPDATE_MODE")
+	if updateMode == "" {
+		updateMode = UpdateModePolling
+	}
+
+	cfg := &Config{
+		BotToken:           os.Getenv("BOT_TOKEN"),
+		DBPath:             os.Getenv("DB_PATH"),
+		DatabaseURL:        os.Getenv("DATABASE_URL"),
+		StationsPath:       "./stations.json",
+		APIEndpoint:        apiEndpoint,
+		AuthToken:          os.Getenv("AUTHORIZATION_TOKEN"),
+		UnitID:             "3895",
+		CheckInterval:      5 * time.Second,
+		CheckBudget:        120,
+		CleanupInterval:    1 * time.Hour, // Add default cleanup interval
+		RequestTimeout:     10 * time.Second,
+		CheckTimeout:       1 * time.Minute,
+		MaxAttempts:        3,
+		RetryBaseDelay:     500 * time.Millisecond,
+		RetryMaxDelay:      5 * time.Second,
+		BreakerThreshold:   5,
+		BreakerCooldown:    1 * time.Minute,
+		UpstreamRate:       2,
+		UpstreamBurst:      5,
+		CacheTTL:           30 * time.Second,
+		WizardTTL:          30 * time.Minute,
+		AdminChatID:        func() int64 { id, _ := strconv.ParseInt(os.Getenv("ADMIN_CHAT_ID"), 10, 64); return id }(),
+		UpdateMode:         updateMode,
+		WebhookURL:         strings.TrimSuffix(os.Getenv("WEBHOOK_URL"), "/"),
+		WebhookListenAddr:  getEnv("WEBHOOK_LISTEN_ADDR", ":8080"),
+		WebhookPathSecret:  os.Getenv("WEBHOOK_PATH_SECRET"),
+		WebhookSecretToken: os.Getenv("WEBHOOK_SECRET_TOKEN"),
+		WebhookCertFile:    os.Getenv("WEBHOOK_CERT_FILE"),
+		WebhookKeyFile:     os.Getenv("WEBHOOK_KEY_FILE"),
+		WebhookWorkers:     10,
+		PriceDropThreshold: 50,
+		RecurringWeeks:     4,
+		SaleHorizonDays:    30,
+		MinTransfer:        20 * time.Minute,
+	}
+
+	if threshold := os.Getenv("PRICE_DROP_THRESHOLD"); threshold != "" {
+		value, err := strconv.ParseFloat(threshold, 64)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid PRICE_DROP_THRESHOLD %q", threshold)
+		}
+		cfg.PriceDropThreshold = value
+	}
+
+	if rate := os.Getenv("TCDD_RATE_PER_SECOND"); rate != "" {
+		value, err := strconv.ParseFloat(rate, 64)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid TCDD_RATE_PER_SECOND %q", rate)
+		}
+		cfg.UpstreamRate = value
+	}
+
+	if burst := os.Getenv("TCDD_BURST"); burst != "" {
+		n, err := strconv.Atoi(burst)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid TCDD_BURST %q", burst)
+		}
+		cfg.UpstreamBurst = n
+	}
+
+	if minutes := os.Getenv("WIZARD_TTL_MINUTES"); minutes != "" {
+		n, err := strconv.Atoi(minutes)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid WIZARD_TTL_MINUTES %q", minutes)
+		}
+		cfg.WizardTTL = time.Duration(n) * time.Minute
+	}
+
+	if seconds := os.Getenv("TCDD_CACHE_TTL_SECONDS"); seconds != "" {
+		n, err := strconv.Atoi(seconds)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid TCDD_CACHE_TTL_SECONDS %q", seconds)
+		}
+		cfg.CacheTTL = time.Duration(n) * time.Second
+	}
+
+	if budget := os.Getenv("CHECK_BUDGET_PER_MINUTE"); budget != "" {
+		n, err := strconv.Atoi(budget)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid CHECK_BUDGET_PER_MINUTE %q", budget)
+		}
+		cfg.CheckBudget = n
+	}
+
+	for key, value := range map[string]*int{"RECURRING_WEEKS": &cfg.RecurringWeeks, "SALE_HORIZON_DAYS": &cfg.SaleHorizonDays} {
+		if text := os.Getenv(key); text != "" {
+			n, err := strconv.Atoi(text)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid %s %q", key, text)
+			}
+			*value = n
+		}
+	}
+
+	if minutes := os.Getenv("MIN_TRANSFER_MINUTES"); minutes != "" {
+		n, err := strconv.Atoi(minutes)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid MIN_TRANSFER_MINUTES %q", minutes)
+		}
+		cfg.MinTransfer = time.Duration(n) * time.Minute
+	}
+
+	switch cfg.UpdateMode {
+	case UpdateModePolling:
+	case UpdateModeWebhook:
+		if cfg.WebhookURL == "" {
+			return nil, fmt.Errorf("WEBHOOK_URL is required in webhook mode")
+		}
+		// Without it anyone reaching the port could post updates as any chat
+		if cfg.WebhookSecretToken == "" {
+			return nil, fmt.Errorf("WEBHOOK_SECRET_TOKEN is required in webhook mode")
+		}
+		if (cfg.WebhookCertFile == "") != (cfg.WebhookKeyFile == "") {
+			return nil, fmt.Errorf("WEBHOOK_CERT_FILE and WEBHOOK_KEY_FILE must be set together")
+		}
+	default:
+		return nil, fmt.Errorf("unknown UPDATE_MODE %q", cfg.UpdateMode)
+	}
+
+	return cfg, nil
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
 }
@@ -1,40 +1,75 @@
 package db
 
 import (
-    "database/sql"
-    _ "modernc.org/sqlite"
+	"context"
+	"database/sql"
+	"fmt"
+	"tcddbot/config"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
-func Initialize(dbPath string) (*sql.DB, error) {
-    db, err := sql.Open("sqlite", dbPath)
-    if (err != nil) {
-        return nil, err
-    }
+// FromConfig picks PostgreSQL when DATABASE_URL is set and SQLite on
+// DB_PATH otherwise.
+func FromConfig(cfg *config.Config) (Dialect, string) {
+	if cfg.DatabaseURL != "" {
+		return Postgres, cfg.DatabaseURL
+	}
+	return SQLite, cfg.DBPath
+}
+
+// Initialize opens the database and migrates it to the latest schema.
+func Initialize(dialect Dialect, dsn string) (*sql.DB, error) {
+	db, err := Open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Migrate(context.Background(), db, dialect); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Open opens the database without touching its schema.
+func Open(dialect Dialect, dsn string) (*sql.DB, error) {
+	switch dialect {
+	case SQLite:
+		return openSQLite(dsn)
+	case Postgres:
+		return openPostgres(dsn)
+	}
+	return nil, fmt.Errorf("unsupported database dialect %q", dialect)
+}
 
-    // Enable WAL mode
-    if _, err := db.Exec(`PRAGMA journal_mode=WAL`); err != nil {
-        return nil, err
-    }
+func openSQLite(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, err
+	}
 
-    // Create tables if they don't exist
-    if err := createTables(db); err != nil {
-        return nil, err
-    }
+	// Enable WAL mode
+	if _, err := db.Exec(`PRAGMA journal_mode=WAL`); err != nil {
+		db.Close()
+		return nil, err
+	}
 
-    return db, nil
+	return db, nil
 }
 
-func createTables(db *sql.DB) error {
-    _, err := db.Exec(`
-        CREATE TABLE IF NOT EXISTS subscriptions (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            chat_id INTEGER,
-            departure_station_id INTEGER,
-            arrival_station_id INTEGER,
-            travel_date TEXT,
-            last_notified DATETIME,
-            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-            deleted_at DATETIME
-        )`)
-    return err
+func openPostgres(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to postgres: %w", err)
+	}
+
+	return db, nil
 }
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// checkLockID is held by the one instance running the periodic checks when
+// several share a PostgreSQL database.
+const checkLockID = 7_245_332
+
+// lockPingInterval is how often the session holding the check lock is
+// checked to still be alive.
+const lockPingInterval = 30 * time.Second
+
+// ErrCheckLockLost is the cause of the context returned by LockChecks when
+// the session holding the lock is gone.
+var ErrCheckLockLost = errors.New("lost the session holding the check lock")
+
+// LockChecks blocks until this instance may run the periodic checks, so a
+// subscription is checked and notified once however many instances serve
+// the bot. On PostgreSQL it takes a session level advisory lock, which a
+// crashed instance gives up with its connection. A SQLite file belongs to
+// one instance and is never waited for.
+//
+// The returned context is done with ctx, or with ErrCheckLockLost as its
+// cause once the session holding the lock fails, and the returned func
+// releases the lock.
+func LockChecks(ctx context.Context, db *sql.DB, dialect Dialect) (context.Context, func(), error) {
+	if dialect != Postgres {
+		lockCtx, cancel := context.WithCancel(ctx)
+		return lockCtx, cancel, nil
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, checkLockID); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("acquire check lock: %w", err)
+	}
+
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lockPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-lockCtx.Done():
+				return
+			case <-ticker.C:
+				if err := conn.PingContext(lockCtx); err != nil && lockCtx.Err() == nil {
+					cancel(fmt.Errorf("%w: %v", ErrCheckLockLost, err))
+					return
+				}
+			}
+		}
+	}()
+
+	return lockCtx, func() {
+		cancel(context.Canceled)
+		<-done
+		conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, checkLockID)
+		conn.Close()
+	}, nil
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockChecksSQLite(t *testing.T) {
+	db, err := Open(SQLite, filepath.Join(t.TempDir(), "tcddbot.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	// One file, one instance: a second lock does not wait for the first
+	first, releaseFirst, err := LockChecks(context.Background(), db, SQLite)
+	if err != nil {
+		t.Fatalf("first lock: %v", err)
+	}
+	_, releaseSecond, err := LockChecks(context.Background(), db, SQLite)
+	if err != nil {
+		t.Fatalf("second lock: %v", err)
+	}
+	releaseSecond()
+
+	releaseFirst()
+	if first.Err() == nil {
+		t.Fatalf("lock context still live after release")
+	}
+}
+
+// TestLockChecksPostgres needs a server, the store tests read the same
+// TEST_DATABASE_URL.
+func TestLockChecksPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	open := func() *sql.DB {
+		db, err := Open(Postgres, dsn)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	leader, standby := open(), open()
+
+	_, release, err := LockChecks(context.Background(), leader, Postgres)
+	if err != nil {
+		t.Fatalf("leader lock: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, _, err := LockChecks(ctx, standby, Postgres); !errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		t.Fatalf("standby lock while the leader holds it: got %v, want to wait", err)
+	}
+
+	// The standby takes over once the leader lets go
+	release()
+	lockCtx, releaseStandby, err := LockChecks(context.Background(), standby, Postgres)
+	if err != nil {
+		t.Fatalf("standby lock after release: %v", err)
+	}
+	defer releaseStandby()
+	if lockCtx.Err() != nil {
+		t.Fatalf("standby lock context done: %v", context.Cause(lockCtx))
+	}
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrationsMatchAcrossDialects(t *testing.T) {
+	sqlite, err := Migrations(SQLite)
+	if err != nil {
+		t.Fatalf("sqlite migrations: %v", err)
+	}
+	postgres, err := Migrations(Postgres)
+	if err != nil {
+		t.Fatalf("postgres migrations: %v", err)
+	}
+
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("sqlite has %d migrations, postgres %d", len(sqlite), len(postgres))
+	}
+	for i, m := range sqlite {
+		if m.Version != i+1 {
+			t.Fatalf("migration %d has version %d, want %d", i, m.Version, i+1)
+		}
+		if postgres[i].Version != m.Version || postgres[i].Name != m.Name {
+			t.Fatalf("migration %04d_%s has no postgres counterpart, got %04d_%s",
+				m.Version, m.Name, postgres[i].Version, postgres[i].Name)
+		}
+	}
+}
+
+func TestMigrateSQLite(t *testing.T) {
+	ctx := context.Background()
+	db, err := Open(SQLite, filepath.Join(t.TempDir(), "tcddbot.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer db.Close()
+
+	migrations, err := Migrations(SQLite)
+	if err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+
+	statuses, err := Status(ctx, db, SQLite)
+	if err != nil {
+		t.Fatalf("status of an empty database: %v", err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatalf("status of an empty database: got %d migrations, want %d", len(statuses), len(migrations))
+	}
+	for _, s := range statuses {
+		if s.Applied {
+			t.Fatalf("status of an empty database: %04d_%s is applied", s.Version, s.Name)
+		}
+	}
+
+	if err := Migrate(ctx, db, SQLite); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	applied := checkApplied(t, db, migrations)
+
+	// Migrating again is a no-op
+	if err := Migrate(ctx, db, SQLite); err != nil {
+		t.Fatalf("migrate again: %v", err)
+	}
+	for version, at := range checkApplied(t, db, migrations) {
+		if !at.Equal(applied[version]) {
+			t.Fatalf("migrate again: %04d was applied again at %v", version, at)
+		}
+	}
+
+	// The head schema is usable
+	for _, table := range []string{"subscriptions", "users", "price_history", "alert_prices", "conversations"} {
+		if _, err := db.ExecContext(ctx, "SELECT COUNT(*) FROM "+table); err != nil {
+			t.Fatalf("table %s: %v", table, err)
+		}
+	}
+}
+
+// checkApplied fails unless Status reports every migration as applied and
+// returns when each was.
+func checkApplied(t *testing.T, db *sql.DB, migrations []Migration) map[int]time.Time {
+	t.Helper()
+
+	statuses, err := Status(context.Background(), db, SQLite)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatalf("status: got %d migrations, want %d", len(statuses), len(migrations))
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count); err != nil {
+		t.Fatalf("count schema_migrations: %v", err)
+	}
+	if count != len(migrations) {
+		t.Fatalf("schema_migrations has %d rows, want %d", count, len(migrations))
+	}
+
+	applied := make(map[int]time.Time)
+	for i, s := range statuses {
+		if s.Version != migrations[i].Version {
+			t.Fatalf("status: migration %d has version %d, want %d", i, s.Version, migrations[i].Version)
+		}
+		if !s.Applied || s.AppliedAt.IsZero() {
+			t.Fatalf("status: %04d_%s applied %v at %v", s.Version, s.Name, s.Applied, s.AppliedAt)
+		}
+		applied[s.Version] = s.AppliedAt
+	}
+	return applied
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id BIGSERIAL PRIMARY KEY,
+    chat_id BIGINT,
+    departure_station_id INTEGER,
+    arrival_station_id INTEGER,
+    travel_date TEXT,
+    last_notified TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMPTZ
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS users (
+    chat_id BIGINT PRIMARY KEY,
+    username TEXT,
+    first_name TEXT,
+    last_name TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
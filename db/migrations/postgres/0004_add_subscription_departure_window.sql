@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions ADD COLUMN earliest_departure TEXT NOT NULL DEFAULT '';
+ALTER TABLE subscriptions ADD COLUMN latest_departure TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE subscriptions ADD COLUMN passengers TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE subscriptions ADD COLUMN max_price DOUBLE PRECISION NOT NULL DEFAULT 0;
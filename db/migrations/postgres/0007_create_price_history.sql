@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS price_history (
+    id BIGSERIAL PRIMARY KEY,
+    departure_station_id INTEGER NOT NULL,
+    arrival_station_id INTEGER NOT NULL,
+    travel_date TEXT NOT NULL,
+    train_number TEXT NOT NULL,
+    departure_time TIMESTAMPTZ,
+    cabin_class TEXT NOT NULL,
+    cabin_class_name TEXT NOT NULL DEFAULT '',
+    price DOUBLE PRECISION NOT NULL,
+    currency TEXT NOT NULL DEFAULT '',
+    observed_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_price_history_route
+    ON price_history (departure_station_id, arrival_station_id, travel_date, observed_at);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS alert_prices (
+    subscription_id BIGINT NOT NULL,
+    travel_date TEXT NOT NULL,
+    price DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (subscription_id, travel_date)
+);
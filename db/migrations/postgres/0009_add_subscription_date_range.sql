@@ -0,0 +1,4 @@
+ALTER TABLE subscriptions ADD COLUMN travel_date_end TEXT NOT NULL DEFAULT '';
+ALTER TABLE subscriptions ADD COLUMN weekdays TEXT NOT NULL DEFAULT '';
+ALTER TABLE subscriptions ADD COLUMN keep_watching BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE subscriptions ADD COLUMN satisfied_dates TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE subscriptions ADD COLUMN recurring BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE subscriptions ADD COLUMN weeks INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN paused_weeks TEXT NOT NULL DEFAULT '';
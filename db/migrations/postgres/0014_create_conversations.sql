@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS conversations (
+    chat_id BIGINT PRIMARY KEY,
+    data TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE subscriptions ADD COLUMN cabin_classes TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE subscriptions ADD COLUMN max_price REAL NOT NULL DEFAULT 0;
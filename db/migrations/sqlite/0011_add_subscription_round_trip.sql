@@ -0,0 +1,3 @@
+ALTER TABLE subscriptions ADD COLUMN round_trip_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN return_leg BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE subscriptions ADD COLUMN both_legs BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1 @@
+ALTER TABLE subscriptions ADD COLUMN via_station_id INTEGER NOT NULL DEFAULT 0;
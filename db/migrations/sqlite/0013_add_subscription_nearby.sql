@@ -0,0 +1 @@
+ALTER TABLE subscriptions ADD COLUMN nearby BOOLEAN NOT NULL DEFAULT FALSE;
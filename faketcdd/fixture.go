@@ -0,0 +1,137 @@
+package faketcdd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"tcddbot/model"
+)
+
+// Fixture scripts the responses of the fake server.
+//
+//	{
+//	  "routes": [{
+//	    "departureStationId": 98, "arrivalStationId": 1323, "date": "28-12-2024",
+//	    "steps": [
+//	      {"after": "0s",  "response": "../test.json", "seats": {"EKONOMİ": 0}},
+//	      {"after": "30s", "response": "../test.json", "seats": {"EKONOMİ": 3}, "delay": "2s"},
+//	      {"after": "1m",  "response": "../test.json", "seats": {"EKONOMİ": 3}, "prices": {"EKONOMİ": 450}},
+//	      {"after": "2m",  "errorCode": 604}
+//	    ]
+//	  }]
+//	}
+type Fixture struct {
+	Routes []Route `json:"routes"`
+}
+
+// Route is the timeline served for one station pair. Station IDs of 0 and an
+// empty Date act as wildcards; the first matching route wins.
+type Route struct {
+	DepartureStationID int    `json:"departureStationId"`
+	ArrivalStationID   int    `json:"arrivalStationId"`
+	Date               string `json:"date"`
+	Steps              []Step `json:"steps"`
+}
+
+// Step is the behavior of a route from After (measured from server start or
+// the last reset) until the next step begins.
+type Step struct {
+	After     Duration `json:"after"`
+	Delay     Duration `json:"delay"`     // Sleep before answering
+	Status    int      `json:"status"`    // HTTP status, defaults to 200 or 400 for error codes
+	ErrorCode int      `json:"errorCode"` // Answer with a TCDD ErrorResponse carrying this code
+	Response  string   `json:"response"`  // Captured availability response, relative to the fixture file
+
+	// Seats overrides the availability of the named cabin classes on every
+	// train. Classes that are not listed keep their captured counts.
+	Seats map[string]int `json:"seats"`
+
+	// Prices overrides the fare of the named cabin classes on every train.
+	Prices map[string]float64 `json:"prices"`
+
+	response *model.TCDDResponse
+}
+
+// Duration is a time.Duration written as "1m30s" in fixtures.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadFixture reads a fixture file and the responses it refers to.
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read fixture: %w", err)
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("parse fixture: %w", err)
+	}
+
+	if err := fixture.load(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	return &fixture, nil
+}
+
+// load resolves the Response files of every step relative to baseDir.
+func (f *Fixture) load(baseDir string) error {
+	cache := make(map[string]*model.TCDDResponse)
+
+	for i := range f.Routes {
+		for j := range f.Routes[i].Steps {
+			step := &f.Routes[i].Steps[j]
+			if step.Response == "" || step.response != nil {
+				continue
+			}
+
+			path := step.Response
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDir, path)
+			}
+
+			if cached, ok := cache[path]; ok {
+				step.response = cached
+				continue
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("read response %s: %w", step.Response, err)
+			}
+			var response model.TCDDResponse
+			if err := json.Unmarshal(data, &response); err != nil {
+				return fmt.Errorf("parse response %s: %w", step.Response, err)
+			}
+			cache[path] = &response
+			step.response = &response
+		}
+	}
+	return nil
+}
+
+// WithResponse attaches an already decoded response to the step, for
+// fixtures built in code.
+func (s Step) WithResponse(response *model.TCDDResponse) Step {
+	s.response = response
+	return s
+}
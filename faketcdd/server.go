@@ -0,0 +1,343 @@
+// Package faketcdd is a stand-in for the TCDD train-availability API that
+// serves scripted fixtures, for local development and integration tests.
+package faketcdd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"tcddbot/model"
+)
+
+const (
+	AvailabilityPath = "/tms/train/train-availability"
+	ResetPath        = "/_fake/reset"
+
+	dateLayout    = "02-01-2006"
+	segmentLayout = "2006-01-02T15:04:05"
+)
+
+// Istanbul is UTC+3 all year, the API sends departure dates as the previous
+// day 21:00 UTC.
+const istanbulOffset = 3 * time.Hour
+
+type routeKey struct {
+	departure, arrival int
+	date               string
+}
+
+// Server serves the availability endpoint from a Fixture.
+type Server struct {
+	fixture *Fixture
+	token   string
+	now     func() time.Time
+
+	mu         sync.Mutex
+	start      time.Time
+	hits       map[routeKey]int
+	passengers map[routeKey]model.Passengers
+}
+
+func New(fixture *Fixture) *Server {
+	return &Server{
+		fixture: fixture,
+		now:     time.Now,
+		start:   time.Now(),
+		hits:    make(map[routeKey]int),
+
+		passengers: make(map[routeKey]model.Passengers),
+	}
+}
+
+// RequireToken makes the server answer 401 unless requests carry token in
+// the Authorization header.
+func (s *Server) RequireToken(token string) {
+	s.token = token
+}
+
+// Reset restarts every route timeline and clears the hit counters.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start = s.now()
+	s.hits = make(map[routeKey]int)
+	s.passengers = make(map[routeKey]model.Passengers)
+}
+
+// Advance moves every route timeline forward by d, so tests reach later
+// steps without waiting for them.
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start = s.start.Add(-d)
+}
+
+// Hits returns how many availability requests were made for a route and
+// travel date (GG-AA-YYYY).
+func (s *Server) Hits(departureID, arrivalID int, date string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[routeKey{departureID, arrivalID, date}]
+}
+
+// Passengers returns the passengerTypeCounts of the last request for a route
+// and travel date (GG-AA-YYYY).
+func (s *Server) Passengers(departureID, arrivalID int, date string) model.Passengers {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.passengers[routeKey{departureID, arrivalID, date}]
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == ResetPath && r.Method == http.MethodPost:
+		s.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == AvailabilityPath && r.Method == http.MethodPost:
+		s.serveAvailability(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+type availabilityRequest struct {
+	SearchRoutes []struct {
+		DepartureStationID int    `json:"departureStationId"`
+		ArrivalStationID   int    `json:"arrivalStationId"`
+		DepartureDate      string `json:"departureDate"`
+	} `json:"searchRoutes"`
+	PassengerTypeCounts model.Passengers `json:"passengerTypeCounts"`
+}
+
+func (s *Server) serveAvailability(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" && r.Header.Get("Authorization") != s.token {
+		writeError(w, http.StatusUnauthorized, 401, "Unauthorized")
+		return
+	}
+
+	var req availabilityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.SearchRoutes) == 0 {
+		writeError(w, http.StatusBadRequest, 400, "Geçersiz istek")
+		return
+	}
+
+	route := req.SearchRoutes[0]
+	departureDate, err := time.Parse(dateLayout+" 15:04:05", route.DepartureDate)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 400, "Geçersiz tarih")
+		return
+	}
+	key := routeKey{
+		departure: route.DepartureStationID,
+		arrival:   route.ArrivalStationID,
+		date:      departureDate.Add(istanbulOffset).Format(dateLayout),
+	}
+
+	s.mu.Lock()
+	s.hits[key]++
+	s.passengers[key] = req.PassengerTypeCounts
+	elapsed := s.now().Sub(s.start)
+	s.mu.Unlock()
+
+	step := s.fixture.step(key, elapsed)
+	log.Printf("fakeTCDD: %d -> %d on %s after %s", key.departure, key.arrival, key.date, elapsed.Round(time.Second))
+
+	if step == nil {
+		writeError(w, http.StatusBadRequest, 604, "Sefer bulunamamıştır.")
+		return
+	}
+
+	if step.Delay > 0 {
+		select {
+		case <-time.After(time.Duration(step.Delay)):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if step.ErrorCode != 0 || step.response == nil {
+		status := step.Status
+		if status == 0 {
+			status = http.StatusBadRequest
+		}
+		code := step.ErrorCode
+		if code == 0 {
+			code = status
+		}
+		writeError(w, status, code, http.StatusText(status))
+		return
+	}
+
+	response := step.render(key.date)
+	w.Header().Set("Content-Type", "application/json")
+	if step.Status != 0 {
+		w.WriteHeader(step.Status)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// step returns the step active for key after elapsed, or nil if the route is
+// not scripted.
+func (f *Fixture) step(key routeKey, elapsed time.Duration) *Step {
+	for i := range f.Routes {
+		route := &f.Routes[i]
+		if route.DepartureStationID != 0 && route.DepartureStationID != key.departure {
+			continue
+		}
+		if route.ArrivalStationID != 0 && route.ArrivalStationID != key.arrival {
+			continue
+		}
+		if route.Date != "" && route.Date != key.date {
+			continue
+		}
+
+		var active *Step
+		for j := range route.Steps {
+			if time.Duration(route.Steps[j].After) <= elapsed {
+				active = &route.Steps[j]
+			}
+		}
+		return active
+	}
+	return nil
+}
+
+// render copies the step response, applies seat overrides and moves every
+// train onto the requested travel date.
+func (s *Step) render(date string) *model.TCDDResponse {
+	var response model.TCDDResponse
+	data, _ := json.Marshal(s.response)
+	json.Unmarshal(data, &response)
+
+	travelDate, _ := time.Parse(dateLayout, date)
+	var shift time.Duration
+	shiftKnown := false
+
+	for i := range response.TrainLegs {
+		for j := range response.TrainLegs[i].TrainAvailabilities {
+			trains := response.TrainLegs[i].TrainAvailabilities[j].Trains
+			for k := range trains {
+				train := &trains[k]
+				if !shiftKnown && len(train.TrainSegments) > 0 {
+					if departure, err := time.Parse(segmentLayout, train.TrainSegments[0].DepartureTime); err == nil {
+						capturedDate := departure.Add(istanbulOffset).Truncate(24 * time.Hour)
+						shift = travelDate.Sub(capturedDate)
+						shiftKnown = true
+					}
+				}
+				shiftTrain(train, shift)
+				applySeats(train, s.Seats)
+				applyPrices(train, s.Prices)
+			}
+		}
+	}
+	return &response
+}
+
+func shiftTrain(train *model.Trains, shift time.Duration) {
+	if shift == 0 {
+		return
+	}
+	for i := range train.TrainSegments {
+		segment := &train.TrainSegments[i]
+		if t, err := time.Parse(segmentLayout, segment.DepartureTime); err == nil {
+			segment.DepartureTime = t.Add(shift).Format(segmentLayout)
+		}
+		if t, err := time.Parse(segmentLayout, segment.ArrivalTime); err == nil {
+			segment.ArrivalTime = t.Add(shift).Format(segmentLayout)
+		}
+	}
+	for i := range train.Segments {
+		train.Segments[i].DepartureTime += shift.Milliseconds()
+		train.Segments[i].ArrivalTime += shift.Milliseconds()
+	}
+	train.TrainDate += shift.Milliseconds()
+}
+
+func applySeats(train *model.Trains, seats map[string]int) {
+	for name, count := range seats {
+		found := false
+		for i := range train.CabinClassAvailabilities {
+			if train.CabinClassAvailabilities[i].CabinClass.Name == name {
+				train.CabinClassAvailabilities[i].AvailabilityCount = count
+				found = true
+			}
+		}
+
+		for i := range train.AvailableFareInfo {
+			for j := range train.AvailableFareInfo[i].CabinClasses {
+				cabin := &train.AvailableFareInfo[i].CabinClasses[j]
+				if cabin.CabinClass.Name != name {
+					continue
+				}
+				cabin.AvailabilityCount = count
+				for k := range cabin.BookingClassAvailabilities {
+					cabin.BookingClassAvailabilities[k].Availability = count
+				}
+				if !found && count > 0 {
+					train.CabinClassAvailabilities = append(train.CabinClassAvailabilities, model.CabinClassAvailabilities{
+						CabinClass:        cabin.CabinClass,
+						AvailabilityCount: count,
+					})
+					found = true
+				}
+			}
+		}
+	}
+}
+
+func applyPrices(train *model.Trains, prices map[string]float64) {
+	for name, price := range prices {
+		cabinID := 0
+		for i := range train.AvailableFareInfo {
+			for j := range train.AvailableFareInfo[i].CabinClasses {
+				cabin := &train.AvailableFareInfo[i].CabinClasses[j]
+				if cabin.CabinClass.Name != name {
+					continue
+				}
+				cabinID = cabin.CabinClass.ID
+				cabin.MinPrice = price
+				for k := range cabin.BookingClassAvailabilities {
+					cabin.BookingClassAvailabilities[k].Price = price
+				}
+			}
+		}
+
+		for i := range train.Cars {
+			for j := range train.Cars[i].Availabilities {
+				availability := &train.Cars[i].Availabilities[j]
+				for k := range availability.PricingList {
+					pricing := &availability.PricingList[k]
+					if availability.CabinClass.Name != name && (cabinID == 0 || pricing.CabinClassID != cabinID) {
+						continue
+					}
+					pricing.FareBasis.Price.PriceAmount = price
+					pricing.CrudePrice.PriceAmount = price
+				}
+			}
+		}
+	}
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	traceID := make([]byte, 8)
+	rand.Read(traceID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"timestamp": time.Now().Format(time.RFC3339),
+		"traceId":   hex.EncodeToString(traceID),
+		"status":    http.StatusText(status),
+		"type":      "about:blank",
+		"code":      code,
+		"message":   message,
+		"detail":    message,
+		"title":     http.StatusText(status),
+	})
+}
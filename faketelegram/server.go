@@ -0,0 +1,409 @@
+// Package faketelegram is an in-process stand-in for the Telegram Bot API.
+// It records everything the bot sends and lets tests script user messages
+// and inline button presses.
+package faketelegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// BotUsername is the username reported by getMe.
+const BotUsername = "tcdd_test_bot"
+
+// Button is an inline keyboard button attached to a bot message.
+type Button struct {
+	Text string
+	Data string
+}
+
+// Message is a message sent by the bot, with edits applied.
+type Message struct {
+	ChatID    int64
+	MessageID int
+	Text      string
+	ParseMode string
+	Buttons   [][]Button
+	Edited    bool
+	Photo     []byte // Image of a sendPhoto message, Text is its caption
+}
+
+// HasButton reports whether the message has a button whose label contains text.
+func (m Message) HasButton(text string) bool {
+	_, ok := m.Button(text)
+	return ok
+}
+
+// Button returns the first button whose label contains text.
+func (m Message) Button(text string) (Button, bool) {
+	for _, row := range m.Buttons {
+		for _, button := range row {
+			if strings.Contains(button.Text, text) {
+				return button, true
+			}
+		}
+	}
+	return Button{}, false
+}
+
+// Event is one call the bot made against the API, in the order received.
+type Event struct {
+	Method     string // sendMessage, sendPhoto, editMessageText or answerCallbackQuery
+	Message    Message
+	CallbackID string
+	Text       string // Callback answer text
+}
+
+// Server implements the subset of the Bot API used by the bot: getMe,
+// getUpdates, sendMessage, sendPhoto, editMessageText and answerCallbackQuery.
+type Server struct {
+	mu            sync.Mutex
+	updates       []tgbotapi.Update
+	nextUpdateID  int
+	nextMessageID int
+	nextCallback  int
+	messages      map[int64][]*Message
+	events        []Event
+	newUpdate     chan struct{}
+}
+
+func New() *Server {
+	return &Server{
+		nextUpdateID:  1,
+		nextMessageID: 1,
+		messages:      make(map[int64][]*Message),
+		newUpdate:     make(chan struct{}),
+	}
+}
+
+// Endpoint returns the format string expected by
+// tgbotapi.NewBotAPIWithAPIEndpoint for a server listening on baseURL.
+func Endpoint(baseURL string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/bot%s/%s"
+}
+
+// TextUpdate builds an update for a text message sent by the user chatID.
+// Messages starting with "/" carry a bot_command entity like real ones.
+func (s *Server) TextUpdate(chatID int64, text string) tgbotapi.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := &tgbotapi.Message{
+		MessageID: s.nextMessageID,
+		From:      user(chatID),
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+	s.nextMessageID++
+
+	if strings.HasPrefix(text, "/") {
+		command := strings.SplitN(text, " ", 2)[0]
+		message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	}
+
+	return s.newUpdateLocked(tgbotapi.Update{Message: message})
+}
+
+// CallbackUpdate builds an update for the user pressing the button with the
+// given callback data on a bot message.
+func (s *Server) CallbackUpdate(msg Message, data string) tgbotapi.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextCallback++
+	return s.newUpdateLocked(tgbotapi.Update{
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:   strconv.Itoa(s.nextCallback),
+			From: user(msg.ChatID),
+			Message: &tgbotapi.Message{
+				MessageID: msg.MessageID,
+				From:      &tgbotapi.User{ID: 1, IsBot: true, UserName: BotUsername},
+				Chat:      &tgbotapi.Chat{ID: msg.ChatID, Type: "private"},
+				Text:      msg.Text,
+			},
+			Data: data,
+		},
+	})
+}
+
+func (s *Server) newUpdateLocked(update tgbotapi.Update) tgbotapi.Update {
+	update.UpdateID = s.nextUpdateID
+	s.nextUpdateID++
+	return update
+}
+
+// Enqueue makes update available to getUpdates.
+func (s *Server) Enqueue(update tgbotapi.Update) {
+	s.mu.Lock()
+	s.updates = append(s.updates, update)
+	close(s.newUpdate)
+	s.newUpdate = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// Messages returns the current state of every message the bot sent to chatID.
+func (s *Server) Messages(chatID int64) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make([]Message, 0, len(s.messages[chatID]))
+	for _, msg := range s.messages[chatID] {
+		messages = append(messages, *msg)
+	}
+	return messages
+}
+
+// Events returns the API calls made by the bot, starting at index from.
+func (s *Server) Events(from int) []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if from >= len(s.events) {
+		return nil
+	}
+	return append([]Event(nil), s.events[from:]...)
+}
+
+// EventCount returns the number of API calls recorded so far.
+func (s *Server) EventCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.events)
+}
+
+type apiResponse struct {
+	Ok          bool        `json:"ok"`
+	Result      interface{} `json:"result,omitempty"`
+	ErrorCode   int         `json:"error_code,omitempty"`
+	Description string      `json:"description,omitempty"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Paths look like /bot<token>/<method>
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "bot") {
+		http.NotFound(w, r)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeResponse(w, apiResponse{ErrorCode: 400, Description: err.Error()})
+		return
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+	switch parts[1] {
+	case "getMe":
+		result = tgbotapi.User{ID: 1, IsBot: true, FirstName: "TCDD", UserName: BotUsername}
+	case "getUpdates":
+		result = s.getUpdates(r)
+	case "sendMessage":
+		result, err = s.sendMessage(r)
+	case "sendPhoto":
+		result, err = s.sendPhoto(r)
+	case "editMessageText":
+		result, err = s.editMessageText(r)
+	case "answerCallbackQuery":
+		s.record(Event{Method: "answerCallbackQuery", CallbackID: r.Form.Get("callback_query_id"), Text: r.Form.Get("text")})
+		result = true
+	default:
+		result = true
+	}
+
+	if err != nil {
+		writeResponse(w, apiResponse{ErrorCode: 400, Description: "Bad Request: " + err.Error()})
+		return
+	}
+	writeResponse(w, apiResponse{Ok: true, Result: result})
+}
+
+func (s *Server) getUpdates(r *http.Request) []tgbotapi.Update {
+	offset, _ := strconv.Atoi(r.Form.Get("offset"))
+	timeout, _ := strconv.Atoi(r.Form.Get("timeout"))
+	deadline := time.After(time.Duration(timeout) * time.Second)
+
+	for {
+		s.mu.Lock()
+		pending := []tgbotapi.Update{}
+		for _, update := range s.updates {
+			if update.UpdateID >= offset {
+				pending = append(pending, update)
+			}
+		}
+		wait := s.newUpdate
+		s.mu.Unlock()
+
+		if len(pending) > 0 || timeout == 0 {
+			return pending
+		}
+
+		select {
+		case <-wait:
+		case <-deadline:
+			return nil
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (s *Server) sendMessage(r *http.Request) (*tgbotapi.Message, error) {
+	chatID, err := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("chat_id: %w", err)
+	}
+	if r.Form.Get("text") == "" {
+		return nil, fmt.Errorf("message text is empty")
+	}
+	buttons, err := parseKeyboard(r.Form.Get("reply_markup"))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	msg := &Message{
+		ChatID:    chatID,
+		MessageID: s.nextMessageID,
+		Text:      r.Form.Get("text"),
+		ParseMode: r.Form.Get("parse_mode"),
+		Buttons:   buttons,
+	}
+	s.nextMessageID++
+	s.messages[chatID] = append(s.messages[chatID], msg)
+	s.events = append(s.events, Event{Method: "sendMessage", Message: *msg})
+	s.mu.Unlock()
+
+	return apiMessage(msg), nil
+}
+
+func (s *Server) sendPhoto(r *http.Request) (*tgbotapi.Message, error) {
+	// Uploads are multipart, which ParseForm leaves alone
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		return nil, fmt.Errorf("multipart form: %w", err)
+	}
+	chatID, err := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("chat_id: %w", err)
+	}
+	file, _, err := r.FormFile("photo")
+	if err != nil {
+		return nil, fmt.Errorf("photo: %w", err)
+	}
+	defer file.Close()
+	photo, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("photo: %w", err)
+	}
+
+	s.mu.Lock()
+	msg := &Message{
+		ChatID:    chatID,
+		MessageID: s.nextMessageID,
+		Text:      r.FormValue("caption"),
+		ParseMode: r.FormValue("parse_mode"),
+		Photo:     photo,
+	}
+	s.nextMessageID++
+	s.messages[chatID] = append(s.messages[chatID], msg)
+	s.events = append(s.events, Event{Method: "sendPhoto", Message: *msg})
+	s.mu.Unlock()
+
+	return apiMessage(msg), nil
+}
+
+func (s *Server) editMessageText(r *http.Request) (*tgbotapi.Message, error) {
+	chatID, err := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("chat_id: %w", err)
+	}
+	messageID, err := strconv.Atoi(r.Form.Get("message_id"))
+	if err != nil {
+		return nil, fmt.Errorf("message_id: %w", err)
+	}
+	buttons, err := parseKeyboard(r.Form.Get("reply_markup"))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, msg := range s.messages[chatID] {
+		if msg.MessageID != messageID {
+			continue
+		}
+		msg.Text = r.Form.Get("text")
+		msg.ParseMode = r.Form.Get("parse_mode")
+		msg.Buttons = buttons
+		msg.Edited = true
+		s.events = append(s.events, Event{Method: "editMessageText", Message: *msg})
+		return apiMessage(msg), nil
+	}
+	return nil, fmt.Errorf("message to edit not found")
+}
+
+func (s *Server) record(event Event) {
+	s.mu.Lock()
+	s.events = append(s.events, event)
+	s.mu.Unlock()
+}
+
+func parseKeyboard(markup string) ([][]Button, error) {
+	if markup == "" {
+		return nil, nil
+	}
+
+	var keyboard tgbotapi.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(markup), &keyboard); err != nil {
+		return nil, fmt.Errorf("reply_markup: %w", err)
+	}
+
+	var buttons [][]Button
+	for _, row := range keyboard.InlineKeyboard {
+		var buttonRow []Button
+		for _, button := range row {
+			b := Button{Text: button.Text}
+			if button.CallbackData != nil {
+				b.Data = *button.CallbackData
+			}
+			buttonRow = append(buttonRow, b)
+		}
+		buttons = append(buttons, buttonRow)
+	}
+	return buttons, nil
+}
+
+func apiMessage(msg *Message) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: msg.MessageID,
+		From:      &tgbotapi.User{ID: 1, IsBot: true, UserName: BotUsername},
+		Chat:      &tgbotapi.Chat{ID: msg.ChatID, Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Text:      msg.Text,
+	}
+}
+
+func user(chatID int64) *tgbotapi.User {
+	return &tgbotapi.User{
+		ID:        chatID,
+		FirstName: "Test",
+		LastName:  strconv.FormatInt(chatID, 10),
+		UserName:  "user" + strconv.FormatInt(chatID, 10),
+	}
+}
+
+func writeResponse(w http.ResponseWriter, resp apiResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
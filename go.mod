@@ -7,6 +7,8 @@ toolchain go1.22.4
 require (
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.10.9
+	golang.org/x/image v0.18.0
 	modernc.org/sqlite v1.34.4
 )
 
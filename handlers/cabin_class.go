@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"tcddbot/messenger"
+	"tcddbot/util"
+)
+
+const msgSelectCabinClass = "💺 *Vagon Sınıfı Seçimi*\n\n" +
+	"Hangi sınıflarda yer açıldığında haber almak istersiniz?\n" +
+	"• Birden fazla sınıf seçebilirsiniz\n" +
+	"• Seçim yapmazsanız tüm sınıflar takip edilir"
+
+// cabinClassKeyboard lists the cabin classes sold on the chosen route with
+// the selected ones ticked.
+func cabinClassKeyboard(state *UserState) [][]messenger.Button {
+	selected := make(map[string]bool)
+	for _, code := range state.CabinClasses {
+		selected[code] = true
+	}
+
+	var keyboard [][]messenger.Button
+	for _, cabinClass := range state.CabinOptions {
+		mark := "▫️"
+		if selected[cabinClass.Code] {
+			mark = "✅"
+		}
+		keyboard = append(keyboard, []messenger.Button{
+			{Text: mark + " " + cabinClass.Name, Data: CallbackCabinPrefix + cabinClass.Code},
+		})
+	}
+
+	return append(keyboard, []messenger.Button{
+		{Text: "Tüm Sınıflar", Data: CallbackCabinAll},
+		{Text: "Devam ➡️", Data: CallbackCabinDone},
+	})
+}
+
+// cabinClassNames returns the display names of the selected cabin classes.
+func cabinClassNames(state *UserState) string {
+	if len(state.CabinClasses) == 0 {
+		return "Tüm sınıflar"
+	}
+
+	var names []string
+	for _, cabinClass := range state.CabinOptions {
+		for _, code := range state.CabinClasses {
+			if code == cabinClass.Code {
+				names = append(names, cabinClass.Name)
+			}
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// askCabinClass lets the user narrow the cabin classes down to what TCDD
+// sells on the route, or skips to the price step if that is unknown.
+func (h *Handler) askCabinClass(ctx context.Context, chatID int64, state *UserState) {
+	response := h.wizardAvailability(ctx, state)
+	if response == nil {
+		h.askMaxPrice(chatID, state)
+		return
+	}
+
+	options := util.CabinClasses(response.TrainLegs)
+	if len(options) == 0 {
+		h.askMaxPrice(chatID, state)
+		return
+	}
+
+	h.statesMux.Lock()
+	state.State = StateSelectCabinClass
+	state.CabinOptions = options
+	state.CabinClasses = nil
+	keyboard := cabinClassKeyboard(state)
+	h.statesMux.Unlock()
+
+	h.msgr.SendWithButtons(chatID, msgSelectCabinClass, messenger.Markdown, keyboard)
+}
+
+// handleCabinClassSelection toggles a cabin class or finishes the step
+func (h *Handler) handleCabinClassSelection(ctx context.Context, callback messenger.Callback) {
+	chatID := callback.ChatID
+
+	h.statesMux.Lock()
+	state := h.userStates[chatID]
+	if state == nil || state.State != StateSelectCabinClass {
+		h.statesMux.Unlock()
+		h.msgr.AnswerCallback(callback.ID, msgStaleSelection)
+		return
+	}
+
+	done := false
+	switch callback.Data {
+	case CallbackCabinAll:
+		state.CabinClasses = nil
+		done = true
+	case CallbackCabinDone:
+		done = true
+	default:
+		code := strings.TrimPrefix(callback.Data, CallbackCabinPrefix)
+		toggled := state.CabinClasses[:0:0]
+		for _, selected := range state.CabinClasses {
+			if selected != code {
+				toggled = append(toggled, selected)
+			}
+		}
+		if len(toggled) == len(state.CabinClasses) {
+			toggled = append(toggled, code)
+		}
+		state.CabinClasses = toggled
+	}
+	keyboard := cabinClassKeyboard(state)
+	summary := cabinClassNames(state)
+	h.statesMux.Unlock()
+
+	h.msgr.AnswerCallback(callback.ID, "")
+	if !done {
+		h.msgr.Edit(chatID, callback.MessageID, msgSelectCabinClass, messenger.Markdown, keyboard)
+		return
+	}
+
+	h.msgr.Edit(chatID, callback.MessageID, "💺 *Takip Edilecek Sınıflar:* "+summary, messenger.Markdown, nil)
+	h.askMaxPrice(chatID, state)
+}
@@ -0,0 +1,198 @@
+package handlers_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tcddbot/faketcdd"
+	"tcddbot/handlers/handlertest"
+	"tcddbot/store"
+	"tcddbot/util"
+)
+
+// loadFixture reads a fixture of the fake TCDD server by name.
+func loadFixture(t *testing.T, name string) *faketcdd.Fixture {
+	t.Helper()
+	fixture, err := faketcdd.LoadFixture(filepath.Join("..", "faketcdd", "fixtures", name))
+	if err != nil {
+		t.Fatalf("load fixture: %v", err)
+	}
+	return fixture
+}
+
+// tomorrow returns the date of tomorrow in Istanbul as GG-AA-YYYY.
+func tomorrow() string {
+	return time.Now().In(util.Istanbul).AddDate(0, 0, 1).Format(store.TravelDateLayout)
+}
+
+// subscribe stores a subscription without going through the wizard.
+func subscribe(t *testing.T, h *handlertest.Harness, chatID int64, departureID, arrivalID int, date string) store.Subscription {
+	t.Helper()
+	sub := store.Subscription{ChatID: chatID, DepartureStationID: departureID, ArrivalStationID: arrivalID, TravelDate: date}
+	if err := h.Store.Subscriptions.Create(context.Background(), &sub); err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	return sub
+}
+
+func TestCheckNotifiesWhenSeatsAppear(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	date := tomorrow()
+	subscribe(t, h, 42, 98, 1323, date)
+	user := h.User(42)
+
+	// Sold out at first
+	user.Watch()
+	h.RunChecks()
+	user.ExpectNoReply()
+	if hits := h.TCDD.Hits(98, 1323, date); hits != 1 {
+		t.Fatalf("TCDD hits after the first check: got %d, want 1", hits)
+	}
+	if subs := h.ActiveSubscriptions(42); len(subs) != 1 || subs[0].Notified {
+		t.Fatalf("subscription after a sold out check: %+v", subs)
+	}
+
+	// Economy seats appear a minute in
+	h.TCDD.Advance(time.Minute)
+	user.Watch()
+	h.RunChecks()
+	user.ExpectReply("BİLETİ BULUNDU")
+	// A YHT seat settles the subscription
+	if subs := h.ActiveSubscriptions(42); len(subs) != 0 {
+		t.Fatalf("subscription after a YHT notification is still active: %+v", subs)
+	}
+
+	user.Watch()
+	h.RunChecks()
+	user.ExpectNoReply()
+}
+
+func TestCheckWithoutTrains(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	date := tomorrow()
+	subscribe(t, h, 42, 98, 1323, date)
+	user := h.User(42)
+
+	// The route answers 604 five minutes in, a day without trains is no error
+	h.TCDD.Advance(5 * time.Minute)
+	user.Watch()
+	h.RunChecks()
+	user.ExpectNoReply()
+	if hits := h.TCDD.Hits(98, 1323, date); hits != 1 {
+		t.Fatalf("TCDD hits: got %d, want 1", hits)
+	}
+}
+
+func TestCheckUpstreamFailure(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	subscribe(t, h, 42, 1323, 98, tomorrow())
+	user := h.User(42)
+
+	// The way back fails with 503 for the first two minutes
+	user.Watch()
+	if err := h.Handler.CheckNow(context.Background()); err == nil {
+		t.Fatalf("check during an outage: got no error")
+	}
+	user.ExpectNoReply()
+
+	h.TCDD.Advance(2 * time.Minute)
+	user.Watch()
+	if err := h.Handler.CheckNow(context.Background()); err != nil {
+		t.Fatalf("check after the outage: %v", err)
+	}
+	user.ExpectReply("BİLETİ BULUNDU")
+}
+
+func TestCheckSkipsDatesNotOnSale(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	date := time.Now().In(util.Istanbul).AddDate(0, 0, h.Config.SaleHorizonDays+5).Format(store.TravelDateLayout)
+	subscribe(t, h, 42, 98, 1323, date)
+	user := h.User(42)
+
+	user.Watch()
+	h.RunChecks()
+	user.ExpectNoReply()
+	if hits := h.TCDD.Hits(98, 1323, date); hits != 0 {
+		t.Fatalf("requests for a day not on sale: got %d, want 0", hits)
+	}
+	if subs := h.ActiveSubscriptions(42); len(subs) != 1 {
+		t.Fatalf("active subscriptions: got %d, want 1", len(subs))
+	}
+}
+
+func TestCheckNotifiesRangeOncePerTick(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "price_drop.json"))
+	start := time.Now().In(util.Istanbul).AddDate(0, 0, 1)
+	sub := store.Subscription{
+		ChatID: 42, DepartureStationID: 98, ArrivalStationID: 1323,
+		TravelDate:    start.Format(store.TravelDateLayout),
+		TravelDateEnd: start.AddDate(0, 0, 2).Format(store.TravelDateLayout),
+	}
+	if err := h.Store.Subscriptions.Create(context.Background(), &sub); err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	user := h.User(42)
+
+	// Every day of the range has seats, the hourly notification names one
+	user.Watch()
+	h.RunChecks()
+	if replies := user.Replies(); len(replies) != 1 {
+		t.Fatalf("notifications: got %d, want 1", len(replies))
+	}
+	user.ExpectReply("Konvansiyonel tren bulundu")
+
+	user.Watch()
+	h.RunChecks()
+	user.ExpectNoReply()
+}
+
+func TestCheckNearbyYHTKeepsSubscription(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "nearby.json"))
+	date := tomorrow()
+	sub := store.Subscription{ChatID: 42, DepartureStationID: 98, ArrivalStationID: 1323, TravelDate: date, Nearby: true}
+	if err := h.Store.Subscriptions.Create(context.Background(), &sub); err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	user := h.User(42)
+
+	// ANKARA GAR is sold out, a YHT from a sibling station is only reported
+	user.Watch()
+	h.RunChecks()
+	user.ExpectReply("Alternatif kalkış")
+	user.ExpectReply("YHT BİLETİ BULUNDU")
+	if subs := h.ActiveSubscriptions(42); len(subs) != 1 || !subs[0].Notified {
+		t.Fatalf("subscription after a nearby YHT: %+v, want it active and notified", subs)
+	}
+	if hits := h.TCDD.Hits(98, 1323, date); hits != 1 {
+		t.Fatalf("subscribed route checks: got %d, want 1", hits)
+	}
+}
+
+func TestCheckDropsSatisfiedDaysOver(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	sub := store.Subscription{ChatID: 42, DepartureStationID: 98, ArrivalStationID: 1323,
+		TravelDate: tomorrow(), Recurring: true, Weeks: 1, KeepWatching: true,
+		SatisfiedDates: []string{"01-01-2024", "02-01-2024"}}
+	if err := h.Store.Subscriptions.Create(context.Background(), &sub); err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	user := h.User(42)
+
+	// A YHT settles the first day, the days long over are dropped with it
+	h.TCDD.Advance(time.Minute)
+	user.Watch()
+	h.RunChecks()
+	user.ExpectReply("YHT BİLETİ BULUNDU")
+	got, err := h.Store.Subscriptions.Get(context.Background(), sub.ID)
+	if err != nil {
+		t.Fatalf("get subscription: %v", err)
+	}
+	if len(got.SatisfiedDates) != 1 || got.SatisfiedDates[0] != tomorrow() {
+		t.Fatalf("satisfied dates: got %v, want only %s", got.SatisfiedDates, tomorrow())
+	}
+	if subs := h.ActiveSubscriptions(42); len(subs) != 1 {
+		t.Fatalf("active subscriptions: got %d, want the series kept", len(subs))
+	}
+}
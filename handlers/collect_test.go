@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"tcddbot/config"
+	"tcddbot/model"
+	"tcddbot/store"
+	"tcddbot/worker"
+)
+
+// collect stores subs and returns the jobs of one check round.
+func collect(t *testing.T, subs ...store.Subscription) []worker.Job {
+	t.Helper()
+	st := store.NewMemory()
+	for i := range subs {
+		if err := st.Subscriptions.Create(context.Background(), &subs[i]); err != nil {
+			t.Fatalf("create subscription: %v", err)
+		}
+	}
+	h := &Handler{subs: st.Subscriptions, cfg: &config.Config{}}
+
+	jobs, err := h.collectJobs(context.Background())
+	if err != nil {
+		t.Fatalf("collect jobs: %v", err)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Key() < jobs[j].Key() })
+	return jobs
+}
+
+// subscribers returns the chats waiting on job.
+func subscribers(job worker.Job) []int64 {
+	var ids []int64
+	for _, sub := range job.Subscribers {
+		ids = append(ids, sub.ChatID)
+	}
+	return ids
+}
+
+func TestCollectJobsCoalescesSameSearch(t *testing.T) {
+	date := "01-06-2030"
+	jobs := collect(t,
+		store.Subscription{ChatID: 1, DepartureStationID: 98, ArrivalStationID: 1323, TravelDate: date},
+		store.Subscription{ChatID: 2, DepartureStationID: 98, ArrivalStationID: 1323, TravelDate: date},
+		// A single adult spelled out is the default group
+		store.Subscription{ChatID: 3, DepartureStationID: 98, ArrivalStationID: 1323, TravelDate: date, Passengers: model.SingleAdult},
+	)
+
+	if len(jobs) != 1 {
+		t.Fatalf("jobs: got %d, want 1", len(jobs))
+	}
+	if got := subscribers(jobs[0]); len(got) != 3 {
+		t.Fatalf("subscribers: got %v, want chats 1, 2 and 3", got)
+	}
+}
+
+func TestCollectJobsKeepsDifferentSearchesApart(t *testing.T) {
+	date := "01-06-2030"
+	couple := model.Passengers{{ID: model.PassengerAdult, Count: 2}}
+	tests := []struct {
+		name  string
+		other store.Subscription
+	}{
+		{"passengers", store.Subscription{TravelDate: date, Passengers: couple}},
+		{"date", store.Subscription{TravelDate: "02-06-2030"}},
+		{"direction", store.Subscription{TravelDate: date, DepartureStationID: 1323, ArrivalStationID: 98}},
+		{"connection", store.Subscription{TravelDate: date, ViaStationID: 93}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := tt.other
+			other.ChatID = 2
+			if other.DepartureStationID == 0 {
+				other.DepartureStationID, other.ArrivalStationID = 98, 1323
+			}
+			jobs := collect(t, store.Subscription{ChatID: 1, DepartureStationID: 98, ArrivalStationID: 1323, TravelDate: date}, other)
+
+			if len(jobs) != 2 {
+				t.Fatalf("jobs: got %d, want 2", len(jobs))
+			}
+			for _, job := range jobs {
+				if len(job.Subscribers) != 1 {
+					t.Fatalf("job %s: got subscribers %v, want 1", job.Key(), subscribers(job))
+				}
+			}
+		})
+	}
+}
+
+func TestCollectJobsKeepsFiltersPerSubscriber(t *testing.T) {
+	date := "01-06-2030"
+	jobs := collect(t,
+		store.Subscription{ChatID: 1, DepartureStationID: 98, ArrivalStationID: 1323, TravelDate: date,
+			CabinClasses: []string{"C"}, EarliestDeparture: "07:00", LatestDeparture: "09:00"},
+		store.Subscription{ChatID: 2, DepartureStationID: 98, ArrivalStationID: 1323, TravelDate: date, MaxPrice: 500},
+	)
+
+	// One search serves both, each subscriber is matched with its own filter
+	if len(jobs) != 1 || len(jobs[0].Subscribers) != 2 {
+		t.Fatalf("jobs: got %v, want one job with both subscribers", jobs)
+	}
+	for _, sub := range jobs[0].Subscribers {
+		f := sub.Filter
+		switch sub.ChatID {
+		case 1:
+			if len(f.CabinClasses) != 1 || f.CabinClasses[0] != "C" || f.EarliestDeparture != "07:00" || f.LatestDeparture != "09:00" || f.MaxPrice != 0 {
+				t.Errorf("filter of chat 1: got %+v", f)
+			}
+		case 2:
+			if len(f.CabinClasses) != 0 || f.EarliestDeparture != "" || f.LatestDeparture != "" || f.MaxPrice != 500 {
+				t.Errorf("filter of chat 2: got %+v", f)
+			}
+		}
+	}
+}
@@ -1,19 +1,49 @@
 package handlers
 
+import (
+	"tcddbot/model"
+	"time"
+)
+
 const (
 	CommandStart             = "start"
 	CommandHelp              = "help"
 	CommandSearchStation     = "istasyonara"
 	CommandSubscribe         = "abone"
 	CommandListSubscriptions = "aboneliklerim"
+	CommandPriceHistory      = "fiyatgecmisi"
+	CommandStatus            = "durum" // Admin only
+	CommandCancel            = "iptal"
 	CancelSubscriptionPrefix = "cancel_subscription_"
+	PriceHistoryPrefix       = "price_history_"
+	PriceChartPrefix         = "price_chart_"
+	PauseMenuPrefix          = "pause_menu_"
+	PauseWeekPrefix          = "pause_week_"
+	NearbyPrefix             = "nearby_"
 )
 
 type SubscriptionInfo struct {
-	ID               int64
-	DepartureStation string
-	ArrivalStation   string
-	TravelDate       string
+	ID                int64
+	DepartureStation  string
+	ArrivalStation    string
+	TravelDate        string
+	TravelDateEnd     string
+	Weekdays          []time.Weekday
+	KeepWatching      bool
+	Recurring         bool
+	PausedWeeks       []string
+	RoundTripID       int64
+	ReturnLeg         bool
+	ReturnDate        string // Set on the outbound leg while the return leg is active too
+	BothLegs          bool
+	ViaStation        string
+	Nearby            bool
+	NearbyStations    string // Siblings the subscription could also watch, empty if none
+	CabinClasses      []string
+	EarliestDeparture string
+	LatestDeparture   string
+	Passengers        model.Passengers
+	MaxPrice          float64
 }
 
 var CommandDescriptions = map[string]string{
@@ -25,6 +55,8 @@ var CommandDescriptions = map[string]string{
 		"📋 *Takip Listesi*\n" +
 		"   • /aboneliklerim ile takiplerinizi yönetin\n" +
 		"   • Tek tıkla takibi sonlandırın\n\n" +
+		"📈 *Fiyat Geçmişi*\n" +
+		"   • /fiyatgecmisi ile fiyatların nasıl değiştiğini görün\n\n" +
 		"❓ Detaylı bilgi için /help yazabilirsiniz",
 
 	CommandHelp: "📋 *Detaylı Komut Rehberi*\n\n" +
@@ -32,13 +64,30 @@ var CommandDescriptions = map[string]string{
 		"   • İstasyon adı yazarak arama yapın\n" +
 		"   • Kalkış ve varış istasyonlarını seçin\n" +
 		"   • Tarih seçimini kolayca yapın\n" +
+		"   • Tek bir gün yerine tarih aralığı ve haftanın belirli günlerini takip edin\n" +
+		"   • Her hafta aynı günlerde yolculuk ediyorsanız 🔁 Her Hafta ile tekrarlayan takip kurun\n" +
+		"   • Direkt sefer yoksa aktarma istasyonu seçerek aktarmalı yolculuk takip edin 🔀\n" +
+		"   • ↔️ Gidiş-Dönüş ile iki yönü birlikte takip edin, isterseniz yalnızca iki yönde de yer olunca haber alın\n" +
+		"   • Yolcu sayısını belirleyin (şimdilik yalnızca yetişkin)\n" +
+		"   • İsterseniz kalkış saati aralığı belirleyin (örn: 07:00-09:30)\n" +
+		"   • İsterseniz vagon sınıflarını seçin (Ekonomi, Business, Loca...)\n" +
+		"   • Kişi başı fiyat sınırı koyun, sınırın altındaki biletler bildirilsin\n" +
 		"   • YHT bulunduğunda anında haberdar olun\n\n" +
 		"*2. Takip Listesi* (/aboneliklerim)\n" +
 		"   • Tüm aktif takiplerinizi görüntüleyin\n" +
-		"   • İstemediğiniz takibi tek tıkla durdurun\n\n" +
+		"   • İstemediğiniz takibi tek tıkla durdurun\n" +
+		"   • Tekrarlayan takiplerde belirli bir haftayı duraklatın ⏸\n" +
+		"   • 📍 Aynı şehirdeki diğer istasyonları da izleyin, örn: BOSTANCI yerine PENDİK'te yer varsa haber alın\n\n" +
+		"*3. Fiyat Geçmişi* (/fiyatgecmisi)\n" +
+		"   • Takip ettiğiniz seferlerin fiyat değişimini görün\n" +
+		"   • İsterseniz grafik olarak alın 📈\n\n" +
+		"*4. İptal* (/iptal)\n" +
+		"   • Yarım kalan takip oluşturmayı iptal edin\n" +
+		"   • Uzun süre işlem yapılmazsa yarım kalan takip kendiliğinden iptal edilir ⌛\n\n" +
 		"*Önemli Bilgiler:*\n" +
 		"   • YHT bulunduğunda anında bildirim 🔔\n" +
 		"   • Diğer trenler için saatlik kontrol ⏰\n" +
+		"   • Fiyat düştüğünde ayrıca bildirim 📉\n" +
 		"   • Otomatik geçmiş takip temizleme 🧹",
 
 	CommandSearchStation: "🔍 *İstasyon Arama*\n\n" +
@@ -62,6 +111,12 @@ var CommandDescriptions = map[string]string{
 		"• ❌ İstemediğiniz takipleri durdurun\n" +
 		"• 🕒 Takip detaylarını kontrol edin\n\n" +
 		"💡 Takipleriniz otomatik olarak güncel tutulur",
+
+	CommandPriceHistory: "📈 *Fiyat Geçmişi*\n\n" +
+		"*Bu komut ile:*\n" +
+		"• 🎫 Takip ettiğiniz seferlerin fiyat değişimini görün\n" +
+		"• 📉 En düşük fiyatın seyrini grafik olarak alın\n\n" +
+		"💡 Fiyatlar her kontrolde kaydedilir",
 }
 
 const (
@@ -83,4 +138,30 @@ const (
 		"• 🔍 İstasyon adları için /istasyonara kullanın\n" +
 		"• 📅 Tarih formatı: GG-AA-YYYY\n" +
 		"• ℹ️ Tire (-) işaretlerini unutmayın"
+
+	// msgStaleSelection answers a button of a wizard that is over or was restarted
+	msgStaleSelection = "Bu seçim artık geçerli değil. /abone ile yeniden başlayın."
+
+	// TCDD API hata mesajları
+	MsgNoServiceOnDate = "Bu tarih için henüz sefer bulunmamaktadır. Lütfen daha sonra tekrar deneyiniz."
+
+	MsgSalesNotOpen = "🕒 *Satışlar Henüz Açılmadı*\n\n" +
+		"Bu tarih için bilet satışı henüz başlamadı.\n" +
+		"📱 Satış açılıp koltuk bulunduğunda size haber vereceğim."
+
+	MsgUpstreamUnauthorized = "⚠️ *TCDD Erişim Hatası*\n\n" +
+		"TCDD sistemi şu an sorgularımızı kabul etmiyor.\n" +
+		"📱 Takibiniz oluşturulacak, sorun giderildiğinde kontrol edilecek."
+
+	MsgUpstreamThrottled = "⏳ *TCDD Sistemi Yoğun*\n\n" +
+		"Şu an anlık kontrol yapılamadı.\n" +
+		"📱 Takibiniz oluşturulacak ve kısa süre içinde tekrar kontrol edilecek."
+
+	MsgUpstreamUnavailable = "🔌 *TCDD Sistemine Ulaşılamıyor*\n\n" +
+		"TCDD sistemi şu an yanıt vermiyor.\n" +
+		"📱 Takibiniz oluşturulacak, sistem tekrar erişilebilir olduğunda kontrol edilecek."
+
+	MsgMalformedResponse = "⚠️ *Beklenmeyen Yanıt*\n\n" +
+		"TCDD sisteminden anlaşılamayan bir yanıt alındı.\n" +
+		"📱 Takibiniz oluşturulacak ve tekrar kontrol edilecek."
 )
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"tcddbot/messenger"
+	"tcddbot/model"
+	"tcddbot/service"
+	"tcddbot/store"
+	"tcddbot/util"
+	"tcddbot/worker"
+	"time"
+)
+
+// nextDayAfter is the time of day in Istanbul after which a train reaching the
+// station to change at, plus the transfer, may connect to a train of the
+// next day. The second leg of the next day is only searched then.
+const nextDayAfter = 22 * time.Hour
+
+const msgSelectVia = "🔀 *Aktarmalı Yolculuk*\n\n" +
+	"Bu istasyonlar arasında direkt sefer bulunmamaktadır.\n" +
+	"Aşağıdaki istasyonlardan birinde tren değiştirerek gidebilirsiniz, aktarma istasyonunu seçin:"
+
+// connectionStations returns the stations a journey can change trains at,
+// busiest first. Hubs such as ESKİŞEHİR or KONYA pair with the most stations
+// and usually have the most trains to connect.
+func (h *Handler) connectionStations(departureID, arrivalID int) []Station {
+	h.stationsMux.RLock()
+	defer h.stationsMux.RUnlock()
+
+	var departure Station
+	byID := make(map[int]Station, len(h.stations))
+	for _, station := range h.stations {
+		byID[station.ID] = station
+		if station.ID == departureID {
+			departure = station
+		}
+	}
+
+	var vias []Station
+	for _, pairID := range departure.PairIDs {
+		via, ok := byID[pairID]
+		if !ok || via.ID == arrivalID {
+			continue
+		}
+		for _, next := range via.PairIDs {
+			if next == arrivalID {
+				vias = append(vias, via)
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(vias, func(i, j int) bool { return len(vias[i].PairIDs) > len(vias[j].PairIDs) })
+	if len(vias) > MaxStationsPerPage {
+		vias = vias[:MaxStationsPerPage]
+	}
+	return vias
+}
+
+// askVia offers the stations to change trains at
+func (h *Handler) askVia(chatID int64, vias []Station) {
+	var keyboard [][]messenger.Button
+	for _, via := range vias {
+		keyboard = append(keyboard, []messenger.Button{
+			{Text: "🔀 " + via.Name + " üzerinden", Data: CallbackViaPrefix + strconv.Itoa(via.ID)},
+		})
+	}
+	h.msgr.SendWithButtons(chatID, msgSelectVia, messenger.Markdown, keyboard)
+}
+
+// handleViaSelection stores the station to change trains at and continues
+// with the date
+func (h *Handler) handleViaSelection(callback messenger.Callback) {
+	chatID := callback.ChatID
+
+	h.statesMux.Lock()
+	state := h.userStates[chatID]
+	if state == nil || state.State != StateSelectVia {
+		h.statesMux.Unlock()
+		h.msgr.AnswerCallback(callback.ID, msgStaleSelection)
+		return
+	}
+	state.ViaStation = strings.TrimPrefix(callback.Data, CallbackViaPrefix)
+	state.State = StateSelectDate
+	h.statesMux.Unlock()
+
+	viaID, _ := strconv.Atoi(state.ViaStation)
+	via, _ := h.routeNames(viaID, 0)
+	h.msgr.AnswerCallback(callback.ID, "")
+	h.msgr.Edit(chatID, callback.MessageID, "🔀 "+via+" üzerinden aktarmalı\n\nLütfen tarih seçin:", messenger.Plain, dateKeyboard())
+}
+
+// processConnection checks both legs of a connecting journey and reports the
+// subscribers whose trains line up
+func (h *Handler) processConnection(ctx context.Context, job worker.Job) error {
+	due := false
+	for _, sub := range job.Subscribers {
+		if h.isDue(sub) {
+			due = true
+		}
+	}
+	if !due {
+		return nil
+	}
+
+	first, err := h.checkLeg(ctx, job.DepartureStation, job.ViaStation, job.TravelDate, job)
+	if first == nil {
+		return err
+	}
+	second, err := h.checkLeg(ctx, job.ViaStation, job.ArrivalStation, job.TravelDate, job)
+	if second == nil {
+		return err
+	}
+	h.scheduler.Observe(job, util.TotalSeats(first.TrainLegs)+util.TotalSeats(second.TrainLegs))
+
+	var errs []error
+	secondLegs := second.TrainLegs
+	if date, late := h.nextDayLeg(job, first); late {
+		nextDay, err := h.checkLeg(ctx, job.ViaStation, job.ArrivalStation, date, job)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if nextDay != nil {
+			secondLegs = append(append([]model.TrainLegs(nil), secondLegs...), nextDay.TrainLegs...)
+		}
+	}
+
+	for _, sub := range job.Subscribers {
+		if !h.isDue(sub) {
+			continue
+		}
+		connections := util.FindConnections(first.TrainLegs, secondLegs, sub.Filter, h.cfg.MinTransfer)
+		if len(connections) == 0 {
+			continue
+		}
+		if err := h.notifyConnections(ctx, job, sub, connections); err != nil {
+			errs = append(errs, fmt.Errorf("subscription %d: %w", sub.SubscriptionID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// nextDayLeg returns the day after the travel date when a train of the first
+// leg reaches the station to change at too late to rule out a connection
+// after midnight.
+func (h *Handler) nextDayLeg(job worker.Job, first *model.TCDDResponse) (string, bool) {
+	travelDate, err := time.ParseInLocation(store.TravelDateLayout, job.TravelDate, util.Istanbul)
+	if err != nil {
+		return "", false
+	}
+	arrival := util.LatestArrival(first.TrainLegs)
+	if arrival.IsZero() || arrival.Add(h.cfg.MinTransfer).Before(travelDate.Add(nextDayAfter)) {
+		return "", false
+	}
+	return travelDate.AddDate(0, 0, 1).Format(store.TravelDateLayout), true
+}
+
+// checkLeg checks one leg of a connection on date. The response is nil when
+// there is nothing to report.
+func (h *Handler) checkLeg(ctx context.Context, departureID, arrivalID int, date string, job worker.Job) (*model.TCDDResponse, error) {
+	response, err := h.trainSvc.CheckAvailability(ctx, departureID, arrivalID, date, job.Passengers)
+	if err != nil {
+		if errors.Is(err, service.ErrNoServiceOnDate) || errors.Is(err, service.ErrSalesNotOpen) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("check leg %d-%d on %s: %w", departureID, arrivalID, date, err)
+	}
+	h.recordPrices(ctx, departureID, arrivalID, date, response)
+	return response, nil
+}
+
+// notifyConnections reports connecting journeys the way notifySubscriber
+// reports trains: a journey on YHTs both ways settles the subscription,
+// others are repeated hourly.
+func (h *Handler) notifyConnections(ctx context.Context, job worker.Job, sub worker.Subscriber, connections []util.Connection) error {
+	var matchedDate string
+	if sub.DateRange {
+		matchedDate = job.TravelDate
+	}
+
+	// Another date of the subscription may have notified already
+	if !h.claimNotification(sub) {
+		return nil
+	}
+
+	for _, connection := range connections {
+		if connection.IsYHT() {
+			if err := h.msgr.SendText(sub.ChatID, h.connectionMessage(job, matchedDate, []util.Connection{connection}), messenger.Markdown); err != nil {
+				h.releaseNotification(sub)
+				return fmt.Errorf("notify YHT connection: %w", err)
+			}
+			if sub.KeepWatching {
+				return h.satisfyDate(ctx, sub.SubscriptionID, job.TravelDate)
+			}
+			return h.subs.Deactivate(ctx, sub.SubscriptionID)
+		}
+	}
+
+	if err := h.msgr.SendText(sub.ChatID, h.connectionMessage(job, matchedDate, connections), messenger.Markdown); err != nil {
+		h.releaseNotification(sub)
+		return fmt.Errorf("notify connection: %w", err)
+	}
+	if err := h.subs.MarkNotified(ctx, sub.SubscriptionID); err != nil {
+		return fmt.Errorf("update last notification: %w", err)
+	}
+	return nil
+}
+
+// connectionMessage lists connecting journeys, one block per journey
+func (h *Handler) connectionMessage(job worker.Job, matchedDate string, connections []util.Connection) string {
+	departure, arrival := h.routeNames(job.DepartureStation, job.ArrivalStation)
+	via, _ := h.routeNames(job.ViaStation, 0)
+
+	var msg strings.Builder
+	if len(connections) == 1 && connections[0].IsYHT() {
+		msg.WriteString("🔀 *AKTARMALI YHT YOLCULUĞU BULUNDU!*\n\n")
+	} else {
+		msg.WriteString("🔀 Aktarmalı yolculuk bulundu\n\n")
+	}
+	msg.WriteString(fmt.Sprintf("🚉 *Güzergah:* %s → %s → %s\n", departure, via, arrival))
+	if date, err := time.Parse(store.TravelDateLayout, matchedDate); err == nil {
+		msg.WriteString(fmt.Sprintf("📅 *Eşleşen Tarih:* %s %s\n", matchedDate, weekdayNames[date.Weekday()]))
+	}
+
+	for _, connection := range connections {
+		msg.WriteString("\n")
+		msg.WriteString("1️⃣ " + formatConnectionLeg(connection.First) + "\n")
+		msg.WriteString(fmt.Sprintf("⏱ %s aktarma: %d dk\n", via, int(connection.Transfer.Minutes())))
+		msg.WriteString("2️⃣ ")
+		// The second train may leave after midnight
+		firstDate := connection.First.DepartureTime.In(util.Istanbul).Format(store.TravelDateLayout)
+		if secondDate := connection.Second.DepartureTime.In(util.Istanbul).Format(store.TravelDateLayout); secondDate != firstDate {
+			msg.WriteString("(" + secondDate + ") ")
+		}
+		msg.WriteString(formatConnectionLeg(connection.Second) + "\n")
+		if fare, ok := connection.Fare(); ok {
+			msg.WriteString("💰 Toplam: " + fare.String() + "\n")
+		}
+	}
+	return msg.String()
+}
+
+// formatConnectionLeg describes one train of a connection on a single line
+func formatConnectionLeg(seat util.SeatAvailability) string {
+	var classes []string
+	for _, cabinClass := range seat.Train.CabinClassAvailabilities {
+		count, ok := seat.AvailableSeats[cabinClass.CabinClass.Name]
+		if !ok {
+			continue
+		}
+		class := fmt.Sprintf("%s: %d koltuk", cabinClass.CabinClass.Name, count)
+		if fare, priced := seat.Fares[cabinClass.CabinClass.Name]; priced {
+			class += " • " + fare.String()
+		}
+		classes = append(classes, class)
+	}
+
+	return fmt.Sprintf("%s → %s %s (%s)\n   🎫 %s",
+		seat.DepartureTime.In(util.Istanbul).Format("15:04"),
+		util.ArrivalTime(seat.Train).In(util.Istanbul).Format("15:04"),
+		seat.Train.Name, seat.Train.Type, strings.Join(classes, ", "))
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"tcddbot/messenger"
+	"tcddbot/model"
+	"tcddbot/service"
+	"tcddbot/store"
+	"time"
+)
+
+const msgWizardCancelled = "❌ Takip oluşturma iptal edildi.\n\n" +
+	"Yeniden başlamak için /abone yazabilirsiniz."
+
+const msgWizardExpired = "⌛ *Takip oluşturma zaman aşımına uğradı*\n\n" +
+	"%d dakikadır işlem yapılmadığı için yarım kalan takip iptal edildi.\n" +
+	"Yeniden başlamak için /abone yazabilirsiniz."
+
+// restoreConversations loads the wizards that were in progress before a
+// restart, so users continue where they left off
+func (h *Handler) restoreConversations(ctx context.Context) error {
+	conversations, err := h.conversations.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	h.statesMux.Lock()
+	defer h.statesMux.Unlock()
+	for _, conversation := range conversations {
+		var state UserState
+		if err := json.Unmarshal([]byte(conversation.Data), &state); err != nil {
+			log.Printf("Error decoding conversation of chat %d: %v", conversation.ChatID, err)
+			continue
+		}
+		state.UpdatedAt = conversation.UpdatedAt
+		state.restored = true
+		h.userStates[conversation.ChatID] = &state
+	}
+	if len(conversations) > 0 {
+		log.Printf("Restored %d conversations", len(conversations))
+	}
+	return nil
+}
+
+// wizardAvailability returns what TCDD sells on the route of the wizard.
+// The answer is not persisted, a wizard restored past the date step asks
+// again once.
+func (h *Handler) wizardAvailability(ctx context.Context, state *UserState) *model.TCDDResponse {
+	h.statesMux.Lock()
+	response := state.Availability
+	refresh := state.restored && response == nil && state.TravelDate != ""
+	state.restored = false
+	h.statesMux.Unlock()
+	if !refresh {
+		return response
+	}
+
+	depID, arrID := state.checkedRoute()
+	ctx = service.WithPriority(ctx, service.PriorityInteractive)
+	response, err := h.trainSvc.CheckAvailability(ctx, depID, arrID, state.checkDate(), state.Passengers)
+	if err != nil {
+		log.Printf("Error checking availability of restored conversation: %v", err)
+		return nil
+	}
+
+	h.statesMux.Lock()
+	state.Availability = response
+	h.statesMux.Unlock()
+	return response
+}
+
+// inConversation reports whether the chat is in the middle of the wizard
+func (h *Handler) inConversation(chatID int64) bool {
+	h.statesMux.RLock()
+	defer h.statesMux.RUnlock()
+	return h.userStates[chatID] != nil
+}
+
+// saveConversation stores the wizard state of a chat after an update. had
+// tells whether the chat was in the wizard before, so chats that never were
+// cost no write.
+func (h *Handler) saveConversation(ctx context.Context, chatID int64, had bool) {
+	h.statesMux.Lock()
+	state := h.userStates[chatID]
+	if state == nil {
+		h.statesMux.Unlock()
+		if had {
+			if err := h.conversations.Delete(ctx, chatID); err != nil {
+				log.Printf("Error deleting conversation of chat %d: %v", chatID, err)
+			}
+		}
+		return
+	}
+	updatedAt := time.Now()
+	state.UpdatedAt = updatedAt
+	data, err := json.Marshal(state)
+	h.statesMux.Unlock()
+	if err != nil {
+		log.Printf("Error encoding conversation of chat %d: %v", chatID, err)
+		return
+	}
+
+	conversation := store.Conversation{ChatID: chatID, Data: string(data), UpdatedAt: updatedAt}
+	if err := h.conversations.Save(ctx, conversation); err != nil {
+		log.Printf("Error saving conversation of chat %d: %v", chatID, err)
+	}
+}
+
+// handleCancelWizard abandons the subscription being built
+func (h *Handler) handleCancelWizard(msg messenger.Message) {
+	h.statesMux.Lock()
+	_, ok := h.userStates[msg.ChatID]
+	delete(h.userStates, msg.ChatID)
+	h.statesMux.Unlock()
+
+	if !ok {
+		h.msgr.SendText(msg.ChatID, "İptal edilecek bir işlem bulunmuyor.", messenger.Plain)
+		return
+	}
+	h.msgr.SendText(msg.ChatID, msgWizardCancelled, messenger.Plain)
+}
+
+// StartConversationExpiry ends wizards nobody touched for cfg.WizardTTL
+// until ctx is done
+func (h *Handler) StartConversationExpiry(ctx context.Context) {
+	if h.cfg.WizardTTL <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(h.cfg.WizardTTL, time.Minute))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.ExpireConversations(ctx, time.Now())
+		}
+	}
+}
+
+// ExpireConversations drops the wizards idle for longer than cfg.WizardTTL
+// at now and lets their users know.
+func (h *Handler) ExpireConversations(ctx context.Context, now time.Time) {
+	var expired []int64
+	h.statesMux.Lock()
+	for chatID, state := range h.userStates {
+		if now.Sub(state.UpdatedAt) >= h.cfg.WizardTTL {
+			expired = append(expired, chatID)
+			delete(h.userStates, chatID)
+		}
+	}
+	h.statesMux.Unlock()
+
+	for _, chatID := range expired {
+		if err := h.conversations.Delete(ctx, chatID); err != nil {
+			log.Printf("Error deleting conversation of chat %d: %v", chatID, err)
+		}
+		h.msgr.SendText(chatID, fmt.Sprintf(msgWizardExpired, int(h.cfg.WizardTTL.Minutes())), messenger.Markdown)
+	}
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"tcddbot/messenger"
+	"tcddbot/store"
+	"time"
+)
+
+const msgSelectDateRange = "📆 *Tarih Aralığı*\n\n" +
+	"Aralıktaki herhangi bir günde yer açılınca haber verilir.\n" +
+	"• Başlangıç ve bitiş tarihini yazın, örn: 20-12-2026 27-12-2026\n" +
+	"• Yıl yazmazsanız en yakın tarih alınır, örn: 20-12 27-12"
+
+const msgSelectWeekdays = "📅 *Gün Seçimi*\n\n" +
+	"Aralıktaki hangi günler takip edilsin?\n" +
+	"• Birden fazla gün seçebilirsiniz\n" +
+	"• Seçim yapmazsanız tüm günler takip edilir"
+
+const msgSelectRangeMode = "🔁 *Eşleşme Sonrası*\n\n" +
+	"Bir günde YHT bulunduğunda takip ne olsun?"
+
+// weekdayNames are indexed by time.Weekday
+var weekdayNames = [...]string{"Pazar", "Pazartesi", "Salı", "Çarşamba", "Perşembe", "Cuma", "Cumartesi"}
+
+// weekdayOrder lists the days the way Turkish calendars do, Monday first
+var weekdayOrder = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// rangeDatePattern matches GG-AA or GG-AA-YYYY, with dots or slashes too
+var rangeDatePattern = regexp.MustCompile(`\d{1,2}[-./]\d{1,2}(?:[-./]\d{4})?`)
+
+// parseDateRange parses two dates such as "20-12-2026 27-12-2026" or
+// "20-12 - 27-12". Dates without a year are the next such day from today.
+func parseDateRange(text string, today time.Time) (start, end time.Time, err error) {
+	matches := rangeDatePattern.FindAllString(text, -1)
+	if len(matches) != 2 {
+		return start, end, errors.New("need a start and an end date")
+	}
+
+	y, m, d := today.Date()
+	today = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+
+	var dates [2]time.Time
+	for i, match := range matches {
+		parts := strings.FieldsFunc(match, func(r rune) bool { return r == '-' || r == '.' || r == '/' })
+		day, _ := strconv.Atoi(parts[0])
+		month, _ := strconv.Atoi(parts[1])
+		year := today.Year()
+		if len(parts) == 3 {
+			year, _ = strconv.Atoi(parts[2])
+		}
+
+		date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+		if date.Day() != day || int(date.Month()) != month {
+			return start, end, fmt.Errorf("invalid date %q", match)
+		}
+		if len(parts) == 2 {
+			// 28-12 03-01 wraps into the next year
+			for date.Before(today) || (i == 1 && date.Before(dates[0])) {
+				date = date.AddDate(1, 0, 0)
+			}
+		}
+		dates[i] = date
+	}
+
+	start, end = dates[0], dates[1]
+	switch {
+	case start.Before(today):
+		return start, end, errors.New("range starts in the past")
+	case end.Before(start):
+		return start, end, errors.New("range ends before it starts")
+	case end.Sub(start) >= MaxDateRangeDays*24*time.Hour:
+		return start, end, fmt.Errorf("range is longer than %d days", MaxDateRangeDays)
+	}
+	return start, end, nil
+}
+
+// formatWeekdays returns the names of the chosen days, Monday first
+func formatWeekdays(weekdays []time.Weekday) string {
+	if len(weekdays) == 0 {
+		return "Tüm günler"
+	}
+
+	var names []string
+	for _, weekday := range weekdayOrder {
+		for _, w := range weekdays {
+			if w == weekday {
+				names = append(names, weekdayNames[weekday])
+			}
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// weekdayPrompt asks for the days of a range or of a weekly series
+func weekdayPrompt(state *UserState) string {
+	if state.Recurring {
+		return msgSelectRecurringWeekdays
+	}
+	return msgSelectWeekdays
+}
+
+// formatTravelDates describes the day or days a subscription watches
+func formatTravelDates(travelDate, travelDateEnd string) string {
+	if travelDateEnd == "" {
+		return travelDate
+	}
+	return travelDate + " – " + travelDateEnd
+}
+
+// isRange reports whether the subscription being built watches more than
+// one day
+func (s *UserState) isRange() bool {
+	return s.TravelDateEnd != "" || s.Recurring
+}
+
+// rangeSubscription returns the dates of the range being built as a
+// subscription, so the wizard counts days like the scheduler does
+func (s *UserState) rangeSubscription() store.Subscription {
+	return store.Subscription{TravelDate: s.TravelDate, TravelDateEnd: s.TravelDateEnd, Weekdays: s.Weekdays,
+		Recurring: s.Recurring, Weeks: s.Weeks}
+}
+
+// checkDate returns the day the wizard checks availability on, the first
+// watched day of a range
+func (s *UserState) checkDate() string {
+	if !s.isRange() {
+		return s.TravelDate
+	}
+	if dates := s.rangeSubscription().Dates(time.Now()); len(dates) > 0 {
+		return dates[0]
+	}
+	return s.TravelDate
+}
+
+// askDateRange switches the date step to a range
+func (h *Handler) askDateRange(callback messenger.Callback) {
+	chatID := callback.ChatID
+
+	h.statesMux.Lock()
+	state := h.userStates[chatID]
+	if state == nil || (state.State != StateSelectDate && state.State != StateSelectDateRange) {
+		h.statesMux.Unlock()
+		h.msgr.AnswerCallback(callback.ID, msgStaleSelection)
+		return
+	}
+	state.State = StateSelectDateRange
+	h.statesMux.Unlock()
+
+	h.msgr.AnswerCallback(callback.ID, "")
+	h.msgr.SendText(chatID, msgSelectDateRange, messenger.Markdown)
+}
+
+// handleDateRangeInput handles the typed range and continues with weekdays
+func (h *Handler) handleDateRangeInput(ctx context.Context, chatID int64, text string) {
+	h.statesMux.RLock()
+	state := h.userStates[chatID]
+	h.statesMux.RUnlock()
+	if state == nil || state.State != StateSelectDateRange {
+		return
+	}
+
+	start, end, err := parseDateRange(text, time.Now())
+	if err != nil {
+		h.msgr.SendText(chatID, fmt.Sprintf("❌ Geçersiz tarih aralığı. Bugünden başlayan ve en fazla %d gün süren "+
+			"bir aralık girin, örn: 20-12-2026 27-12-2026", MaxDateRangeDays), messenger.Plain)
+		return
+	}
+
+	depID, _ := strconv.Atoi(state.DepartureStation)
+	arrID, _ := strconv.Atoi(state.ArrivalStation)
+	startDate := start.Format(store.TravelDateLayout)
+	exists, err := h.subs.ExistsActive(ctx, chatID, depID, arrID, startDate)
+	if err != nil {
+		log.Printf("Error checking existing subscription: %v", err)
+		h.msgr.SendText(chatID, "Bir hata oluştu. Lütfen daha sonra tekrar deneyin.", messenger.Plain)
+		return
+	}
+	if exists {
+		h.msgr.SendText(chatID, "Bu güzergah için zaten bir takibiniz bulunmaktadır.", messenger.Plain)
+		return
+	}
+
+	h.statesMux.Lock()
+	state.clearDates()
+	state.TravelDate = startDate
+	state.TravelDateEnd = end.Format(store.TravelDateLayout)
+	state.State = StateSelectWeekdays
+	keyboard := weekdayKeyboard(state.Weekdays)
+	h.statesMux.Unlock()
+
+	h.msgr.SendText(chatID, "📆 *Tarih Aralığı:* "+formatTravelDates(state.TravelDate, state.TravelDateEnd), messenger.Markdown)
+	h.msgr.SendWithButtons(chatID, msgSelectWeekdays, messenger.Markdown, keyboard)
+}
+
+// weekdayKeyboard shows the days of the week with the selected ones ticked
+func weekdayKeyboard(selected []time.Weekday) [][]messenger.Button {
+	ticked := make(map[time.Weekday]bool)
+	for _, weekday := range selected {
+		ticked[weekday] = true
+	}
+
+	var keyboard [][]messenger.Button
+	var row []messenger.Button
+	for i, weekday := range weekdayOrder {
+		mark := "▫️"
+		if ticked[weekday] {
+			mark = "✅"
+		}
+		row = append(row, messenger.Button{
+			Text: mark + " " + weekdayNames[weekday],
+			Data: CallbackWeekdayPrefix + strconv.Itoa(int(weekday)),
+		})
+		if len(row) == 2 || i == len(weekdayOrder)-1 {
+			keyboard = append(keyboard, row)
+			row = nil
+		}
+	}
+
+	return append(keyboard, []messenger.Button{
+		{Text: "Tüm Günler", Data: CallbackWeekdayAll},
+		{Text: "Devam ➡️", Data: CallbackWeekdayDone},
+	})
+}
+
+// handleWeekdaySelection toggles a weekday or finishes the step
+func (h *Handler) handleWeekdaySelection(ctx context.Context, callback messenger.Callback) {
+	chatID := callback.ChatID
+
+	h.statesMux.Lock()
+	state := h.userStates[chatID]
+	if state == nil || state.State != StateSelectWeekdays {
+		h.statesMux.Unlock()
+		h.msgr.AnswerCallback(callback.ID, msgStaleSelection)
+		return
+	}
+
+	done := false
+	switch callback.Data {
+	case CallbackWeekdayAll:
+		state.Weekdays = nil
+		done = true
+	case CallbackWeekdayDone:
+		done = true
+	default:
+		n, err := strconv.Atoi(strings.TrimPrefix(callback.Data, CallbackWeekdayPrefix))
+		if err != nil || n < 0 || n > 6 {
+			h.statesMux.Unlock()
+			h.msgr.AnswerCallback(callback.ID, "")
+			return
+		}
+		weekday := time.Weekday(n)
+		toggled := state.Weekdays[:0:0]
+		for _, selected := range state.Weekdays {
+			if selected != weekday {
+				toggled = append(toggled, selected)
+			}
+		}
+		if len(toggled) == len(state.Weekdays) {
+			toggled = append(toggled, weekday)
+		}
+		state.Weekdays = toggled
+	}
+
+	if done && len(state.rangeSubscription().Dates(time.Now())) == 0 {
+		h.statesMux.Unlock()
+		h.msgr.AnswerCallback(callback.ID, "Seçtiğiniz günler bu tarih aralığında yok.")
+		return
+	}
+	if done && !state.Recurring {
+		state.State = StateSelectRangeMode
+	}
+	keyboard := weekdayKeyboard(state.Weekdays)
+	prompt := weekdayPrompt(state)
+	summary := formatWeekdays(state.Weekdays)
+	h.statesMux.Unlock()
+
+	h.msgr.AnswerCallback(callback.ID, "")
+	if !done {
+		h.msgr.Edit(chatID, callback.MessageID, prompt, messenger.Markdown, keyboard)
+		return
+	}
+
+	// Every week goes on after a match, there is nothing to ask
+	if state.Recurring {
+		h.msgr.Edit(chatID, callback.MessageID, "🔁 *Takip:* "+formatRecurrence(state.Weekdays), messenger.Markdown, nil)
+		h.askPassengers(chatID, state)
+		return
+	}
+
+	h.msgr.Edit(chatID, callback.MessageID, "📅 *Takip Edilecek Günler:* "+summary, messenger.Markdown, nil)
+	h.msgr.SendWithButtons(chatID, msgSelectRangeMode, messenger.Markdown, [][]messenger.Button{
+		{{Text: "✅ Takibi bitir", Data: CallbackRangeStop}},
+		{{Text: "🔁 Diğer günleri izlemeye devam et", Data: CallbackRangeKeep}},
+	})
+}
+
+// handleRangeMode stores what happens after a match and asks for passengers
+func (h *Handler) handleRangeMode(ctx context.Context, callback messenger.Callback) {
+	chatID := callback.ChatID
+
+	h.statesMux.Lock()
+	state := h.userStates[chatID]
+	if state == nil || state.State != StateSelectRangeMode {
+		h.statesMux.Unlock()
+		h.msgr.AnswerCallback(callback.ID, msgStaleSelection)
+		return
+	}
+	state.KeepWatching = callback.Data == CallbackRangeKeep
+	h.statesMux.Unlock()
+
+	summary := "🔁 *Eşleşme Sonrası:* Takip biter"
+	if state.KeepWatching {
+		summary = "🔁 *Eşleşme Sonrası:* Diğer günler izlenmeye devam eder"
+	}
+	h.msgr.AnswerCallback(callback.ID, "")
+	h.msgr.Edit(chatID, callback.MessageID, summary, messenger.Markdown, nil)
+	h.askPassengers(chatID, state)
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"tcddbot/store"
+)
+
+func TestParseDateRange(t *testing.T) {
+	today := time.Date(2030, 12, 20, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		text       string
+		start, end string // Empty when the text is refused
+	}{
+		{"20-12-2030 27-12-2030", "20-12-2030", "27-12-2030"},
+		{"20-12 - 27-12", "20-12-2030", "27-12-2030"},
+		{"21.12 ile 23/12", "21-12-2030", "23-12-2030"},
+		{"1-1 5-1", "01-01-2031", "05-01-2031"},
+		// A range without years runs into the next year
+		{"28-12 03-01", "28-12-2030", "03-01-2031"},
+		// A day without a year is never in the past
+		{"19-12 21-12", "19-12-2031", "21-12-2031"},
+		{"20-12-2030 19-01-2031", "20-12-2030", "19-01-2031"},
+		{"20-12-2030 20-01-2031", "", ""},
+		{"19-12-2030 21-12-2030", "", ""},
+		{"27-12-2030 20-12-2030", "", ""},
+		{"31-02 03-03", "", ""},
+		{"20-12", "", ""},
+		{"20-12 21-12 22-12", "", ""},
+		{"yarın", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			start, end, err := parseDateRange(tt.text, today)
+			if tt.start == "" {
+				if err == nil {
+					t.Fatalf("got %s – %s, want an error", start.Format(store.TravelDateLayout), end.Format(store.TravelDateLayout))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if got := start.Format(store.TravelDateLayout); got != tt.start {
+				t.Errorf("start: got %s, want %s", got, tt.start)
+			}
+			if got := end.Format(store.TravelDateLayout); got != tt.end {
+				t.Errorf("end: got %s, want %s", got, tt.end)
+			}
+		})
+	}
+}
@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,14 +12,13 @@ import (
 	"strings"
 	"sync"
 	"tcddbot/config"
-	"tcddbot/model"
+	"tcddbot/messenger"
 	"tcddbot/service"
+	"tcddbot/store"
 	"time"
 
 	"tcddbot/util"
 	"tcddbot/worker"
-
-	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 const NOTIFICATION_INTERVAL = 1 * time.Hour
@@ -32,54 +31,74 @@ type Station struct {
 }
 
 type Handler struct {
-	bot         *tgbotapi.BotAPI
-	db          *sql.DB
-	cfg         *config.Config
-	trainSvc    *service.TrainService
-	stations    []Station
-	stationsMux sync.RWMutex
-	workerPool  *worker.Pool
-	userStates  map[int64]*UserState
-	statesMux   sync.RWMutex
-}
-
-func NewHandler(bot *tgbotapi.BotAPI, db *sql.DB, cfg *config.Config) *Handler {
+	msgr          messenger.Messenger
+	subs          store.SubscriptionStore
+	users         store.UserStore
+	prices        store.PriceHistoryStore
+	conversations store.ConversationStore
+	cfg           *config.Config
+	trainSvc      *service.TrainService
+	stations      []Station
+	stationsMux   sync.RWMutex
+	workerPool    *worker.Pool
+	scheduler     *worker.Scheduler
+	userStates    map[int64]*UserState
+	statesMux     sync.RWMutex
+	// notified holds notifications sent after the jobs of a tick read
+	// LastNotified, see claimNotification
+	notified    map[int64]time.Time
+	notifiedMux sync.Mutex
+}
+
+func NewHandler(msgr messenger.Messenger, st *store.Store, cfg *config.Config) *Handler {
 	h := &Handler{
-		bot:        bot,
-		db:         db,
-		cfg:        cfg,
-		trainSvc:   service.NewTrainService(cfg),
-		userStates: make(map[int64]*UserState),
+		msgr:          msgr,
+		subs:          st.Subscriptions,
+		users:         st.Users,
+		prices:        st.Prices,
+		conversations: st.Conversations,
+		cfg:           cfg,
+		trainSvc:      service.NewTrainService(cfg),
+		userStates:    make(map[int64]*UserState),
+		notified:      make(map[int64]time.Time),
 	}
 
 	if err := h.loadStations(); err != nil {
 		log.Printf("Error loading stations: %v", err)
 	}
 
-	if err := h.initializeTables(); err != nil {
-		log.Printf("Error initializing tables: %v", err)
+	if err := h.restoreConversations(context.Background()); err != nil {
+		log.Printf("Error restoring conversations: %v", err)
 	}
 
+	h.trainSvc.Breaker().OnStateChange(h.notifyAdminBreaker)
+
 	// Initialize worker pool with 5 workers and 100 queue size
-	h.workerPool = worker.NewPool(5, 100, h.processSubscription)
+	h.workerPool = worker.NewPool(5, 100, cfg.CheckTimeout, h.processSubscription)
+	h.workerPool.OnError(func(job worker.Job, err error) {
+		log.Printf("Error checking %s: %v", job.Key(), err)
+	})
+	h.scheduler = worker.NewScheduler(cfg.CheckInterval, cfg.CheckBudget, func(job worker.Job) time.Duration {
+		return checkInterval(job, time.Now())
+	})
 
 	return h
 }
 
 func (h *Handler) loadStations() error {
-	file, err := os.Open("./stations.json")
-	if (err != nil) {
+	file, err := os.Open(h.cfg.StationsPath)
+	if err != nil {
 		return fmt.Errorf("error opening stations.json: %w", err)
 	}
 	defer file.Close()
 
 	data, err := io.ReadAll(file)
-	if (err != nil) {
+	if err != nil {
 		return err
 	}
 
 	err = json.Unmarshal(data, &h.stations)
-	if (err != nil) {
+	if err != nil {
 		return err
 	}
 
@@ -87,96 +106,79 @@ func (h *Handler) loadStations() error {
 	return nil
 }
 
-// Add this new method after NewHandler
-func (h *Handler) initializeTables() error {
-    _, err := h.db.Exec(`
-        CREATE TABLE IF NOT EXISTS users (
-            chat_id INTEGER PRIMARY KEY,
-            username TEXT,
-            first_name TEXT,
-            last_name TEXT,
-            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-        )`)
-    return err
-}
-
-// Update HandleUpdate to handle non-command messages
-func (h *Handler) HandleUpdate(ctx context.Context, update tgbotapi.Update) {
-    if update.Message != nil {
-        // Check if this is a new user
-        var count int
-        err := h.db.QueryRow("SELECT COUNT(*) FROM users WHERE chat_id = ?", update.Message.Chat.ID).Scan(&count)
-        if err != nil {
-            log.Printf("Error checking user existence: %v", err)
-        }
-
-        if count == 0 {
-            // New user, save to database and notify admin
-            username := update.Message.From.UserName
-            if username == "" {
-                username = "Ayarlanmamış"
-            }
-            firstName := update.Message.From.FirstName
-            if firstName == "" {
-                firstName = "Ayarlanmamış"
-            }
-            lastName := update.Message.From.LastName
-            if lastName == "" {
-                lastName = "Ayarlanmamış"
-            }
-
-            _, err := h.db.Exec(`
-                INSERT INTO users (chat_id, username, first_name, last_name)
-                VALUES (?, ?, ?, ?)`,
-                update.Message.Chat.ID, username, firstName, lastName)
-            
-            if err != nil {
-                log.Printf("Error saving new user: %v", err)
-            } else {
-                h.notifyAdmin(update.Message.Chat.ID, username, firstName, lastName)
-            }
-        }
-    }
-
-    if update.CallbackQuery != nil {
-        h.handleCallback(ctx, update.CallbackQuery)
-        return
-    }
-
-    if update.Message == nil {
-        return
-    }
-
-    if update.Message.IsCommand() {
-        switch update.Message.Command() {
-        case CommandStart, CommandHelp:
-            h.handleHelp(update)
-        case CommandSearchStation:
-            h.handleStationSearch(update)
-        case CommandSubscribe:
-            h.handleSubscriptionStart(update)
-        case CommandListSubscriptions:
-            h.handleListSubscriptions(ctx, update)
-        }
-        return
-    }
-
-    // Handle non-command messages (station search and date input)
-    h.HandleMessage(update)
-}
-
-func (h *Handler) handleHelp(update tgbotapi.Update) {
-	msg := tgbotapi.NewMessage(update.Message.Chat.ID, CommandDescriptions[update.Message.Command()])
-	msg.ParseMode = "Markdown"
-	h.bot.Send(msg)
-}
-
-func (h *Handler) handleStationSearch(update tgbotapi.Update) {
-	chatID := update.Message.Chat.ID
-	keyword := strings.TrimSpace(update.Message.CommandArguments())
+// HandleMessage handles an incoming text message or command
+func (h *Handler) HandleMessage(ctx context.Context, msg messenger.Message) {
+	// Whatever the message changes in the wizard outlives a restart
+	defer h.saveConversation(ctx, msg.ChatID, h.inConversation(msg.ChatID))
+
+	// Check if this is a new user
+	exists, err := h.users.Exists(ctx, msg.ChatID)
+	if err != nil {
+		log.Printf("Error checking user existence: %v", err)
+	}
+
+	if err == nil && !exists {
+		// New user, save to database and notify admin
+		username := msg.From.UserName
+		if username == "" {
+			username = "Ayarlanmamış"
+		}
+		firstName := msg.From.FirstName
+		if firstName == "" {
+			firstName = "Ayarlanmamış"
+		}
+		lastName := msg.From.LastName
+		if lastName == "" {
+			lastName = "Ayarlanmamış"
+		}
+
+		err := h.users.Create(ctx, store.User{
+			ChatID:    msg.ChatID,
+			UserName:  username,
+			FirstName: firstName,
+			LastName:  lastName,
+		})
+
+		if err != nil {
+			log.Printf("Error saving new user: %v", err)
+		} else {
+			h.notifyAdmin(ctx, msg.ChatID, username, firstName, lastName)
+		}
+	}
+
+	if msg.Command != "" {
+		switch msg.Command {
+		case CommandStart, CommandHelp:
+			h.handleHelp(msg)
+		case CommandSearchStation:
+			h.handleStationSearch(msg)
+		case CommandSubscribe:
+			h.handleSubscriptionStart(msg)
+		case CommandListSubscriptions:
+			h.handleListSubscriptions(ctx, msg)
+		case CommandPriceHistory:
+			h.handlePriceHistoryStart(ctx, msg)
+		case CommandStatus:
+			h.handleStatus(msg)
+		case CommandCancel:
+			h.handleCancelWizard(msg)
+		}
+		return
+	}
+
+	// Handle non-command messages (station search and date input)
+	h.handleText(ctx, msg)
+}
+
+func (h *Handler) handleHelp(msg messenger.Message) {
+	h.msgr.SendText(msg.ChatID, CommandDescriptions[msg.Command], messenger.Markdown)
+}
+
+func (h *Handler) handleStationSearch(msg messenger.Message) {
+	chatID := msg.ChatID
+	keyword := strings.TrimSpace(msg.Arguments)
 	if keyword == "" {
-		msg := tgbotapi.NewMessage(chatID, MsgInvalidStationSearch)
-		h.bot.Send(msg)
+		h.msgr.SendText(chatID, MsgInvalidStationSearch, messenger.Plain)
 		return
 	}
 
@@ -195,326 +197,479 @@ func (h *Handler) handleStationSearch(update tgbotapi.Update) {
 
 	if len(matchingStations) > 0 {
 		var responseText strings.Builder
-        responseText.WriteString("🔍 *Bulunan İstasyonlar:*\n\n")
-        for i, station := range matchingStations {
-            responseText.WriteString(fmt.Sprintf("%d. %s\n", i+1, station))
-        }
-        responseText.WriteString("\n💡 Bu istasyon adlarını takip oluştururken kullanabilirsiniz.")
-        
-        msg := tgbotapi.NewMessage(chatID, responseText.String())
-        msg.ParseMode = "Markdown"
-        h.bot.Send(msg)
+		responseText.WriteString("🔍 *Bulunan İstasyonlar:*\n\n")
+		for i, station := range matchingStations {
+			responseText.WriteString(fmt.Sprintf("%d. %s\n", i+1, station))
+		}
+		responseText.WriteString("\n💡 Bu istasyon adlarını takip oluştururken kullanabilirsiniz.")
+
+		h.msgr.SendText(chatID, responseText.String(), messenger.Markdown)
 	} else {
-		msg := tgbotapi.NewMessage(chatID, "❌ *İstasyon Bulunamadı*\n\n"+
-            "Lütfen farklı bir arama yapın.\n"+
-            "💡 Kısmi kelimeler ile de arama yapabilirsiniz.\n"+
-            "Örnek: 'ist' yazarak İstanbul'daki istasyonları bulabilirsiniz.")
-        msg.ParseMode = "Markdown"
-        h.bot.Send(msg)
+		h.msgr.SendText(chatID, "❌ *İstasyon Bulunamadı*\n\n"+
+			"Lütfen farklı bir arama yapın.\n"+
+			"💡 Kısmi kelimeler ile de arama yapabilirsiniz.\n"+
+			"Örnek: 'ist' yazarak İstanbul'daki istasyonları bulabilirsiniz.", messenger.Markdown)
 	}
 }
 
-func (h *Handler) handleSubscriptionStart(update tgbotapi.Update) {
-	chatID := update.Message.Chat.ID
+func (h *Handler) handleSubscriptionStart(msg messenger.Message) {
+	chatID := msg.ChatID
 
 	h.statesMux.Lock()
 	h.userStates[chatID] = &UserState{
 		State:       StateSelectDeparture,
 		CurrentPage: 0,
+		UpdatedAt:   time.Now(),
 	}
 	h.statesMux.Unlock()
 
-	msg := tgbotapi.NewMessage(chatID, "🔍 *KALKIŞ İstasyonu Seçimi*\n\n"+
-        "*İstasyon adını yazın:*\n"+
-        "• Örnek: ankara, istanbul, izmir\n\n"+
-        "💡 En az 2 karakter girmelisiniz")
-    msg.ParseMode = "Markdown"
-    h.bot.Send(msg)
-}
-
-// Update handleCallback to handle search dummy button
-func (h *Handler) handleCallback(ctx context.Context, callback *tgbotapi.CallbackQuery) {
-    chatID := callback.Message.Chat.ID
-
-    if strings.HasPrefix(callback.Data, "station_") {
-        h.handleStationSelection(callback)
-        return
-    }
-
-    switch callback.Data {
-    case CallbackDateToday:
-        h.handleDateSelection(callback, time.Now())
-    case CallbackDateTomorrow:
-        h.handleDateSelection(callback, time.Now().AddDate(0, 0, 1))
-    case CallbackDateCustom:
-        // Send message asking for custom date input
-        msg := tgbotapi.NewMessage(chatID, "Lütfen tarihi GG-AA-YYYY formatında girin:")
-        h.bot.Send(msg)
-    }
-
-    if strings.HasPrefix(callback.Data, CancelSubscriptionPrefix) {
-        subscriptionID, err := strconv.ParseInt(strings.TrimPrefix(callback.Data, CancelSubscriptionPrefix), 10, 64)
-        if err != nil {
-            log.Printf("Error parsing subscription ID: %v", err)
-            return
-        }
-
-        if err := h.cancelSubscription(ctx, callback.Message.Chat.ID, subscriptionID); err != nil {
-            log.Printf("Error canceling subscription: %v", err)
-            h.bot.Send(tgbotapi.NewCallback(callback.ID, "Abonelik iptal edilirken bir hata oluştu."))
-            return
-        }
-
-        // Update the message to remove the button
-        editMsg := tgbotapi.NewEditMessageText(
-            callback.Message.Chat.ID,
-            callback.Message.MessageID,
-            callback.Message.Text+"\n\n✅ Seçilen abonelik başarıyla iptal edildi.",
-        )
-        h.bot.Send(editMsg)
-        h.bot.Send(tgbotapi.NewCallback(callback.ID, "Abonelik başarıyla iptal edildi."))
-    }
-}
-
-func (h *Handler) handleStationSelection(callback *tgbotapi.CallbackQuery) {
-    chatID := callback.Message.Chat.ID
-    stationID := strings.TrimPrefix(callback.Data, "station_")
-
-    h.statesMux.Lock()
-    state := h.userStates[chatID]
-
-    if state.State == StateSelectDeparture {
-        // Store selected departure station
-        state.DepartureStation = stationID
-        state.State = StateSelectArrival
-        state.CurrentPage = 0
-        h.statesMux.Unlock()
-
-        msg := tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID,
-            "🔍 *VARIŞ İstasyonu Seçimi*\n\n"+
-                "*İstasyon adını yazın:*\n"+
-                "• Örnek: ankara, istanbul, izmir\n\n"+
-                "💡 En az 2 karakter girmelisiniz")
-        msg.ParseMode = "Markdown"
-        h.bot.Send(msg)
-    } else if state.State == StateSelectArrival {
-        // Check if departure and arrival stations are the same
-        if stationID == state.DepartureStation {
-            h.statesMux.Unlock()
-            msg := tgbotapi.NewMessage(chatID, "❌ Kalkış ve varış istasyonları aynı olamaz. Lütfen farklı bir istasyon seçin.")
-            h.bot.Send(msg)
-            return
-        }
-
-        // Check if arrival station is in pair_ids of departure station
-        depID, _ := strconv.Atoi(state.DepartureStation)
-        arrID, _ := strconv.Atoi(stationID)
-        
-        h.stationsMux.RLock()
-        var depStation Station
-        var validPair bool
-        for _, station := range h.stations {
-            if station.ID == depID {
-                depStation = station
-                break
-            }
-        }
-        h.stationsMux.RUnlock()
-
-        // Check if arrival station is in departure station's pair_ids
-        for _, pairID := range depStation.PairIDs {
-            if (pairID == arrID) {
-                validPair = true
-                break
-            }
-        }
-
-        if !validPair {
-            h.statesMux.Unlock()
-            msg := tgbotapi.NewMessage(chatID, "❌ Bu istasyonlar arasında sefer bulunmamaktadır. Lütfen farklı bir istasyon seçin.")
-            h.bot.Send(msg)
-            return
-        }
-
-        // Continue with valid station selection
-        state.ArrivalStation = stationID
-        state.State = StateSelectDate
-        h.statesMux.Unlock()
-
-        // Create date selection keyboard
-        keyboard := [][]tgbotapi.InlineKeyboardButton{
-            {
-                tgbotapi.NewInlineKeyboardButtonData("Bugün", CallbackDateToday),
-                tgbotapi.NewInlineKeyboardButtonData("Yarın", CallbackDateTomorrow),
-            },
-            {
-                tgbotapi.NewInlineKeyboardButtonData("Özel Tarih", CallbackDateCustom),
-            },
-        }
-        markup := tgbotapi.NewInlineKeyboardMarkup(keyboard...)
-        msg := tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID, "Lütfen tarih seçin:")
-        msg.ReplyMarkup = &markup
-        h.bot.Send(msg)
-    }
-}
-
-func (h *Handler) createSubscription(chatID int64, departureStationID, arrivalStationID, travelDate string) {
+	h.msgr.SendText(chatID, "🔍 *KALKIŞ İstasyonu Seçimi*\n\n"+
+		"*İstasyon adını yazın:*\n"+
+		"• Örnek: ankara, istanbul, izmir\n\n"+
+		"💡 En az 2 karakter girmelisiniz\n"+
+		"❌ Vazgeçmek için /iptal yazın", messenger.Markdown)
+}
+
+// HandleCallback handles an inline button press
+func (h *Handler) HandleCallback(ctx context.Context, callback messenger.Callback) {
+	chatID := callback.ChatID
+	defer h.saveConversation(ctx, chatID, h.inConversation(chatID))
+
+	if strings.HasPrefix(callback.Data, "station_") {
+		h.handleStationSelection(callback)
+		return
+	}
+
+	if strings.HasPrefix(callback.Data, CallbackViaPrefix) {
+		h.handleViaSelection(callback)
+		return
+	}
+
+	if strings.HasPrefix(callback.Data, NearbyPrefix) {
+		h.handleNearbyToggle(ctx, callback)
+		return
+	}
+
+	if strings.HasPrefix(callback.Data, PauseMenuPrefix) {
+		h.handlePauseMenu(ctx, callback)
+		return
+	}
+
+	if strings.HasPrefix(callback.Data, PauseWeekPrefix) {
+		h.handlePauseWeek(ctx, callback)
+		return
+	}
+
+	if strings.HasPrefix(callback.Data, PriceHistoryPrefix) || strings.HasPrefix(callback.Data, PriceChartPrefix) {
+		h.handlePriceHistorySelection(ctx, callback)
+		return
+	}
+
+	if callback.Data == CallbackPriceAny {
+		h.handleMaxPriceSkip(ctx, callback)
+		return
+	}
+
+	if strings.HasPrefix(callback.Data, CallbackPassengerPrefix) {
+		h.handlePassengerSelection(ctx, callback)
+		return
+	}
+
+	if strings.HasPrefix(callback.Data, CallbackWeekdayPrefix) {
+		h.handleWeekdaySelection(ctx, callback)
+		return
+	}
+
+	if strings.HasPrefix(callback.Data, CallbackRangePrefix) {
+		h.handleRangeMode(ctx, callback)
+		return
+	}
+
+	if strings.HasPrefix(callback.Data, CallbackRoundTripPrefix) {
+		h.handleRoundTripMode(ctx, callback)
+		return
+	}
+
+	if strings.HasPrefix(callback.Data, CallbackTimePrefix) {
+		h.handleTimeWindowSelection(ctx, callback)
+		return
+	}
+
+	if strings.HasPrefix(callback.Data, CallbackCabinPrefix) ||
+		callback.Data == CallbackCabinAll || callback.Data == CallbackCabinDone {
+		h.handleCabinClassSelection(ctx, callback)
+		return
+	}
+
+	switch callback.Data {
+	case CallbackDateToday:
+		h.handleDateSelection(ctx, chatID, time.Now())
+	case CallbackDateTomorrow:
+		h.handleDateSelection(ctx, chatID, time.Now().AddDate(0, 0, 1))
+	case CallbackDateCustom:
+		// Send message asking for custom date input
+		h.msgr.SendText(chatID, "Lütfen tarihi GG-AA-YYYY formatında girin:", messenger.Plain)
+	case CallbackDateRange:
+		h.askDateRange(callback)
+	case CallbackDateWeekly:
+		h.askRecurring(callback)
+	case CallbackDateRoundTrip:
+		h.askRoundTrip(callback)
+	}
+
+	if strings.HasPrefix(callback.Data, CancelSubscriptionPrefix) {
+		subscriptionID, err := strconv.ParseInt(strings.TrimPrefix(callback.Data, CancelSubscriptionPrefix), 10, 64)
+		if err != nil {
+			log.Printf("Error parsing subscription ID: %v", err)
+			return
+		}
+
+		if err := h.cancelSubscription(ctx, chatID, subscriptionID); err != nil {
+			log.Printf("Error canceling subscription: %v", err)
+			h.msgr.AnswerCallback(callback.ID, "Abonelik iptal edilirken bir hata oluştu.")
+			return
+		}
+
+		// Update the message to remove the button
+		h.msgr.Edit(chatID, callback.MessageID,
+			callback.MessageText+"\n\n✅ Seçilen abonelik başarıyla iptal edildi.", messenger.Plain, nil)
+		h.msgr.AnswerCallback(callback.ID, "Abonelik başarıyla iptal edildi.")
+	}
+}
+
+// stationStep is where a station button leaves the wizard
+type stationStep int
+
+const (
+	stationStepStale stationStep = iota // No wizard waits for a station
+	stationStepArrival
+	stationStepSameStation
+	stationStepNoTrain
+	stationStepVia
+	stationStepDate
+)
+
+func (h *Handler) handleStationSelection(callback messenger.Callback) {
+	chatID := callback.ChatID
+	stationID := strings.TrimPrefix(callback.Data, "station_")
+
+	step, vias := h.selectStation(chatID, stationID)
+	switch step {
+	case stationStepStale:
+		h.msgr.AnswerCallback(callback.ID, msgStaleSelection)
+	case stationStepArrival:
+		h.msgr.Edit(chatID, callback.MessageID,
+			"🔍 *VARIŞ İstasyonu Seçimi*\n\n"+
+				"*İstasyon adını yazın:*\n"+
+				"• Örnek: ankara, istanbul, izmir\n\n"+
+				"💡 En az 2 karakter girmelisiniz", messenger.Markdown, nil)
+	case stationStepSameStation:
+		h.msgr.SendText(chatID, "❌ Kalkış ve varış istasyonları aynı olamaz. Lütfen farklı bir istasyon seçin.", messenger.Plain)
+	case stationStepNoTrain:
+		h.msgr.SendText(chatID, "❌ Bu istasyonlar arasında sefer bulunmamaktadır. Lütfen farklı bir istasyon seçin.", messenger.Plain)
+	case stationStepVia:
+		h.askVia(chatID, vias)
+	case stationStepDate:
+		h.msgr.Edit(chatID, callback.MessageID, "Lütfen tarih seçin:", messenger.Plain, dateKeyboard())
+	}
+}
+
+// selectStation stores the chosen station in the wizard of the chat and
+// tells which step follows. vias lists the stations to change trains at
+// when there is no direct train.
+func (h *Handler) selectStation(chatID int64, stationID string) (step stationStep, vias []Station) {
+	h.statesMux.Lock()
+	defer h.statesMux.Unlock()
+
+	state := h.userStates[chatID]
+	if state == nil {
+		return stationStepStale, nil
+	}
+
+	switch state.State {
+	case StateSelectDeparture:
+		// Store selected departure station
+		state.DepartureStation = stationID
+		state.ViaStation = ""
+		state.State = StateSelectArrival
+		state.CurrentPage = 0
+		return stationStepArrival, nil
+
+	case StateSelectArrival:
+		// Check if departure and arrival stations are the same
+		if stationID == state.DepartureStation {
+			return stationStepSameStation, nil
+		}
+
+		// Check if arrival station is in pair_ids of departure station
+		depID, _ := strconv.Atoi(state.DepartureStation)
+		arrID, _ := strconv.Atoi(stationID)
+
+		h.stationsMux.RLock()
+		var depStation Station
+		var validPair bool
+		for _, station := range h.stations {
+			if station.ID == depID {
+				depStation = station
+				break
+			}
+		}
+		h.stationsMux.RUnlock()
+
+		// Check if arrival station is in departure station's pair_ids
+		for _, pairID := range depStation.PairIDs {
+			if pairID == arrID {
+				validPair = true
+				break
+			}
+		}
+
+		if !validPair {
+			// Without a direct train the journey may still connect somewhere
+			vias := h.connectionStations(depID, arrID)
+			if len(vias) == 0 {
+				return stationStepNoTrain, nil
+			}
+
+			state.ArrivalStation = stationID
+			state.State = StateSelectVia
+			return stationStepVia, vias
+		}
+
+		// Continue with valid station selection
+		state.ArrivalStation = stationID
+		state.ViaStation = ""
+		state.State = StateSelectDate
+		return stationStepDate, nil
+	}
+	return stationStepStale, nil
+}
+
+// dateKeyboard offers the common travel dates
+func dateKeyboard() [][]messenger.Button {
+	return [][]messenger.Button{
+		{
+			{Text: "Bugün", Data: CallbackDateToday},
+			{Text: "Yarın", Data: CallbackDateTomorrow},
+		},
+		{
+			{Text: "Özel Tarih", Data: CallbackDateCustom},
+			{Text: "📆 Tarih Aralığı", Data: CallbackDateRange},
+		},
+		{
+			{Text: "🔁 Her Hafta", Data: CallbackDateWeekly},
+			{Text: "↔️ Gidiş-Dönüş", Data: CallbackDateRoundTrip},
+		},
+	}
+}
+
+func (h *Handler) createSubscription(ctx context.Context, chatID int64, state *UserState) {
 	// Convert station IDs from string to int
-	depID, _ := strconv.Atoi(departureStationID)
-	arrID, _ := strconv.Atoi(arrivalStationID)
-	
+	depID, _ := strconv.Atoi(state.DepartureStation)
+	arrID, _ := strconv.Atoi(state.ArrivalStation)
+	viaID, _ := strconv.Atoi(state.ViaStation)
+
 	// Create subscription in database
-	_, err := h.db.Exec(
-		`INSERT INTO subscriptions (chat_id, departure_station_id, arrival_station_id, travel_date) 
-		 VALUES (?, ?, ?, ?)`,
-		chatID, depID, arrID, travelDate)
-	
+	err := h.subs.Create(ctx, &store.Subscription{
+		ChatID:             chatID,
+		DepartureStationID: depID,
+		ArrivalStationID:   arrID,
+		TravelDate:         state.TravelDate,
+		TravelDateEnd:      state.TravelDateEnd,
+		Weekdays:           state.Weekdays,
+		KeepWatching:       state.KeepWatching,
+		Recurring:          state.Recurring,
+		Weeks:              state.Weeks,
+		ViaStationID:       viaID,
+		CabinClasses:       state.CabinClasses,
+		EarliestDeparture:  state.EarliestDeparture,
+		LatestDeparture:    state.LatestDeparture,
+		Passengers:         state.Passengers,
+		MaxPrice:           state.MaxPrice,
+	})
+
 	if err != nil {
 		log.Printf("Error creating subscription: %v", err)
-		msg := tgbotapi.NewMessage(chatID, "Abonelik oluşturulurken bir hata oluştu. Lütfen daha sonra tekrar deneyin.")
-		h.bot.Send(msg)
+		h.msgr.SendText(chatID, "Abonelik oluşturulurken bir hata oluştu. Lütfen daha sonra tekrar deneyin.", messenger.Plain)
+		return
+	}
+
+	h.msgr.SendText(chatID, "Aboneliğiniz başarıyla oluşturuldu! Uygun koltuk bulunduğunda size haber vereceğim.", messenger.Plain)
+}
+
+// handleText handles station search and custom date input of the subscription wizard
+func (h *Handler) handleText(ctx context.Context, msg messenger.Message) {
+	chatID := msg.ChatID
+
+	h.statesMux.RLock()
+	state := h.userStates[chatID]
+	h.statesMux.RUnlock()
+
+	if state == nil {
 		return
 	}
 
-	msg := tgbotapi.NewMessage(chatID, "Aboneliğiniz başarıyla oluşturuldu! Uygun koltuk bulunduğunda size haber vereceğim.")
-	h.bot.Send(msg)
-}
-
-// Update HandleMessage for better search handling
-func (h *Handler) HandleMessage(update tgbotapi.Update) {
-    chatID := update.Message.Chat.ID
-
-    h.statesMux.RLock()
-    state := h.userStates[chatID]
-    h.statesMux.RUnlock()
-
-    if state == nil {
-        return
-    }
-
-    if state.State == StateSelectDeparture || state.State == StateSelectArrival {
-        query := strings.TrimSpace(update.Message.Text)
-        if len(query) < 2 {
-            msg := tgbotapi.NewMessage(chatID, "❌ *Çok Kısa Arama*\n\n"+
-                "Lütfen en az 2 karakter girin.\n"+
-                "💡 Örnek: 'ank', 'ist', 'izm' gibi")
-            msg.ParseMode = "Markdown"
-            h.bot.Send(msg)
-            return
-        }
-
-        var matchingStations []Station
-        h.stationsMux.RLock()
-        queryLower := util.ToLowerTurkish(query)
-        
-        // Get departure station if we're in arrival selection state
-        var departureStation Station
-        var validPairs map[int]bool
-        if state.State == StateSelectArrival {
-            depID, _ := strconv.Atoi(state.DepartureStation)
-            for _, station := range h.stations {
-                if station.ID == depID {
-                    departureStation = station
-                    // Create a map of valid pair IDs for quick lookup
-                    validPairs = make(map[int]bool)
-                    for _, pairID := range station.PairIDs {
-                        validPairs[pairID] = true
-                    }
-                    break
-                }
-            }
-        }
-
-        // Filter stations based on search query and valid pairs
-        for _, station := range h.stations {
-            stationNameLower := util.ToLowerTurkish(station.Name)
-            cityNameLower := util.ToLowerTurkish(station.CityName)
-            
-            // For arrival station selection, only include valid pairs
-            if state.State == StateSelectArrival {
-                if station.ID == departureStation.ID {
-                    continue // Skip departure station
-                }
-                if !validPairs[station.ID] {
-                    continue // Skip stations that aren't valid pairs
-                }
-            }
-
-            if strings.Contains(stationNameLower, queryLower) ||
-               strings.Contains(cityNameLower, queryLower) {
-                matchingStations = append(matchingStations, station)
-            }
-        }
-        h.stationsMux.RUnlock()
-
-        if len(matchingStations) == 0 {
-            var msgText string
-            if state.State == StateSelectArrival {
-                msgText = "❌ *Uygun İstasyon Bulunamadı*\n\n" +
-                    "Seçtiğiniz kalkış istasyonundan girdiğiniz konuma sefer bulunmamaktadır.\n" +
-                    "💡 Farklı bir varış noktası deneyin veya /abone yazarak baştan başlayın."
-            } else {
-                msgText = "❌ *İstasyon Bulunamadı*\n\n" +
-                    "Aradığınız kalkış istasyonu bulunamadı.\n" +
-                    "💡 Farklı bir arama yapın veya kısmi kelime kullanın."
-            }
-            msg := tgbotapi.NewMessage(chatID, msgText)
-            msg.ParseMode = "Markdown"
-            h.bot.Send(msg)
-            return
-        }
-
-        var keyboard [][]tgbotapi.InlineKeyboardButton
-        for _, station := range matchingStations {
+	if state.State == StateSelectDeparture || state.State == StateSelectArrival {
+		query := strings.TrimSpace(msg.Text)
+		if len(query) < 2 {
+			h.msgr.SendText(chatID, "❌ *Çok Kısa Arama*\n\n"+
+				"Lütfen en az 2 karakter girin.\n"+
+				"💡 Örnek: 'ank', 'ist', 'izm' gibi", messenger.Markdown)
+			return
+		}
+
+		var matchingStations []Station
+		h.stationsMux.RLock()
+		queryLower := util.ToLowerTurkish(query)
+
+		// Get departure station if we're in arrival selection state
+		var departureStation Station
+		var validPairs, connectingPairs map[int]bool
+		if state.State == StateSelectArrival {
+			depID, _ := strconv.Atoi(state.DepartureStation)
+			for _, station := range h.stations {
+				if station.ID == depID {
+					departureStation = station
+					// Create a map of valid pair IDs for quick lookup
+					validPairs = make(map[int]bool)
+					for _, pairID := range station.PairIDs {
+						validPairs[pairID] = true
+					}
+					break
+				}
+			}
+
+			// Stations one change away are offered as connections
+			connectingPairs = make(map[int]bool)
+			for _, station := range h.stations {
+				if validPairs[station.ID] {
+					for _, pairID := range station.PairIDs {
+						connectingPairs[pairID] = true
+					}
+				}
+			}
+		}
+
+		// Filter stations based on search query and valid pairs
+		for _, station := range h.stations {
+			stationNameLower := util.ToLowerTurkish(station.Name)
+			cityNameLower := util.ToLowerTurkish(station.CityName)
+
+			// For arrival station selection, only include valid pairs
+			if state.State == StateSelectArrival {
+				if station.ID == departureStation.ID {
+					continue // Skip departure station
+				}
+				if !validPairs[station.ID] && !connectingPairs[station.ID] {
+					continue // Skip stations that aren't reachable
+				}
+			}
+
+			if strings.Contains(stationNameLower, queryLower) ||
+				strings.Contains(cityNameLower, queryLower) {
+				matchingStations = append(matchingStations, station)
+			}
+		}
+		h.stationsMux.RUnlock()
+
+		if len(matchingStations) == 0 {
+			var msgText string
+			if state.State == StateSelectArrival {
+				msgText = "❌ *Uygun İstasyon Bulunamadı*\n\n" +
+					"Seçtiğiniz kalkış istasyonundan girdiğiniz konuma sefer bulunmamaktadır.\n" +
+					"💡 Farklı bir varış noktası deneyin veya /abone yazarak baştan başlayın."
+			} else {
+				msgText = "❌ *İstasyon Bulunamadı*\n\n" +
+					"Aradığınız kalkış istasyonu bulunamadı.\n" +
+					"💡 Farklı bir arama yapın veya kısmi kelime kullanın."
+			}
+			h.msgr.SendText(chatID, msgText, messenger.Markdown)
+			return
+		}
+
+		var keyboard [][]messenger.Button
+		for _, station := range matchingStations {
 			displayName := fmt.Sprintf("%s (%s)", station.Name, station.CityName)
-            keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
-                tgbotapi.NewInlineKeyboardButtonData(displayName, "station_"+strconv.Itoa(station.ID)),
-            })
-        }
-
-        var msgText string
-        if state.State == StateSelectDeparture {
-            msgText = fmt.Sprintf("🔍 *'%s' için bulunan KALKIŞ istasyonları:*", query)
-        } else {
-            msgText = fmt.Sprintf("🔍 *'%s' için bulunan VARIŞ istasyonları:*", query)
-        }
-
-        msg := tgbotapi.NewMessage(chatID, msgText)
-        markup := tgbotapi.NewInlineKeyboardMarkup(keyboard...)
-        msg.ReplyMarkup = &markup
-        msg.ParseMode = "Markdown"
-        h.bot.Send(msg)
-        return
-    }
-
-    // Handle custom date input
-    if state.State == StateSelectDate {
-        // Parse custom date
-        date, err := time.Parse("02-01-2006", update.Message.Text)
-        if err != nil {
-            msg := tgbotapi.NewMessage(chatID, "Geçersiz tarih formatı. Lütfen GG-AA-YYYY formatında girin:")
-            h.bot.Send(msg)
-            return
-        }
-
-        h.handleDateSelection(&tgbotapi.CallbackQuery{
-            Message: update.Message,
-        }, date)
-    }
+			if state.State == StateSelectArrival && !validPairs[station.ID] {
+				displayName += " 🔀 aktarmalı"
+			}
+			keyboard = append(keyboard, []messenger.Button{
+				{Text: displayName, Data: "station_" + strconv.Itoa(station.ID)},
+			})
+		}
+
+		var msgText string
+		if state.State == StateSelectDeparture {
+			msgText = fmt.Sprintf("🔍 *'%s' için bulunan KALKIŞ istasyonları:*", query)
+		} else {
+			msgText = fmt.Sprintf("🔍 *'%s' için bulunan VARIŞ istasyonları:*", query)
+		}
+
+		h.msgr.SendWithButtons(chatID, msgText, messenger.Markdown, keyboard)
+		return
+	}
+
+	// Handle custom date input
+	if state.State == StateSelectDate {
+		// Parse custom date
+		date, err := time.Parse("02-01-2006", msg.Text)
+		if err != nil {
+			h.msgr.SendText(chatID, "Geçersiz tarih formatı. Lütfen GG-AA-YYYY formatında girin:", messenger.Plain)
+			return
+		}
+
+		h.handleDateSelection(ctx, chatID, date)
+		return
+	}
+
+	if state.State == StateSelectDateRange {
+		h.handleDateRangeInput(ctx, chatID, msg.Text)
+		return
+	}
+
+	if state.State == StateSelectRoundTripDates {
+		h.handleRoundTripInput(ctx, chatID, msg.Text)
+		return
+	}
+
+	if state.State == StateSelectTimeWindow {
+		h.handleTimeWindowInput(ctx, chatID, msg.Text)
+		return
+	}
+
+	if state.State == StateSelectMaxPrice {
+		h.handleMaxPriceInput(ctx, chatID, msg.Text)
+	}
 }
 
+// StartPeriodicCheck checks subscriptions until ctx is done. The schedule is
+// refreshed from the store every CheckInterval, in between the loop sleeps
+// until the next job is due. Only one instance sharing a store may run it,
+// see db.LockChecks.
 func (h *Handler) StartPeriodicCheck(ctx context.Context) {
 	h.workerPool.Start(ctx)
-	ticker := time.NewTicker(h.cfg.CheckInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
+	var synced time.Time
 	for {
 		select {
 		case <-ctx.Done():
 			h.workerPool.Stop()
 			return
-		case <-ticker.C:
-			h.queueSubscriptionChecks(ctx)
+		case now := <-timer.C:
+			if now.Sub(synced) >= h.cfg.CheckInterval {
+				h.syncSchedule(ctx, now)
+				synced = now
+			}
+			h.queueSubscriptionChecks(now)
+
+			wait := h.cfg.CheckInterval - time.Since(synced)
+			if next := h.scheduler.NextCheck(time.Now()); !next.IsZero() {
+				wait = min(wait, time.Until(next))
+			}
+			// Breathe while the breaker holds due jobs back
+			timer.Reset(max(wait, time.Second))
 		}
 	}
 }
@@ -536,107 +691,379 @@ func (h *Handler) StartCleanup(ctx context.Context) {
 }
 
 func (h *Handler) cleanupOldSubscriptions(ctx context.Context) error {
-	_, err := h.db.ExecContext(ctx, `
-        UPDATE subscriptions 
-        SET deleted_at = CURRENT_TIMESTAMP 
-        WHERE travel_date < DATE('now', '-1 day') 
-        AND deleted_at IS NULL`)
+	count, err := h.subs.DeactivateExpired(ctx, time.Now().AddDate(0, 0, -1))
+	if count > 0 {
+		log.Printf("Cleaned up %d old subscriptions", count)
+	}
+	if err != nil {
+		return err
+	}
+
+	// A return leg waiting for both legs is moot once the outbound is gone
+	count, err = h.deactivateOrphanLegs(ctx)
+	if count > 0 {
+		log.Printf("Cleaned up %d orphaned return legs", count)
+	}
+	if err != nil {
+		return err
+	}
+
+	count, err = h.prices.DeleteExpired(ctx, time.Now().AddDate(0, 0, -1))
+	if count > 0 {
+		log.Printf("Cleaned up %d old price records", count)
+	}
 	return err
 }
 
-func (h *Handler) queueSubscriptionChecks(ctx context.Context) {
-	rows, err := h.db.QueryContext(ctx, `
-        SELECT chat_id, departure_station_id, arrival_station_id, travel_date 
-        FROM subscriptions 
-        WHERE deleted_at IS NULL`)
-	if err != nil {
-		log.Printf("Error querying subscriptions: %v", err)
+func (h *Handler) queueSubscriptionChecks(now time.Time) {
+	// Pause all checks while the circuit breaker considers TCDD down
+	if !h.trainSvc.Breaker().Ready() {
 		return
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var job worker.Job
-		if err := rows.Scan(&job.ChatID, &job.DepartureStation, &job.ArrivalStation, &job.TravelDate); err != nil {
-			log.Printf("Error scanning row: %v", err)
+	// A check still running from an earlier round or a full queue puts the
+	// job off for a moment, a full queue means checks fall behind
+	jobs := h.scheduler.Due(now)
+	dropped := 0
+	for _, job := range jobs {
+		err := h.workerPool.AddJob(job)
+		if err == nil {
 			continue
 		}
-		h.workerPool.AddJob(job)
+		h.scheduler.Retry(job, now)
+		if errors.Is(err, worker.ErrQueueFull) {
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		log.Printf("Worker queue full, put off %d of %d checks", dropped, len(jobs))
 	}
 }
 
-func (h *Handler) processSubscription(ctx context.Context, job worker.Job) error {
-	// Check if we should notify based on last notification time
-	var lastNotified sql.NullTime
-	err := h.db.QueryRowContext(ctx, `
-        SELECT last_notified 
-        FROM subscriptions 
-        WHERE chat_id = ? AND departure_station_id = ? AND arrival_station_id = ? AND travel_date = ? AND deleted_at IS NULL`,
-		job.ChatID, job.DepartureStation, job.ArrivalStation, job.TravelDate).Scan(&lastNotified)
+// CheckNow runs one round of subscription checks synchronously, bypassing
+// the worker pool. Errors of individual checks are joined.
+func (h *Handler) CheckNow(ctx context.Context) error {
+	jobs, err := h.collectJobs(ctx)
+	if err != nil {
+		return fmt.Errorf("query subscriptions: %w", err)
+	}
+
+	var errs []error
+	for _, job := range jobs {
+		if err := h.processSubscription(ctx, job); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// collectJobs groups subscriptions watching the same route and date so each
+// group costs a single upstream request per tick.
+func (h *Handler) collectJobs(ctx context.Context) ([]worker.Job, error) {
+	subs, err := h.subs.ListActive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	alertPrices, err := h.subs.AlertPrices(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("check last notification: %w", err)
+	type routeKey struct {
+		departure, arrival int
+		via                int
+		date               string
+		passengers         string
 	}
+	groups := make(map[routeKey]int)
+	var jobs []worker.Job
+	today := time.Now()
+
+	for _, sub := range subs {
+		passengers := sub.Passengers.OrDefault()
+
+		// The outbound leg checks the way back when both legs are needed
+		if sub.ReturnLeg && sub.BothLegs {
+			continue
+		}
+		routes := h.watchedRoutes(sub)
+
+		// A date range is checked one day at a time, a weekly series only as
+		// far ahead as TCDD sells tickets
+		for _, date := range sub.Dates(today) {
+			if sub.Recurring && !h.withinSaleHorizon(date, today) {
+				break
+			}
+			// Nearby stations are checked as routes of their own
+			for _, r := range routes {
+				key := routeKey{r.departure, r.arrival, sub.ViaStationID, date, passengers.String()}
+
+				i, ok := groups[key]
+				if !ok {
+					i = len(jobs)
+					groups[key] = i
+					jobs = append(jobs, worker.Job{
+						DepartureStation: key.departure,
+						ArrivalStation:   key.arrival,
+						ViaStation:       key.via,
+						TravelDate:       key.date,
+						Passengers:       passengers,
+					})
+				}
+				jobs[i].Subscribers = append(jobs[i].Subscribers, worker.Subscriber{
+					SubscriptionID: sub.ID,
+					ChatID:         sub.ChatID,
+					LastNotified:   sub.LastNotified,
+					AlertPrice:     alertPrices[store.AlertKey{SubscriptionID: sub.ID, TravelDate: date}],
+					DateRange:      sub.IsRange(),
+					KeepWatching:   sub.KeepWatching,
+					RoundTripID:    sub.RoundTripID,
+					ReturnLeg:      sub.ReturnLeg,
+					BothLegs:       sub.BothLegs,
+					Nearby:         r != routes[0],
+					HomeDeparture:  sub.DepartureStationID,
+					HomeArrival:    sub.ArrivalStationID,
+					Filter:         seatFilter(sub),
+				})
+			}
+		}
+	}
+
+	return jobs, nil
+}
 
-	// If last notification was less than an hour ago, skip
-	if lastNotified.Valid && time.Since(lastNotified.Time) < NOTIFICATION_INTERVAL {
+// seatFilter returns the seats a stored subscription is interested in
+func seatFilter(sub store.Subscription) util.SeatFilter {
+	return util.SeatFilter{
+		CabinClasses:      sub.CabinClasses,
+		EarliestDeparture: sub.EarliestDeparture,
+		LatestDeparture:   sub.LatestDeparture,
+		Seats:             sub.Passengers.OrDefault().Total(),
+		MaxPrice:          sub.MaxPrice,
+	}
+}
+
+func (h *Handler) processSubscription(ctx context.Context, job worker.Job) error {
+	if job.ViaStation != 0 {
+		return h.processConnection(ctx, job)
+	}
+
+	// Subscribers whose last notification is older than an hour are due,
+	// the others only while a price drop could be reported to them
+	watched := false
+	for _, sub := range job.Subscribers {
+		if h.isDue(sub) || h.watchesPrice(sub) {
+			watched = true
+		}
+	}
+	if !watched {
 		return nil
 	}
 
-	response, err := h.trainSvc.CheckAvailability(ctx, job.DepartureStation, job.ArrivalStation, job.TravelDate)
+	response, err := h.trainSvc.CheckAvailability(ctx, job.DepartureStation, job.ArrivalStation, job.TravelDate, job.Passengers)
 	if err != nil {
+		// No trains or no sales yet just means there is nothing to report
+		if errors.Is(err, service.ErrNoServiceOnDate) || errors.Is(err, service.ErrSalesNotOpen) {
+			return nil
+		}
 		return fmt.Errorf("check availability: %w", err)
 	}
+	h.recordPrices(ctx, job.DepartureStation, job.ArrivalStation, job.TravelDate, response)
+	h.scheduler.Observe(job, util.TotalSeats(response.TrainLegs))
 
-	availableSeats := util.FindAvailableSeats(response.TrainLegs)
-	if len(availableSeats) > 0 {
-		for _, seat := range availableSeats {
-			// If YHT is found, notify and deactivate subscription
-			if seat.IsYHT {
-				if err := h.notifyAvailability(job.ChatID, seat.Train, job.DepartureStation, job.ArrivalStation,
-					seat.DepartureTime.Format("2006-01-02T15:04:05")); err != nil {
-					return fmt.Errorf("notify YHT availability: %w", err)
-				}
-				return h.deactivateSubscription(ctx, job.ChatID, job.DepartureStation, job.ArrivalStation, job.TravelDate)
+	var errs []error
+	for _, sub := range job.Subscribers {
+		availableSeats := util.FindAvailableSeats(response.TrainLegs, sub.Filter)
+		if len(availableSeats) == 0 {
+			continue
+		}
+
+		if !h.isDue(sub) {
+			// One leg of a round trip on its own is not worth a price alert,
+			// and fares of a nearby station do not compare to the subscribed one
+			if sub.BothLegs || sub.Nearby {
+				continue
+			}
+			if err := h.checkPriceDrop(ctx, job, sub, availableSeats); err != nil {
+				errs = append(errs, fmt.Errorf("subscription %d: %w", sub.SubscriptionID, err))
 			}
+			continue
 		}
 
-		// For non-YHT trains, notify hourly and continue subscription
-		if time.Since(lastNotified.Time) >= NOTIFICATION_INTERVAL {
-			for _, seat := range availableSeats {
-				if err := h.notifyAvailability(job.ChatID, seat.Train, job.DepartureStation, job.ArrivalStation,
-					seat.DepartureTime.Format("2006-01-02T15:04:05")); err != nil {
-					return fmt.Errorf("notify availability: %w", err)
+		notify := h.notifySubscriber
+		if sub.BothLegs {
+			notify = h.notifyRoundTrip
+		}
+		if err := notify(ctx, job, sub, availableSeats); err != nil {
+			errs = append(errs, fmt.Errorf("subscription %d: %w", sub.SubscriptionID, err))
+			continue
+		}
+		// The notification listed the current fares, later drops count from here
+		if sub.Nearby {
+			continue
+		}
+		if err := h.resetAlertPrice(ctx, job, sub, availableSeats); err != nil {
+			errs = append(errs, fmt.Errorf("subscription %d: %w", sub.SubscriptionID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h *Handler) isDue(sub worker.Subscriber) bool {
+	h.notifiedMux.Lock()
+	defer h.notifiedMux.Unlock()
+	return h.dueLocked(sub, time.Now())
+}
+
+func (h *Handler) dueLocked(sub worker.Subscriber, now time.Time) bool {
+	last := sub.LastNotified
+	if notified := h.notified[sub.SubscriptionID]; notified.After(last) {
+		last = notified
+	}
+	return now.Sub(last) >= NOTIFICATION_INTERVAL
+}
+
+// claimNotification reserves the notification of a due subscriber for the
+// caller. Every date and nearby route of a subscription is a job of its own,
+// all carrying the LastNotified of the tick they were collected in, so only
+// the first of them to claim may notify.
+func (h *Handler) claimNotification(sub worker.Subscriber) bool {
+	h.notifiedMux.Lock()
+	defer h.notifiedMux.Unlock()
+
+	now := time.Now()
+	if !h.dueLocked(sub, now) {
+		return false
+	}
+	for id, notified := range h.notified {
+		if now.Sub(notified) >= NOTIFICATION_INTERVAL {
+			delete(h.notified, id)
+		}
+	}
+	h.notified[sub.SubscriptionID] = now
+	return true
+}
+
+// releaseNotification gives back a claim whose notification was not sent
+func (h *Handler) releaseNotification(sub worker.Subscriber) {
+	h.notifiedMux.Lock()
+	delete(h.notified, sub.SubscriptionID)
+	h.notifiedMux.Unlock()
+}
+
+func (h *Handler) notifySubscriber(ctx context.Context, job worker.Job, sub worker.Subscriber, availableSeats []util.SeatAvailability) error {
+	// Date ranges name the day that matched, round trips the direction
+	var matchedDate string
+	if sub.DateRange {
+		matchedDate = job.TravelDate
+	}
+	details := availabilityDetails(matchedDate, legName(sub.RoundTripID != 0, sub.ReturnLeg))
+	// and nearby stations the one that stood in for the subscribed station
+	if sub.Nearby {
+		details += h.nearbyLine(job, sub)
+	}
+
+	// Another date or route of the subscription may have notified already
+	if !h.claimNotification(sub) {
+		return nil
+	}
+
+	for _, seat := range availableSeats {
+		// If YHT is found, notify and deactivate subscription
+		if seat.IsYHT {
+			if err := h.notifyAvailability(sub.ChatID, seat, job.DepartureStation, job.ArrivalStation, details); err != nil {
+				h.releaseNotification(sub)
+				return fmt.Errorf("notify YHT availability: %w", err)
+			}
+			// A nearby station is only an alternative, the subscribed route
+			// is still watched and the report repeats hourly
+			if sub.Nearby {
+				if err := h.subs.MarkNotified(ctx, sub.SubscriptionID); err != nil {
+					return fmt.Errorf("update last notification: %w", err)
 				}
+				return nil
 			}
-			
-			// Update last notification time
-			_, err = h.db.ExecContext(ctx, `
-				UPDATE subscriptions 
-				SET last_notified = CURRENT_TIMESTAMP 
-				WHERE chat_id = ? AND departure_station_id = ? AND arrival_station_id = ? AND travel_date = ?`,
-				job.ChatID, job.DepartureStation, job.ArrivalStation, job.TravelDate)
-			if err != nil {
-				return fmt.Errorf("update last notification: %w", err)
+			// or only stop checking this day when the user asked to keep watching
+			if sub.KeepWatching {
+				return h.satisfyDate(ctx, sub.SubscriptionID, job.TravelDate)
 			}
+			return h.subs.Deactivate(ctx, sub.SubscriptionID)
+		}
+	}
+
+	// For non-YHT trains, notify hourly and continue subscription
+	for _, seat := range availableSeats {
+		if err := h.notifyAvailability(sub.ChatID, seat, job.DepartureStation, job.ArrivalStation, details); err != nil {
+			h.releaseNotification(sub)
+			return fmt.Errorf("notify availability: %w", err)
 		}
 	}
 
+	// Update last notification time
+	if err := h.subs.MarkNotified(ctx, sub.SubscriptionID); err != nil {
+		return fmt.Errorf("update last notification: %w", err)
+	}
+
 	return nil
 }
 
-func (h *Handler) notifyAvailability(chatID int64, trainInfo model.Trains, departureStationID, arrivalStationID int, departureTime string) error {
-	departureTimeParsed, err := time.Parse("2006-01-02T15:04:05", departureTime)
+// satisfyDate stops checking one day of a range and ends the subscription
+// once no day is left
+func (h *Handler) satisfyDate(ctx context.Context, subscriptionID int64, travelDate string) error {
+	sub, err := h.subs.Get(ctx, subscriptionID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("parse departure time: %w", err)
+		return err
 	}
 
-	loc, err := time.LoadLocation("Europe/Istanbul")
-	if err != nil {
-		return fmt.Errorf("load timezone: %w", err)
+	// Days already over are dropped while at it, a weekly series would
+	// collect them forever
+	now := time.Now()
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	satisfied := []string{travelDate}
+	for _, date := range sub.SatisfiedDates {
+		day, err := time.Parse(store.TravelDateLayout, date)
+		if err == nil && !day.Before(today) && date != travelDate {
+			satisfied = append(satisfied, date)
+		}
+	}
+	if err := h.subs.SetSatisfiedDates(ctx, subscriptionID, satisfied); err != nil {
+		return fmt.Errorf("satisfy %s: %w", travelDate, err)
+	}
+	sub.SatisfiedDates = satisfied
+
+	// A weekly series ends only when cancelled
+	if !sub.Recurring && len(sub.Dates(now)) == 0 {
+		return h.subs.Deactivate(ctx, subscriptionID)
+	}
+	return nil
+}
+
+// availabilityDetails describes where a match fits in the subscription.
+// matchedDate is set for date ranges so the user knows which day matched,
+// leg names the direction of a round trip.
+func availabilityDetails(matchedDate, leg string) string {
+	var details string
+	if leg != "" {
+		details = fmt.Sprintf("↔️ *Yön:* %s\n", leg)
+	}
+	if matchedDate != "" {
+		if date, err := time.Parse(store.TravelDateLayout, matchedDate); err == nil {
+			details += fmt.Sprintf("📅 *Eşleşen Tarih:* %s %s\n", matchedDate, weekdayNames[date.Weekday()])
+		}
 	}
+	return details
+}
+
+// notifyAvailability reports seats on a train. details are extra lines from
+// availabilityDetails shown under the route.
+func (h *Handler) notifyAvailability(chatID int64, seat util.SeatAvailability, departureStationID, arrivalStationID int, details string) error {
+	trainInfo := seat.Train
 
-	departureTimeTurkish := departureTimeParsed.In(loc).Format("02.01.2006 15:04")
+	departureTimeTurkish := seat.DepartureTime.In(util.Istanbul).Format("02.01.2006 15:04")
 
 	var departureStationName, arrivalStationName string
 	h.stationsMux.RLock()
@@ -650,11 +1077,18 @@ func (h *Handler) notifyAvailability(chatID int64, trainInfo model.Trains, depar
 	}
 	h.stationsMux.RUnlock()
 
+	// Only the cabin classes the subscriber asked for are in AvailableSeats
 	var seatDetails []string
 	for _, cabinClass := range trainInfo.CabinClassAvailabilities {
-		if cabinClass.CabinClass.Name != "TEKERLEKLİ SANDALYE" && cabinClass.AvailabilityCount > 0 {
-			seatDetails = append(seatDetails, fmt.Sprintf("🎫 %s: %d koltuk", cabinClass.CabinClass.Name, cabinClass.AvailabilityCount))
+		count, ok := seat.AvailableSeats[cabinClass.CabinClass.Name]
+		if !ok {
+			continue
 		}
+		detail := fmt.Sprintf("🎫 %s: %d koltuk", cabinClass.CabinClass.Name, count)
+		if fare, priced := seat.Fares[cabinClass.CabinClass.Name]; priced {
+			detail += " • " + fare.String()
+		}
+		seatDetails = append(seatDetails, detail)
 	}
 
 	var msgPrefix string
@@ -666,109 +1100,185 @@ func (h *Handler) notifyAvailability(chatID int64, trainInfo model.Trains, depar
 
 	msgText := fmt.Sprintf("%s\n\n"+
 		"🚉 *Güzergah:* %s → %s\n"+
+		"%s"+
 		"🕒 *Kalkış Zamanı:* %s\n"+
 		"🎫 *Tren:* %s (%s)\n\n"+
 		"*Müsait Koltuklar:*\n%s",
 		msgPrefix,
 		departureStationName,
 		arrivalStationName,
+		details,
 		departureTimeTurkish,
 		trainInfo.Name,
 		trainInfo.Type,
 		strings.Join(seatDetails, "\n"))
 
-	msg := tgbotapi.NewMessage(chatID, msgText)
-	msg.ParseMode = "Markdown"
-	_, err = h.bot.Send(msg)
-	return err
-}
-
-func (h *Handler) deactivateSubscription(ctx context.Context, chatID int64, departureStationID, arrivalStationID int, travelDate string) error {
-	_, err := h.db.ExecContext(ctx, `
-        UPDATE subscriptions 
-        SET deleted_at = CURRENT_TIMESTAMP 
-        WHERE chat_id = ? 
-        AND departure_station_id = ? 
-        AND arrival_station_id = ? 
-        AND travel_date = ?`,
-		chatID, departureStationID, arrivalStationID, travelDate)
-	return err
+	return h.msgr.SendText(chatID, msgText, messenger.Markdown)
 }
 
-func (h *Handler) handleListSubscriptions(ctx context.Context, update tgbotapi.Update) {
-	chatID := update.Message.Chat.ID
+func (h *Handler) handleListSubscriptions(ctx context.Context, msg messenger.Message) {
+	chatID := msg.ChatID
 
 	subscriptions, err := h.getActiveSubscriptions(ctx, chatID)
 	if err != nil {
 		log.Printf("Error getting subscriptions: %v", err)
-		msg := tgbotapi.NewMessage(chatID, "Abonelikleriniz getirilirken bir hata oluştu.")
-		h.bot.Send(msg)
+		h.msgr.SendText(chatID, "Abonelikleriniz getirilirken bir hata oluştu.", messenger.Plain)
 		return
 	}
 
 	if len(subscriptions) == 0 {
-		msg := tgbotapi.NewMessage(chatID, "Aktif aboneliğiniz bulunmamaktadır.")
-		h.bot.Send(msg)
+		h.msgr.SendText(chatID, "Aktif aboneliğiniz bulunmamaktadır.", messenger.Plain)
 		return
 	}
 
 	// Create message with inline keyboard
-	var keyboard [][]tgbotapi.InlineKeyboardButton
+	var keyboard [][]messenger.Button
 	var messageText strings.Builder
 	messageText.WriteString("Aktif Abonelikleriniz:\n\n")
 
 	for i, sub := range subscriptions {
-		messageText.WriteString(fmt.Sprintf("%d. %s → %s (%s)\n",
-			i+1, sub.DepartureStation, sub.ArrivalStation, sub.TravelDate))
-
-		keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData(
-				fmt.Sprintf("🗑️ %s → %s aboneliğini iptal et", sub.DepartureStation, sub.ArrivalStation),
-				fmt.Sprintf("%s%d", CancelSubscriptionPrefix, sub.ID),
-			),
+		switch {
+		case sub.ReturnDate != "":
+			messageText.WriteString(fmt.Sprintf("%d. %s ⇄ %s (Gidiş %s, Dönüş %s)\n",
+				i+1, sub.DepartureStation, sub.ArrivalStation, sub.TravelDate, sub.ReturnDate))
+			messageText.WriteString(fmt.Sprintf("   🔔 Bildirim: %s\n", roundTripModeName(sub.BothLegs)))
+		case sub.RoundTripID != 0:
+			messageText.WriteString(fmt.Sprintf("%d. %s → %s (%s, %s)\n",
+				i+1, sub.DepartureStation, sub.ArrivalStation, legName(true, sub.ReturnLeg), sub.TravelDate))
+		case sub.Recurring:
+			messageText.WriteString(fmt.Sprintf("%d. %s → %s (🔁 %s)\n",
+				i+1, sub.DepartureStation, sub.ArrivalStation, formatRecurrence(sub.Weekdays)))
+			if paused := pausedWeeksSummary(sub.PausedWeeks, time.Now()); paused != "" {
+				messageText.WriteString(fmt.Sprintf("   ⏸ Duraklatılan haftalar: %s\n", paused))
+			}
+		default:
+			messageText.WriteString(fmt.Sprintf("%d. %s → %s (%s)\n",
+				i+1, sub.DepartureStation, sub.ArrivalStation, formatTravelDates(sub.TravelDate, sub.TravelDateEnd)))
+		}
+		if sub.TravelDateEnd != "" {
+			messageText.WriteString(fmt.Sprintf("   📅 Günler: %s\n", formatWeekdays(sub.Weekdays)))
+			if sub.KeepWatching {
+				messageText.WriteString("   🔁 Eşleşmeden sonra diğer günler de izlenir\n")
+			}
+		}
+		if sub.ViaStation != "" {
+			messageText.WriteString(fmt.Sprintf("   🔀 Aktarma: %s\n", sub.ViaStation))
+		}
+		if sub.Nearby && sub.NearbyStations != "" {
+			messageText.WriteString(fmt.Sprintf("   📍 Yakın istasyonlar: %s\n", sub.NearbyStations))
+		}
+		if !sub.Passengers.IsSingleAdult() {
+			messageText.WriteString(fmt.Sprintf("   👥 Yolcu: %s\n", sub.Passengers.Describe()))
+		}
+		if window := formatTimeWindow(sub.EarliestDeparture, sub.LatestDeparture); window != "" {
+			if sub.ReturnDate != "" {
+				messageText.WriteString(fmt.Sprintf("   🕒 Gidiş kalkış: %s\n", window))
+			} else {
+				messageText.WriteString(fmt.Sprintf("   🕒 Kalkış: %s\n", window))
+			}
+		}
+		if len(sub.CabinClasses) > 0 {
+			messageText.WriteString(fmt.Sprintf("   💺 Sınıf: %s\n", strings.Join(sub.CabinClasses, ", ")))
+		}
+		if sub.MaxPrice > 0 {
+			messageText.WriteString(fmt.Sprintf("   💰 En fazla: %s TL\n", util.FormatAmount(sub.MaxPrice)))
+		}
+
+		if sub.Recurring {
+			keyboard = append(keyboard, []messenger.Button{
+				{
+					Text: fmt.Sprintf("⏸ %s → %s haftalarını yönet", sub.DepartureStation, sub.ArrivalStation),
+					Data: fmt.Sprintf("%s%d", PauseMenuPrefix, sub.ID),
+				},
+			})
+		}
+		if sub.NearbyStations != "" {
+			nearbyText := fmt.Sprintf("📍 %s → %s için yakın istasyonları da izle", sub.DepartureStation, sub.ArrivalStation)
+			if sub.Nearby {
+				nearbyText = fmt.Sprintf("📍 %s → %s için yakın istasyonları bırak", sub.DepartureStation, sub.ArrivalStation)
+			}
+			keyboard = append(keyboard, []messenger.Button{
+				{
+					Text: nearbyText,
+					Data: fmt.Sprintf("%s%d", NearbyPrefix, sub.ID),
+				},
+			})
+		}
+		cancelText := fmt.Sprintf("🗑️ %s → %s aboneliğini iptal et", sub.DepartureStation, sub.ArrivalStation)
+		if sub.ReturnDate != "" {
+			cancelText = fmt.Sprintf("🗑️ %s ⇄ %s gidiş-dönüşünü iptal et", sub.DepartureStation, sub.ArrivalStation)
+		}
+		keyboard = append(keyboard, []messenger.Button{
+			{
+				Text: cancelText,
+				Data: fmt.Sprintf("%s%d", CancelSubscriptionPrefix, sub.ID),
+			},
 		})
 	}
 
-	msg := tgbotapi.NewMessage(chatID, messageText.String())
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
-	h.bot.Send(msg)
+	h.msgr.SendWithButtons(chatID, messageText.String(), messenger.Plain, keyboard)
 }
 
 func (h *Handler) getActiveSubscriptions(ctx context.Context, chatID int64) ([]SubscriptionInfo, error) {
-	rows, err := h.db.QueryContext(ctx, `
-        SELECT 
-            id,
-            departure_station_id,
-            arrival_station_id,
-            travel_date
-        FROM subscriptions 
-        WHERE chat_id = ? AND deleted_at IS NULL
-        ORDER BY created_at DESC`,
-		chatID)
+	subs, err := h.subs.ListActiveByChat(ctx, chatID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	var subscriptions []SubscriptionInfo
-	for rows.Next() {
-		var sub SubscriptionInfo
-		var departureID, arrivalID int
-		if err := rows.Scan(&sub.ID, &departureID, &arrivalID, &sub.TravelDate); err != nil {
-			return nil, err
+	// Both legs of a round trip are one item, listed as the outbound leg
+	roundTrips := make(map[int64]int)
+	for _, s := range subs {
+		sub := SubscriptionInfo{
+			ID:                s.ID,
+			TravelDate:        s.TravelDate,
+			TravelDateEnd:     s.TravelDateEnd,
+			Weekdays:          s.Weekdays,
+			KeepWatching:      s.KeepWatching,
+			Recurring:         s.Recurring,
+			PausedWeeks:       s.PausedWeeks,
+			RoundTripID:       s.RoundTripID,
+			ReturnLeg:         s.ReturnLeg,
+			BothLegs:          s.BothLegs,
+			CabinClasses:      s.CabinClasses,
+			EarliestDeparture: s.EarliestDeparture,
+			LatestDeparture:   s.LatestDeparture,
+			Passengers:        s.Passengers,
+			MaxPrice:          s.MaxPrice,
 		}
 
 		h.stationsMux.RLock()
 		for _, station := range h.stations {
-			if station.ID == departureID {
+			if station.ID == s.DepartureStationID {
 				sub.DepartureStation = station.Name
 			}
-			if station.ID == arrivalID {
+			if station.ID == s.ArrivalStationID {
 				sub.ArrivalStation = station.Name
 			}
+			if station.ID == s.ViaStationID {
+				sub.ViaStation = station.Name
+			}
 		}
 		h.stationsMux.RUnlock()
 
+		sub.Nearby = s.Nearby
+		if s.ViaStationID == 0 {
+			sub.NearbyStations = h.nearbyNames(s.DepartureStationID, s.ArrivalStationID)
+		}
+
+		if s.IsRoundTrip() {
+			if i, ok := roundTrips[s.RoundTripID]; ok {
+				if s.ReturnLeg {
+					subscriptions[i].ReturnDate = s.TravelDate
+				} else {
+					sub.ReturnDate = subscriptions[i].TravelDate
+					subscriptions[i] = sub
+				}
+				continue
+			}
+			roundTrips[s.RoundTripID] = len(subscriptions)
+		}
+
 		subscriptions = append(subscriptions, sub)
 	}
 
@@ -776,153 +1286,262 @@ func (h *Handler) getActiveSubscriptions(ctx context.Context, chatID int64) ([]S
 }
 
 func (h *Handler) cancelSubscription(ctx context.Context, chatID int64, subscriptionID int64) error {
-	result, err := h.db.ExecContext(ctx, `
-        UPDATE subscriptions 
-        SET deleted_at = CURRENT_TIMESTAMP 
-        WHERE id = ? AND chat_id = ? AND deleted_at IS NULL`,
-		subscriptionID, chatID)
-	if err != nil {
+	sub, err := h.subs.Get(ctx, subscriptionID)
+	if err == nil {
+		err = h.subs.Cancel(ctx, chatID, subscriptionID)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("subscription not found or already cancelled")
+	}
+	if err != nil || !sub.IsRoundTrip() {
 		return err
 	}
 
-	rows, err := result.RowsAffected()
+	// Cancelling a round trip cancels the other leg too
+	subs, err := h.subs.ListActiveByChat(ctx, chatID)
 	if err != nil {
 		return err
 	}
-
-	if rows == 0 {
-		return fmt.Errorf("subscription not found or already cancelled")
+	for _, leg := range subs {
+		if leg.RoundTripID == sub.RoundTripID {
+			if err := h.subs.Cancel(ctx, chatID, leg.ID); err != nil {
+				return err
+			}
+		}
 	}
-
 	return nil
 }
 
 // Add the missing handleDateSelection method
-func (h *Handler) handleDateSelection(callback *tgbotapi.CallbackQuery, selectedDate time.Time) {
-    chatID := callback.Message.Chat.ID
-
-    h.statesMux.Lock()
-    state := h.userStates[chatID]
-    h.statesMux.Unlock()
-
-    if state == nil {
-        return
-    }
-
-    // Format date for subscription
-    dateStr := selectedDate.Format("02-01-2006")
-
-    // Check current date
-    if selectedDate.Before(time.Now().AddDate(0, 0, -1)) {
-        msg := tgbotapi.NewMessage(chatID, "Geçmiş bir tarih seçemezsiniz. Lütfen gelecek bir tarih seçin.")
-        h.bot.Send(msg)
-        return
-    }
-
-    // Call CheckAvailability before creating subscription
-    depID, _ := strconv.Atoi(state.DepartureStation)
-    arrID, _ := strconv.Atoi(state.ArrivalStation)
-
-    // First check if subscription already exists
-    var count int
-    err := h.db.QueryRow(`SELECT COUNT(*) FROM subscriptions WHERE chat_id = ? AND departure_station_id = ? AND arrival_station_id = ? AND travel_date = ? AND deleted_at IS NULL`,
-        chatID, depID, arrID, dateStr).Scan(&count)
-    if err != nil {
-        log.Printf("Error checking existing subscription: %v", err)
-        msg := tgbotapi.NewMessage(chatID, "Bir hata oluştu. Lütfen daha sonra tekrar deneyin.")
-        h.bot.Send(msg)
-        return
-    }
-    if count > 0 {
-        msg := tgbotapi.NewMessage(chatID, "Bu güzergah için zaten bir takibiniz bulunmaktadır.")
-        h.bot.Send(msg)
-        return
-    }
-
-    response, err := h.trainSvc.CheckAvailability(context.Background(), depID, arrID, dateStr)
-    if err != nil {
-        if strings.Contains(err.Error(), "no trains available") {
-            msg := tgbotapi.NewMessage(chatID, "Bu tarih için henüz sefer bulunmamaktadır. Lütfen daha sonra tekrar deneyiniz.")
-            h.bot.Send(msg)
-            return
-        }
-        log.Printf("Error checking availability: %v", err)
-    }
-
-    var yhtFound bool
-    if response != nil {
-        availableSeats := util.FindAvailableSeats(response.TrainLegs)
-        if len(availableSeats) > 0 {
-            for _, seat := range availableSeats {
-                if seat.IsYHT {
-                    yhtFound = true
-                    h.notifyAvailability(chatID, seat.Train, depID, arrID,
-                        seat.DepartureTime.Format("2006-01-02T15:04:05"))
-                    msg := tgbotapi.NewMessage(chatID, "✨ YHT bulundu! Yukarıdaki seferi hemen kontrol ediniz.\n"+
-                        "🎯 Takip oluşturulmadı çünkü bilet şu an müsait!")
-                    h.bot.Send(msg)
-                    break
-                }
-            }
-            if !yhtFound {
-                h.createSubscription(chatID, state.DepartureStation, state.ArrivalStation, dateStr)
-                msg := tgbotapi.NewMessage(chatID, "🎫 Konvansiyonel tren bulundu\n"+
-                    "✅ Takip oluşturuldu ve YHT için aramaya devam edilecek\n"+
-                    "📱 Müsait YHT bulunduğunda anında bildirim alacaksınız!")
-                h.bot.Send(msg)
-            }
-        } else {
-            h.createSubscription(chatID, state.DepartureStation, state.ArrivalStation, dateStr)
-            msg := tgbotapi.NewMessage(chatID, "🔍 Şu an için müsait koltuk bulunmuyor\n"+
-                "✅ Takip başarıyla oluşturuldu\n"+
-                "📱 Uygun koltuk bulunduğunda anında bildirim alacaksınız!")
-            h.bot.Send(msg)
-        }
-    } else {
-        // No response or error, create subscription
-        h.createSubscription(chatID, state.DepartureStation, state.ArrivalStation, dateStr)
-        msg := tgbotapi.NewMessage(chatID, "Aboneliğiniz oluşturuldu! Koltuk bulunduğunda size haber vereceğim.")
-        h.bot.Send(msg)
-    }
-
-    // Clean up state
-    h.statesMux.Lock()
-    delete(h.userStates, chatID)
-    h.statesMux.Unlock()
+func (h *Handler) handleDateSelection(ctx context.Context, chatID int64, selectedDate time.Time) {
+	h.statesMux.Lock()
+	state := h.userStates[chatID]
+	h.statesMux.Unlock()
+
+	if state == nil {
+		return
+	}
+
+	// Format date for subscription
+	dateStr := selectedDate.Format("02-01-2006")
+
+	// Check current date
+	if selectedDate.Before(time.Now().AddDate(0, 0, -1)) {
+		h.msgr.SendText(chatID, "Geçmiş bir tarih seçemezsiniz. Lütfen gelecek bir tarih seçin.", messenger.Plain)
+		return
+	}
+
+	// Call CheckAvailability before creating subscription
+	depID, _ := strconv.Atoi(state.DepartureStation)
+	arrID, _ := strconv.Atoi(state.ArrivalStation)
+
+	// First check if subscription already exists
+	exists, err := h.subs.ExistsActive(ctx, chatID, depID, arrID, dateStr)
+	if err != nil {
+		log.Printf("Error checking existing subscription: %v", err)
+		h.msgr.SendText(chatID, "Bir hata oluştu. Lütfen daha sonra tekrar deneyin.", messenger.Plain)
+		return
+	}
+	if exists {
+		h.msgr.SendText(chatID, "Bu güzergah için zaten bir takibiniz bulunmaktadır.", messenger.Plain)
+		return
+	}
+
+	h.statesMux.Lock()
+	state.clearDates()
+	state.TravelDate = dateStr
+	h.statesMux.Unlock()
+
+	h.askPassengers(chatID, state)
+}
+
+// checkRoute runs the first availability check for the group once it is
+// known, then continues with the time window.
+func (h *Handler) checkRoute(ctx context.Context, chatID int64, state *UserState) {
+	checkDate := state.checkDate()
+	isRange := state.isRange()
+	depID, arrID := state.checkedRoute()
+
+	// The user waits in the chat, this goes ahead of background checks
+	ctx = service.WithPriority(ctx, service.PriorityInteractive)
+	response, err := h.trainSvc.CheckAvailability(ctx, depID, arrID, checkDate, state.Passengers)
+	if err != nil {
+		log.Printf("Error checking availability: %v", err)
+
+		h.msgr.SendText(chatID, availabilityErrorMessage(err), messenger.Markdown)
+
+		// Nothing to watch on this day, don't create a subscription. The
+		// other days of a range may still have trains.
+		if errors.Is(err, service.ErrNoServiceOnDate) && !isRange {
+			h.statesMux.Lock()
+			state.State = StateSelectDate
+			h.statesMux.Unlock()
+
+			h.msgr.SendWithButtons(chatID, "Lütfen başka bir tarih seçin:", messenger.Plain, dateKeyboard())
+			return
+		}
+	}
+
+	if err == nil {
+		h.recordPrices(ctx, depID, arrID, checkDate, response)
+	}
+
+	h.statesMux.Lock()
+	state.Availability = response
+	h.statesMux.Unlock()
+
+	h.askTimeWindow(chatID, state)
+}
+
+// completeSubscription reports seats that are already available and creates
+// the subscription otherwise, ending the wizard.
+func (h *Handler) completeSubscription(ctx context.Context, chatID int64, state *UserState) {
+	depID, _ := strconv.Atoi(state.DepartureStation)
+	arrID, _ := strconv.Atoi(state.ArrivalStation)
+	response := h.wizardAvailability(ctx, state)
+	filter := state.SeatFilter()
+
+	var yhtFound bool
+	if state.ViaStation != "" {
+		// Seats on the first train alone don't make a journey, the scheduler
+		// reports connections
+		viaID, _ := strconv.Atoi(state.ViaStation)
+		via, _ := h.routeNames(viaID, 0)
+		h.createSubscription(ctx, chatID, state)
+		h.msgr.SendText(chatID, fmt.Sprintf("🔀 %s üzerinden aktarmalı takip edilecek\n"+
+			"⏱ Trenler arasında en az %d dk aktarma süresi aranır\n"+
+			"📱 İki trende de uygun koltuk bulunduğunda haber vereceğim!",
+			via, int(h.cfg.MinTransfer.Minutes())), messenger.Plain)
+	} else if state.ReturnDate != "" {
+		// Seats one way don't settle a round trip, the scheduler reports them
+		if err := h.createRoundTrip(ctx, chatID, state); err != nil {
+			log.Printf("Error creating round trip: %v", err)
+			h.msgr.SendText(chatID, "Abonelik oluşturulurken bir hata oluştu. Lütfen daha sonra tekrar deneyin.", messenger.Plain)
+		} else {
+			h.msgr.SendText(chatID, fmt.Sprintf("↔️ Gidiş %s, dönüş %s takip edilecek\n"+
+				"🔔 Bildirim: %s\n"+
+				"📱 Uygun koltuk bulunduğunda hangi yön olduğunu da bildireceğim!",
+				state.TravelDate, state.ReturnDate, util.ToLowerTurkish(roundTripModeName(state.BothLegs))), messenger.Plain)
+		}
+	} else if state.Recurring {
+		h.createSubscription(ctx, chatID, state)
+		h.msgr.SendText(chatID, fmt.Sprintf("🔁 %s, önümüzdeki %d hafta boyunca takip edilecek\n"+
+			"📱 Uygun koltuk bulunduğunda hangi gün olduğunu da bildireceğim!\n"+
+			"⏸ Bir haftayı /aboneliklerim üzerinden duraklatabilirsiniz.",
+			formatRecurrence(state.Weekdays), state.Weeks), messenger.Plain)
+	} else if state.TravelDateEnd != "" {
+		// Seats on one day don't settle a range, the scheduler reports them
+		h.createSubscription(ctx, chatID, state)
+		h.msgr.SendText(chatID, fmt.Sprintf("📆 %s arasında, %s için takip edilecek\n"+
+			"📱 Uygun koltuk bulunduğunda hangi gün olduğunu da bildireceğim!",
+			formatTravelDates(state.TravelDate, state.TravelDateEnd), util.ToLowerTurkish(formatWeekdays(state.Weekdays))),
+			messenger.Plain)
+	} else if response != nil {
+		availableSeats := util.FindAvailableSeats(response.TrainLegs, filter)
+		if len(availableSeats) > 0 {
+			for _, seat := range availableSeats {
+				if seat.IsYHT {
+					yhtFound = true
+					h.notifyAvailability(chatID, seat, depID, arrID, "")
+					h.msgr.SendText(chatID, "✨ YHT bulundu! Yukarıdaki seferi hemen kontrol ediniz.\n"+
+						"🎯 Takip oluşturulmadı çünkü bilet şu an müsait!", messenger.Plain)
+					break
+				}
+			}
+			if !yhtFound {
+				h.createSubscription(ctx, chatID, state)
+				h.msgr.SendText(chatID, "🎫 Konvansiyonel tren bulundu\n"+
+					"✅ Takip oluşturuldu ve YHT için aramaya devam edilecek\n"+
+					"📱 Müsait YHT bulunduğunda anında bildirim alacaksınız!", messenger.Plain)
+			}
+		} else {
+			h.createSubscription(ctx, chatID, state)
+			h.msgr.SendText(chatID, "🔍 Şu an için müsait koltuk bulunmuyor\n"+
+				"✅ Takip başarıyla oluşturuldu\n"+
+				"📱 Uygun koltuk bulunduğunda anında bildirim alacaksınız!", messenger.Plain)
+		}
+	} else {
+		// No response or error, create subscription
+		h.createSubscription(ctx, chatID, state)
+		h.msgr.SendText(chatID, "Aboneliğiniz oluşturuldu! Koltuk bulunduğunda size haber vereceğim.", messenger.Plain)
+	}
+
+	// Clean up state
+	h.statesMux.Lock()
+	delete(h.userStates, chatID)
+	h.statesMux.Unlock()
+}
+
+// availabilityErrorMessage returns the user facing message for a failed availability check
+func availabilityErrorMessage(err error) string {
+	var msgText string
+	switch {
+	case errors.Is(err, service.ErrNoServiceOnDate):
+		return MsgNoServiceOnDate
+	case errors.Is(err, service.ErrSalesNotOpen):
+		return MsgSalesNotOpen
+	case errors.Is(err, service.ErrThrottled):
+		return MsgUpstreamThrottled
+	case errors.Is(err, service.ErrUnauthorized):
+		msgText = MsgUpstreamUnauthorized
+	case errors.Is(err, service.ErrMalformedResponse):
+		msgText = MsgMalformedResponse
+	default:
+		msgText = MsgUpstreamUnavailable
+	}
+
+	// Give the user something to quote when reporting the problem
+	if traceID := service.TraceID(err); traceID != "" {
+		msgText += fmt.Sprintf("\n\n🔖 Hata kodu: `%s`", traceID)
+	}
+	return msgText
 }
 
 // Add this new method
-func (h *Handler) notifyAdmin(newUserID int64, username, firstName, lastName string) {
-    totalUsers, err := h.getUserStats()
-    if err != nil {
-        log.Printf("Error getting user stats: %v", err)
-        totalUsers = 0
-    }
-
-    msgText := fmt.Sprintf("🆕 *Yeni Kullanıcı Bildirimi*\n\n"+
-        "👤 *Kullanıcı Bilgileri:*\n"+
-        "• ID: `%d`\n"+
-        "• Kullanıcı Adı: %s\n"+
-        "• İsim: %s\n"+
-        "• Soyisim: %s\n\n"+
-        "📊 *İstatistikler:*\n"+
-        "• Toplam Kullanıcı: %d\n\n"+
-        "🕒 Tarih: %s",
-        newUserID,
-        username,
-        firstName,
-        lastName,
-        totalUsers,
-        time.Now().Format("02.01.2006 15:04:05"))
-
-    msg := tgbotapi.NewMessage(h.cfg.AdminChatID, msgText)
-    msg.ParseMode = "Markdown"
-    h.bot.Send(msg)
-}
-
-// Add this new method for admin statistics
-func (h *Handler) getUserStats() (int, error) {
-    var count int
-    err := h.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
-    return count, err
-}
\ No newline at end of file
+func (h *Handler) notifyAdmin(ctx context.Context, newUserID int64, username, firstName, lastName string) {
+	totalUsers, err := h.users.Count(ctx)
+	if err != nil {
+		log.Printf("Error getting user stats: %v", err)
+		totalUsers = 0
+	}
+
+	msgText := fmt.Sprintf("🆕 *Yeni Kullanıcı Bildirimi*\n\n"+
+		"👤 *Kullanıcı Bilgileri:*\n"+
+		"• ID: `%d`\n"+
+		"• Kullanıcı Adı: %s\n"+
+		"• İsim: %s\n"+
+		"• Soyisim: %s\n\n"+
+		"📊 *İstatistikler:*\n"+
+		"• Toplam Kullanıcı: %d\n\n"+
+		"🕒 Tarih: %s",
+		newUserID,
+		username,
+		firstName,
+		lastName,
+		totalUsers,
+		time.Now().Format("02.01.2006 15:04:05"))
+
+	h.msgr.SendText(h.cfg.AdminChatID, msgText, messenger.Markdown)
+}
+
+// notifyAdminBreaker informs the admin chat when TCDD API checks are paused or resumed
+func (h *Handler) notifyAdminBreaker(from, to service.BreakerState) {
+	var msgText string
+	switch to {
+	case service.BreakerOpen:
+		if from == service.BreakerHalfOpen {
+			return
+		}
+		msgText = "⚠️ *TCDD API Erişilemiyor*\n\n" +
+			"Art arda başarısız istekler nedeniyle kontroller duraklatıldı.\n" +
+			"API tekrar yanıt verdiğinde bilgilendirileceksiniz."
+	case service.BreakerClosed:
+		msgText = "✅ *TCDD API Tekrar Erişilebilir*\n\n" +
+			"Kontroller kaldığı yerden devam ediyor."
+	default:
+		return
+	}
+
+	log.Printf("TCDD circuit breaker %s -> %s", from, to)
+	h.msgr.SendText(h.cfg.AdminChatID, msgText+"\n\n🕒 Tarih: "+time.Now().Format("02.01.2006 15:04:05"), messenger.Markdown)
+}
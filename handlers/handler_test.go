@@ -0,0 +1,330 @@
+package handlers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tcddbot/faketelegram"
+	"tcddbot/handlers/handlertest"
+	"tcddbot/store"
+	"tcddbot/util"
+)
+
+// startWizard takes user through the wizard up to the time window step for
+// a trip from Ankara to Bostancı tomorrow.
+func startWizard(user *handlertest.User) {
+	user.Say("/abone")
+	user.Say("ankara")
+	user.Press("ANKARA GAR")
+	user.Say("bostancı")
+	user.Press("BOSTANCI")
+	user.Press("Yarın")
+	user.Press("Devam")
+	user.ExpectReply("Kalkış Saati Aralığı")
+}
+
+// finishWizard accepts every train, cabin class and price.
+func finishWizard(user *handlertest.User) {
+	user.Press("Tüm Gün")
+	user.Press("Devam")
+	user.Press("Fark Etmez")
+}
+
+func TestSubscriptionWizard(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	user := h.User(42)
+
+	user.Say("/abone")
+	user.ExpectReply("KALKIŞ İstasyonu Seçimi")
+	user.Say("ankara")
+	user.ExpectReply("'ankara' için bulunan KALKIŞ istasyonları")
+	user.Press("ANKARA GAR")
+	user.ExpectReply("VARIŞ İstasyonu Seçimi")
+	user.Say("bostancı")
+	user.ExpectReply("'bostancı' için bulunan VARIŞ istasyonları")
+	user.Press("BOSTANCI")
+	user.ExpectReply("Lütfen tarih seçin")
+	user.Press("Yarın")
+	user.ExpectReply("Yolcu Sayısı")
+	user.Press("Devam")
+	user.ExpectReply("Kalkış Saati Aralığı")
+	user.Press("Tüm Gün")
+	user.ExpectReply("Vagon Sınıfı Seçimi")
+	user.Press("Devam")
+	user.ExpectReply("Fiyat Sınırı")
+	user.Press("Fark Etmez")
+	user.ExpectReply("Takip başarıyla oluşturuldu")
+
+	subs := h.ActiveSubscriptions(42)
+	if len(subs) != 1 {
+		t.Fatalf("active subscriptions: got %d, want 1", len(subs))
+	}
+	if sub := subs[0]; sub.DepartureStationID != 98 || sub.ArrivalStationID != 1323 || sub.TravelDate != tomorrow() {
+		t.Fatalf("subscription: got %+v, want 98 -> 1323 on %s", sub, tomorrow())
+	}
+
+	// The wizard is over
+	user.Say("/iptal")
+	user.ExpectReply("İptal edilecek bir işlem bulunmuyor")
+}
+
+func TestPassengersAdultsOnly(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	user := h.User(42)
+
+	user.Say("/abone")
+	user.Say("ankara")
+	user.Press("ANKARA GAR")
+	user.Say("bostancı")
+	user.Press("BOSTANCI")
+	user.Press("Yarın")
+	user.ExpectReply("Yolcu Sayısı")
+	for _, label := range []string{"Çocuk", "Öğrenci", "65 Yaş"} {
+		if _, ok := user.LastMessage().Button(label); ok {
+			t.Fatalf("passenger keyboard offers %q before its type ID is confirmed", label)
+		}
+	}
+	user.Press("➕")
+	user.Press("Devam")
+	user.ExpectReply("Yolcular:* 2 Yetişkin")
+	user.Press("Tüm Gün")
+	user.Press("Devam")
+	user.Press("Fark Etmez")
+	user.ExpectReply("Takip başarıyla oluşturuldu")
+
+	subs := h.ActiveSubscriptions(42)
+	if len(subs) != 1 {
+		t.Fatalf("active subscriptions: got %d, want 1", len(subs))
+	}
+}
+
+func TestSubscriptionBeforeSalesOpen(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	date := time.Now().In(util.Istanbul).AddDate(0, 0, h.Config.SaleHorizonDays+5).Format(store.TravelDateLayout)
+	user := h.User(42)
+
+	user.Say("/abone")
+	user.Say("ankara")
+	user.Press("ANKARA GAR")
+	user.Say("bostancı")
+	user.Press("BOSTANCI")
+	user.Press("Özel Tarih")
+	user.Say(date)
+	user.ExpectReply("Yolcu Sayısı")
+	user.Press("Devam")
+	user.ExpectReply("Satışlar Henüz Açılmadı")
+	// The wizard goes on without cabin classes to offer, the subscription
+	// waits for the sales
+	user.Press("Tüm Gün")
+	user.ExpectReply("Fiyat Sınırı")
+	user.Press("Fark Etmez")
+	user.ExpectReply("Aboneliğiniz oluşturuldu")
+
+	if subs := h.ActiveSubscriptions(42); len(subs) != 1 || subs[0].TravelDate != date {
+		t.Fatalf("active subscriptions: got %+v, want one on %s", subs, date)
+	}
+}
+
+func TestSubscriptionNotification(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	user := h.User(42)
+	startWizard(user)
+	finishWizard(user)
+	user.ExpectReply("Takip başarıyla oluşturuldu")
+
+	h.TCDD.Advance(time.Minute)
+	user.Watch()
+	h.RunChecks()
+	user.ExpectReply("BİLETİ BULUNDU")
+}
+
+func TestCancelWizard(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	user := h.User(42)
+	startWizard(user)
+
+	user.Say("/iptal")
+	user.ExpectReply("Takip oluşturma iptal edildi")
+	if subs := h.Subscriptions(42); len(subs) != 0 {
+		t.Fatalf("subscriptions after cancelling the wizard: %+v", subs)
+	}
+
+	// Buttons of the cancelled wizard do nothing
+	user.Press("Tüm Gün")
+	user.ExpectReply("Bu seçim artık geçerli değil")
+
+	user.Say("/iptal")
+	user.ExpectReply("İptal edilecek bir işlem bulunmuyor")
+}
+
+func TestStationButtonAfterCancel(t *testing.T) {
+	h := handlertest.New(t, nil)
+	user := h.User(42)
+
+	user.Say("/abone")
+	user.Say("ankara")
+	user.Say("/iptal")
+	user.Press("ANKARA GAR")
+	user.ExpectReply("Bu seçim artık geçerli değil")
+
+	// The chat still works
+	user.Say("/abone")
+	user.ExpectReply("KALKIŞ İstasyonu Seçimi")
+}
+
+func TestCancelSubscription(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	user := h.User(42)
+	startWizard(user)
+	finishWizard(user)
+
+	user.Say("/aboneliklerim")
+	user.Press("aboneliğini iptal et")
+	user.ExpectReply("Abonelik başarıyla iptal edildi")
+	if subs := h.ActiveSubscriptions(42); len(subs) != 0 {
+		t.Fatalf("active subscriptions after cancelling: %+v", subs)
+	}
+}
+
+func TestWizardExpiry(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	user := h.User(42)
+	startWizard(user)
+
+	user.Watch()
+	h.ExpireConversations(time.Minute)
+	user.ExpectNoReply()
+
+	h.ExpireConversations(h.Config.WizardTTL)
+	user.ExpectReply("zaman aşımına uğradı")
+
+	user.Press("Tüm Gün")
+	user.ExpectReply("Bu seçim artık geçerli değil")
+}
+
+func TestWizardSurvivesRestart(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	user := h.User(42)
+	startWizard(user)
+
+	h.Restart()
+	finishWizard(user)
+	user.ExpectReply("Takip başarıyla oluşturuldu")
+	if subs := h.ActiveSubscriptions(42); len(subs) != 1 {
+		t.Fatalf("active subscriptions: got %d, want 1", len(subs))
+	}
+}
+
+func TestPauseWeek(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	today := time.Now()
+	sub := store.Subscription{ChatID: 42, DepartureStationID: 98, ArrivalStationID: 1323,
+		TravelDate: today.Format(store.TravelDateLayout), Recurring: true, Weeks: 3, KeepWatching: true,
+		PausedWeeks: []string{"01-01-2024"}}
+	if err := h.Store.Subscriptions.Create(context.Background(), &sub); err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	thisWeek, _ := time.Parse(store.TravelDateLayout, store.WeekOf(today))
+	nextWeek := thisWeek.AddDate(0, 0, 7)
+	user := h.User(42)
+
+	user.Say("/aboneliklerim")
+	user.Press("haftalarını yönet")
+	user.ExpectReply("Hafta Duraklatma")
+
+	// A week long over is dropped when another one is paused
+	user.Press(nextWeek.Format("02.01") + " –")
+	user.ExpectReply("haftası duraklatıldı")
+	expectPausedWeeks(t, h, sub.ID, nextWeek.Format(store.TravelDateLayout))
+	if _, ok := user.LastMessage().Button("▶️ " + nextWeek.Format("02.01")); !ok {
+		t.Fatalf("no resume button for the paused week in %q", user.LastMessage().Text)
+	}
+
+	user.Press("▶️ " + nextWeek.Format("02.01"))
+	user.ExpectReply("yeniden takip ediliyor")
+	expectPausedWeeks(t, h, sub.ID)
+}
+
+func TestPauseWeekOfAnotherChat(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	today := time.Now()
+	sub := store.Subscription{ChatID: 42, DepartureStationID: 98, ArrivalStationID: 1323,
+		TravelDate: today.Format(store.TravelDateLayout), Recurring: true, Weeks: 3}
+	if err := h.Store.Subscriptions.Create(context.Background(), &sub); err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	user := h.User(42)
+	user.Say("/aboneliklerim")
+	user.Press("haftalarını yönet")
+	menu := user.ExpectReply("Hafta Duraklatma")
+
+	// Another chat replaying the button changes nothing
+	button := menu.Buttons[0][0]
+	other := h.User(7).Watch()
+	h.Dispatch(h.Telegram.CallbackUpdate(faketelegram.Message{ChatID: 7, MessageID: menu.MessageID}, button.Data))
+	other.ExpectReply("Bu takip artık aktif değil")
+	expectPausedWeeks(t, h, sub.ID)
+}
+
+func expectPausedWeeks(t *testing.T, h *handlertest.Harness, id int64, want ...string) {
+	t.Helper()
+	sub, err := h.Store.Subscriptions.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("get subscription: %v", err)
+	}
+	if len(sub.PausedWeeks) != len(want) {
+		t.Fatalf("paused weeks: got %v, want %v", sub.PausedWeeks, want)
+	}
+	for i := range want {
+		if sub.PausedWeeks[i] != want[i] {
+			t.Fatalf("paused weeks: got %v, want %v", sub.PausedWeeks, want)
+		}
+	}
+}
+
+func TestCabinClassToggle(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "example.json"))
+	user := h.User(42)
+	startWizard(user)
+	user.Press("Tüm Gün")
+	user.ExpectReply("Vagon Sınıfı Seçimi")
+
+	// A press ticks a class, a second press unticks it
+	user.Press("EKONOMİ")
+	user.Press("BUSİNESS")
+	if _, ok := user.LastMessage().Button("✅ EKONOMİ"); !ok {
+		t.Fatalf("economy not ticked after pressing it")
+	}
+	user.Press("EKONOMİ")
+	if _, ok := user.LastMessage().Button("▫️ EKONOMİ"); !ok {
+		t.Fatalf("economy still ticked after pressing it again")
+	}
+	if _, ok := user.LastMessage().Button("✅ BUSİNESS"); !ok {
+		t.Fatalf("business not ticked")
+	}
+	keyboard := user.LastMessage()
+	economy, _ := keyboard.Button("EKONOMİ")
+
+	user.Press("Devam")
+	user.ExpectReply("Takip Edilecek Sınıflar:* BUSİNESS")
+	user.Press("Fark Etmez")
+	user.ExpectReply("Takip başarıyla oluşturuldu")
+
+	subs := h.ActiveSubscriptions(42)
+	if len(subs) != 1 {
+		t.Fatalf("active subscriptions: got %d, want 1", len(subs))
+	}
+	sub, err := h.Store.Subscriptions.Get(context.Background(), subs[0].ID)
+	if err != nil {
+		t.Fatalf("get subscription: %v", err)
+	}
+	if len(sub.CabinClasses) != 1 || sub.CabinClasses[0] != "C" {
+		t.Fatalf("cabin classes: got %v, want [C]", sub.CabinClasses)
+	}
+
+	// The keyboard is stale once the step is over
+	user.Watch()
+	h.Dispatch(h.Telegram.CallbackUpdate(keyboard, economy.Data))
+	user.ExpectReply("Bu seçim artık geçerli değil")
+}
@@ -1,23 +1,131 @@
 package handlers
 
+import (
+	"strconv"
+	"tcddbot/model"
+	"tcddbot/util"
+	"time"
+)
+
 const (
-    StateNone = iota
-    StateSelectDeparture
-    StateSelectArrival
-    StateSelectDate
+	StateNone = iota
+	StateSelectDeparture
+	StateSelectArrival
+	StateSelectDate
+	StateSelectPassengers
+	StateSelectTimeWindow
+	StateSelectCabinClass
+	StateSelectMaxPrice
+	StateSelectDateRange
+	StateSelectWeekdays
+	StateSelectRangeMode
+	StateSelectRoundTripDates
+	StateSelectRoundTripMode
+	StateSelectVia
 )
 
 const (
-    CallbackDateToday     = "date_today"
-    CallbackDateTomorrow  = "date_tomorrow"
-    CallbackDateCustom    = "date_custom"
-    CallbackStationPrefix = "station_"
-    MaxStationsPerPage    = 5
+	CallbackDateToday       = "date_today"
+	CallbackDateTomorrow    = "date_tomorrow"
+	CallbackDateCustom      = "date_custom"
+	CallbackDateRange       = "date_range"
+	CallbackDateWeekly      = "date_weekly"
+	CallbackDateRoundTrip   = "date_roundtrip"
+	CallbackRoundTripPrefix = "roundtrip_"
+	CallbackRoundTripBoth   = "roundtrip_both"
+	CallbackRoundTripEach   = "roundtrip_each"
+	CallbackWeekdayPrefix   = "weekday_"
+	CallbackWeekdayAll      = "weekday_all"
+	CallbackWeekdayDone     = "weekday_done"
+	CallbackRangePrefix     = "range_"
+	CallbackRangeStop       = "range_stop"
+	CallbackRangeKeep       = "range_keep"
+	MaxDateRangeDays        = 31
+	CallbackStationPrefix   = "station_"
+	CallbackViaPrefix       = "via_"
+	CallbackPassengerPrefix = "pax_"
+	CallbackPassengerInc    = "pax_inc_"
+	CallbackPassengerDec    = "pax_dec_"
+	CallbackPassengerDone   = "pax_done"
+	CallbackPassengerNoop   = "pax_noop"
+	MaxPassengers           = 9
+	CallbackTimePrefix      = "time_"
+	CallbackTimeAny         = "time_any"
+	CallbackCabinPrefix     = "cabinclass_"
+	CallbackCabinAll        = "cabin_all"
+	CallbackCabinDone       = "cabin_done"
+	CallbackPriceAny        = "price_any"
+	MaxStationsPerPage      = 5
+	MaxNearbyStations       = 3
 )
 
 type UserState struct {
-    State            int
-    DepartureStation string
-    ArrivalStation   string
-    CurrentPage      int
-}
\ No newline at end of file
+	State            int
+	DepartureStation string
+	ArrivalStation   string
+	ViaStation       string // Station to change trains at, empty for direct trains
+	TravelDate       string
+	Passengers       model.Passengers
+	CurrentPage      int
+
+	// Date range subscriptions, TravelDate is the first day
+	TravelDateEnd string
+	Weekdays      []time.Weekday
+	KeepWatching  bool
+
+	// Recurring subscriptions repeat on Weekdays for the next Weeks weeks
+	Recurring bool
+	Weeks     int
+
+	// Round trips watch the way back on ReturnDate too
+	ReturnDate string
+	BothLegs   bool
+
+	// Set once the date is chosen, so later steps can offer what TCDD sells.
+	// Too large to persist, a restored wizard asks TCDD again.
+	Availability *model.TCDDResponse `json:"-"`
+	CabinOptions []model.CabinClass
+	CabinClasses []string // Selected cabin class codes
+
+	EarliestDeparture string // HH:MM, empty is open ended
+	LatestDeparture   string
+
+	MaxPrice float64 // Per passenger, 0 accepts any
+
+	UpdatedAt time.Time // Last step taken, the wizard expires cfg.WizardTTL after it
+	restored  bool      // Loaded after a restart, Availability is not known yet
+}
+
+// checkedRoute returns the stations the availability of the wizard is
+// checked between. A connection is checked up to where the first train
+// ends, the later steps are about that train.
+func (s *UserState) checkedRoute() (departureID, arrivalID int) {
+	departureID, _ = strconv.Atoi(s.DepartureStation)
+	arrivalID, _ = strconv.Atoi(s.ArrivalStation)
+	if viaID, _ := strconv.Atoi(s.ViaStation); viaID != 0 {
+		arrivalID = viaID
+	}
+	return departureID, arrivalID
+}
+
+// clearDates forgets the dates of an earlier choice at the date step
+func (s *UserState) clearDates() {
+	s.TravelDateEnd = ""
+	s.Weekdays = nil
+	s.KeepWatching = false
+	s.Recurring = false
+	s.Weeks = 0
+	s.ReturnDate = ""
+	s.BothLegs = false
+}
+
+// SeatFilter returns the seat filter of the subscription being built.
+func (s *UserState) SeatFilter() util.SeatFilter {
+	return util.SeatFilter{
+		CabinClasses:      s.CabinClasses,
+		EarliestDeparture: s.EarliestDeparture,
+		LatestDeparture:   s.LatestDeparture,
+		Seats:             s.Passengers.OrDefault().Total(),
+		MaxPrice:          s.MaxPrice,
+	}
+}
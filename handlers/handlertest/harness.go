@@ -0,0 +1,290 @@
+// Package handlertest runs a Handler against fake Telegram and TCDD servers
+// and a throwaway SQLite database, so tests can script whole conversations.
+//
+//	h := handlertest.New(t, fixture)
+//	user := h.User(42)
+//	user.Say("/abone")
+//	user.Say("ankara")
+//	user.Press("ANKARA GAR")
+//	...
+//	user.ExpectReply("Takip başarıyla oluşturuldu")
+package handlertest
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"tcddbot/config"
+	"tcddbot/db"
+	"tcddbot/faketcdd"
+	"tcddbot/faketelegram"
+	"tcddbot/handlers"
+	"tcddbot/messenger"
+	"tcddbot/store"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// AdminChatID receives the admin notifications sent by the handler.
+const AdminChatID int64 = 1000
+
+// Harness wires a Handler to fake upstreams.
+type Harness struct {
+	T        testing.TB
+	Telegram *faketelegram.Server
+	TCDD     *faketcdd.Server
+	DB       *sql.DB
+	Store    *store.Store
+	Config   *config.Config
+	Handler  *handlers.Handler
+
+	msgr messenger.Messenger
+}
+
+// New starts the fake servers and a Handler. A nil fixture answers every
+// availability request with "no trains" (604).
+func New(t testing.TB, fixture *faketcdd.Fixture) *Harness {
+	t.Helper()
+
+	if fixture == nil {
+		fixture = &faketcdd.Fixture{}
+	}
+
+	telegram := faketelegram.New()
+	telegramServer := httptest.NewServer(telegram)
+	t.Cleanup(telegramServer.Close)
+
+	tcdd := faketcdd.New(fixture)
+	tcddServer := httptest.NewServer(tcdd)
+	t.Cleanup(tcddServer.Close)
+
+	database, err := db.Initialize(db.SQLite, filepath.Join(t.TempDir(), "tcddbot.db"))
+	if err != nil {
+		t.Fatalf("initialize database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("test-token", faketelegram.Endpoint(telegramServer.URL))
+	if err != nil {
+		t.Fatalf("initialize bot: %v", err)
+	}
+
+	cfg := &config.Config{
+		BotToken:           "test-token",
+		StationsPath:       filepath.Join(repoRoot(), "stations.json"),
+		APIEndpoint:        tcddServer.URL + "/tms/train",
+		AuthToken:          "test-auth",
+		UnitID:             "3895",
+		CheckInterval:      time.Second,
+		CleanupInterval:    time.Hour,
+		RequestTimeout:     5 * time.Second,
+		CheckTimeout:       30 * time.Second,
+		MaxAttempts:        1,
+		BreakerThreshold:   100,
+		BreakerCooldown:    time.Second,
+		AdminChatID:        AdminChatID,
+		PriceDropThreshold: 50,
+		RecurringWeeks:     4,
+		SaleHorizonDays:    30,
+		MinTransfer:        20 * time.Minute,
+		WizardTTL:          30 * time.Minute,
+	}
+
+	st := store.NewSQL(database, db.SQLite)
+	msgr := messenger.NewTelegram(bot)
+	return &Harness{
+		T:        t,
+		Telegram: telegram,
+		TCDD:     tcdd,
+		DB:       database,
+		Store:    st,
+		Config:   cfg,
+		Handler:  handlers.NewHandler(msgr, st, cfg),
+		msgr:     msgr,
+	}
+}
+
+// Restart replaces the Handler with a new one on the same database, as if
+// the bot had been restarted.
+func (h *Harness) Restart() {
+	h.Handler = handlers.NewHandler(h.msgr, h.Store, h.Config)
+}
+
+// repoRoot locates the module root from this file so stations.json is found
+// regardless of the test's working directory.
+func repoRoot() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..")
+}
+
+// User returns a scripted Telegram user whose private chat ID is chatID.
+func (h *Harness) User(chatID int64) *User {
+	return &User{h: h, ChatID: chatID}
+}
+
+// Dispatch delivers update to the handler and waits for it to be handled.
+func (h *Harness) Dispatch(update tgbotapi.Update) {
+	h.Handler.HandleUpdate(context.Background(), update)
+}
+
+// ExpireConversations runs the wizard expiry as if after had passed.
+func (h *Harness) ExpireConversations(after time.Duration) {
+	h.Handler.ExpireConversations(context.Background(), time.Now().Add(after))
+}
+
+// RunChecks runs one round of the periodic subscription checks.
+func (h *Harness) RunChecks() {
+	h.T.Helper()
+	if err := h.Handler.CheckNow(context.Background()); err != nil {
+		h.T.Errorf("run checks: %v", err)
+	}
+}
+
+// Subscription is a row of the subscriptions table.
+type Subscription struct {
+	ID                 int64
+	ChatID             int64
+	DepartureStationID int
+	ArrivalStationID   int
+	TravelDate         string
+	Notified           bool
+	Deleted            bool
+}
+
+// Subscriptions returns every subscription of chatID, including deleted ones.
+func (h *Harness) Subscriptions(chatID int64) []Subscription {
+	h.T.Helper()
+
+	rows, err := h.DB.Query(`
+        SELECT id, chat_id, departure_station_id, arrival_station_id, travel_date,
+               last_notified IS NOT NULL, deleted_at IS NOT NULL
+        FROM subscriptions
+        WHERE chat_id = ?
+        ORDER BY id`, chatID)
+	if err != nil {
+		h.T.Fatalf("query subscriptions: %v", err)
+	}
+	defer rows.Close()
+
+	var subs []Subscription
+	for rows.Next() {
+		var sub Subscription
+		if err := rows.Scan(&sub.ID, &sub.ChatID, &sub.DepartureStationID, &sub.ArrivalStationID,
+			&sub.TravelDate, &sub.Notified, &sub.Deleted); err != nil {
+			h.T.Fatalf("scan subscription: %v", err)
+		}
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// ActiveSubscriptions returns the subscriptions of chatID that are not deleted.
+func (h *Harness) ActiveSubscriptions(chatID int64) []Subscription {
+	h.T.Helper()
+
+	var active []Subscription
+	for _, sub := range h.Subscriptions(chatID) {
+		if !sub.Deleted {
+			active = append(active, sub)
+		}
+	}
+	return active
+}
+
+// User scripts one side of a private chat with the bot.
+type User struct {
+	h      *Harness
+	ChatID int64
+	mark   int
+}
+
+// Say sends a text message (or a /command) and waits for the bot to react.
+func (u *User) Say(text string) {
+	u.mark = u.h.Telegram.EventCount()
+	u.h.Dispatch(u.h.Telegram.TextUpdate(u.ChatID, text))
+}
+
+// Press presses the first button whose label contains label on the most
+// recent bot message that has one.
+func (u *User) Press(label string) {
+	u.h.T.Helper()
+
+	messages := u.h.Telegram.Messages(u.ChatID)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if button, ok := messages[i].Button(label); ok {
+			u.mark = u.h.Telegram.EventCount()
+			u.h.Dispatch(u.h.Telegram.CallbackUpdate(messages[i], button.Data))
+			return
+		}
+	}
+	u.h.T.Fatalf("chat %d: no button matching %q", u.ChatID, label)
+}
+
+// Replies returns what the bot did in this chat since the last Say or Press.
+func (u *User) Replies() []faketelegram.Event {
+	var replies []faketelegram.Event
+	for _, event := range u.h.Telegram.Events(u.mark) {
+		if event.Method == "answerCallbackQuery" || event.Message.ChatID == u.ChatID {
+			replies = append(replies, event)
+		}
+	}
+	return replies
+}
+
+// Messages returns every message the bot sent to this chat, edits applied.
+func (u *User) Messages() []faketelegram.Message {
+	return u.h.Telegram.Messages(u.ChatID)
+}
+
+// LastMessage returns the most recent message the bot sent to this chat.
+func (u *User) LastMessage() faketelegram.Message {
+	u.h.T.Helper()
+
+	messages := u.Messages()
+	if len(messages) == 0 {
+		u.h.T.Fatalf("chat %d: bot sent no messages", u.ChatID)
+	}
+	return messages[len(messages)-1]
+}
+
+// ExpectReply fails the test unless one of the replies since the last action
+// contains text, and returns the matching message.
+func (u *User) ExpectReply(text string) faketelegram.Message {
+	u.h.T.Helper()
+
+	replies := u.Replies()
+	for _, event := range replies {
+		if strings.Contains(event.Message.Text, text) || strings.Contains(event.Text, text) {
+			return event.Message
+		}
+	}
+
+	var got []string
+	for _, event := range replies {
+		got = append(got, event.Method+": "+event.Message.Text+event.Text)
+	}
+	u.h.T.Fatalf("chat %d: no reply containing %q, got:\n%s", u.ChatID, text, strings.Join(got, "\n"))
+	return faketelegram.Message{}
+}
+
+// ExpectNoReply fails the test if the bot reacted since the last action.
+func (u *User) ExpectNoReply() {
+	u.h.T.Helper()
+
+	if replies := u.Replies(); len(replies) > 0 {
+		u.h.T.Fatalf("chat %d: expected no reply, got %d", u.ChatID, len(replies))
+	}
+}
+
+// Watch starts a new reply window without sending anything, so notifications
+// produced by RunChecks can be asserted with ExpectReply.
+func (u *User) Watch() *User {
+	u.mark = u.h.Telegram.EventCount()
+	return u
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"tcddbot/messenger"
+	"tcddbot/model"
+	"tcddbot/util"
+)
+
+const msgSelectMaxPrice = "💰 *Fiyat Sınırı*\n\n" +
+	"Kişi başı en fazla ne kadar ödemek istersiniz?\n" +
+	"• Tutarı yazın, örn: 500 veya 749,90\n" +
+	"• Sınır istemiyorsanız *Fark Etmez*'e basın"
+
+const msgInvalidMaxPrice = "❌ Geçersiz tutar. Lütfen kişi başı fiyat sınırını sayı olarak girin, örn: 500"
+
+// thousandsGrouped matches amounts like 1.250 written with a thousands dot
+var thousandsGrouped = regexp.MustCompile(`^\d{1,3}(\.\d{3})+$`)
+
+// parsePrice parses a Turkish formatted amount such as "1.250", "749,90" or
+// "500 TL".
+func parsePrice(text string) (float64, error) {
+	text = strings.ToUpper(strings.TrimSpace(text))
+	for _, suffix := range []string{"TL", "TRY", "₺"} {
+		text = strings.TrimSpace(strings.TrimSuffix(text, suffix))
+	}
+
+	switch {
+	case strings.Contains(text, ","):
+		text = strings.ReplaceAll(text, ".", "")
+		text = strings.Replace(text, ",", ".", 1)
+	case thousandsGrouped.MatchString(text):
+		text = strings.ReplaceAll(text, ".", "")
+	}
+
+	amount, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, err
+	}
+	if amount <= 0 {
+		return 0, errors.New("amount must be positive")
+	}
+	return amount, nil
+}
+
+// cheapestFare returns the lowest fare TCDD quoted for the route, if any
+func cheapestFare(response *model.TCDDResponse) (util.Fare, bool) {
+	var cheapest util.Fare
+	found := false
+	if response == nil {
+		return cheapest, false
+	}
+
+	for _, trainLeg := range response.TrainLegs {
+		for _, trainAvailability := range trainLeg.TrainAvailabilities {
+			for _, train := range trainAvailability.Trains {
+				price := train.MinPrice
+				if price.PriceAmount <= 0 || (found && price.PriceAmount >= cheapest.Amount) {
+					continue
+				}
+				cheapest = util.Fare{Amount: price.PriceAmount, Currency: price.PriceCurrency}
+				found = true
+			}
+		}
+	}
+	return cheapest, found
+}
+
+// askMaxPrice starts the price ceiling step
+func (h *Handler) askMaxPrice(chatID int64, state *UserState) {
+	h.statesMux.Lock()
+	state.State = StateSelectMaxPrice
+	state.MaxPrice = 0
+	response := state.Availability
+	h.statesMux.Unlock()
+
+	msgText := msgSelectMaxPrice
+	if fare, ok := cheapestFare(response); ok {
+		msgText += "\n\n💡 Bu tarihte en düşük bilet fiyatı: " + fare.String()
+	}
+
+	h.msgr.SendWithButtons(chatID, msgText, messenger.Markdown, [][]messenger.Button{
+		{{Text: "Fark Etmez", Data: CallbackPriceAny}},
+	})
+}
+
+// handleMaxPriceSkip creates the subscription without a price ceiling
+func (h *Handler) handleMaxPriceSkip(ctx context.Context, callback messenger.Callback) {
+	chatID := callback.ChatID
+	state := h.maxPriceState(chatID)
+	if state == nil {
+		h.msgr.AnswerCallback(callback.ID, msgStaleSelection)
+		return
+	}
+
+	h.msgr.AnswerCallback(callback.ID, "")
+	h.msgr.Edit(chatID, callback.MessageID, "💰 *Fiyat Sınırı:* Yok", messenger.Markdown, nil)
+	h.completeSubscription(ctx, chatID, state)
+}
+
+// handleMaxPriceInput handles a price ceiling typed by the user
+func (h *Handler) handleMaxPriceInput(ctx context.Context, chatID int64, text string) {
+	state := h.maxPriceState(chatID)
+	if state == nil {
+		return
+	}
+
+	amount, err := parsePrice(text)
+	if err != nil {
+		h.msgr.SendText(chatID, msgInvalidMaxPrice, messenger.Plain)
+		return
+	}
+
+	h.statesMux.Lock()
+	state.MaxPrice = amount
+	h.statesMux.Unlock()
+
+	h.msgr.SendText(chatID, "💰 *Fiyat Sınırı:* Kişi başı "+util.FormatAmount(amount)+" TL", messenger.Markdown)
+	h.completeSubscription(ctx, chatID, state)
+}
+
+func (h *Handler) maxPriceState(chatID int64) *UserState {
+	h.statesMux.RLock()
+	defer h.statesMux.RUnlock()
+
+	state := h.userStates[chatID]
+	if state == nil || state.State != StateSelectMaxPrice {
+		return nil
+	}
+	return state
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"testing"
+
+	"tcddbot/model"
+	"tcddbot/util"
+)
+
+func TestParsePrice(t *testing.T) {
+	tests := []struct {
+		text string
+		want float64 // 0 when the text is refused
+	}{
+		{"500", 500},
+		{"500 TL", 500},
+		{"500tl", 500},
+		{"500 TRY", 500},
+		{"500 ₺", 500},
+		{"749,90", 749.9},
+		{"749,90 TL", 749.9},
+		{"1.250", 1250},
+		{"12.500", 12500},
+		{"1.250,50", 1250.5},
+		{"1.250.000", 1250000},
+		// A dot without three digits after it is a decimal point
+		{"12.5", 12.5},
+		{"1.25", 1.25},
+		{"0", 0},
+		{"-50", 0},
+		{"", 0},
+		{"TL", 0},
+		{"beş yüz", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := parsePrice(tt.text)
+			if tt.want == 0 {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("got %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// pricedResponse returns a response with one train per minimum price.
+func pricedResponse(prices ...float64) *model.TCDDResponse {
+	var trains []model.Trains
+	for _, price := range prices {
+		trains = append(trains, model.Trains{MinPrice: model.MinPrice{PriceAmount: price, PriceCurrency: "TRY"}})
+	}
+	return &model.TCDDResponse{TrainLegs: []model.TrainLegs{{TrainAvailabilities: []model.TrainAvailabilities{{Trains: trains}}}}}
+}
+
+func TestCheapestFare(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *model.TCDDResponse
+		want     string // Empty when no fare is known
+	}{
+		{"no response", nil, ""},
+		{"no trains", pricedResponse(), ""},
+		{"unpriced trains", pricedResponse(0, 0), ""},
+		{"cheapest", pricedResponse(1250, 749.9, 980), "749,90 TRY"},
+		{"unpriced trains left out", pricedResponse(0, 500), "500 TRY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fare, ok := cheapestFare(tt.response)
+			if got := fare.String(); ok != (tt.want != "") || (ok && got != tt.want) {
+				t.Fatalf("got %q, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypedPriceCeiling(t *testing.T) {
+	// An economy seat at 749,90 TRY
+	train := model.Trains{
+		TrainSegments: []model.TrainSegments{{DepartureTime: "2030-06-01T07:00:00"}},
+		CabinClassAvailabilities: []model.CabinClassAvailabilities{
+			{CabinClass: model.CabinClass{ID: 1, Code: "Y1", Name: "EKONOMİ"}, AvailabilityCount: 4},
+		},
+		AvailableFareInfo: []model.AvailableFareInfo{{CabinClasses: []model.CabinClasses{{
+			CabinClass: model.CabinClass{ID: 1, Code: "Y1", Name: "EKONOMİ"}, MinPrice: 749.9,
+		}}}},
+	}
+	trainLegs := []model.TrainLegs{{TrainAvailabilities: []model.TrainAvailabilities{{Trains: []model.Trains{train}}}}}
+
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"749,90", true},
+		{"750 TL", true},
+		{"1.250", true},
+		{"749", false},
+		{"749,89", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			ceiling, err := parsePrice(tt.text)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.text, err)
+			}
+			seats := util.FindAvailableSeats(trainLegs, util.SeatFilter{MaxPrice: ceiling})
+			if got := len(seats) == 1; got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
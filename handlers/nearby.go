@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"tcddbot/messenger"
+	"tcddbot/store"
+	"tcddbot/worker"
+)
+
+// route is one station pair a subscription watches
+type route struct {
+	departure, arrival int
+}
+
+// station looks a station up by ID
+func (h *Handler) station(id int) (Station, bool) {
+	h.stationsMux.RLock()
+	defer h.stationsMux.RUnlock()
+
+	for _, station := range h.stations {
+		if station.ID == id {
+			return station, true
+		}
+	}
+	return Station{}, false
+}
+
+// nearbyStations returns the siblings of a station a journey could use
+// instead, busiest first. Stations of the same city are siblings, the
+// station list carries no coordinates to measure distances with. reaches
+// tells whether a sibling still has trains to the other end of the route.
+func (h *Handler) nearbyStations(stationID int, reaches func(Station) bool) []Station {
+	home, ok := h.station(stationID)
+	if !ok {
+		return nil
+	}
+
+	h.stationsMux.RLock()
+	var siblings []Station
+	for _, station := range h.stations {
+		if station.ID == home.ID || home.CityName == "" || station.CityName != home.CityName || !reaches(station) {
+			continue
+		}
+		siblings = append(siblings, station)
+	}
+	h.stationsMux.RUnlock()
+
+	sort.SliceStable(siblings, func(i, j int) bool { return len(siblings[i].PairIDs) > len(siblings[j].PairIDs) })
+	if len(siblings) > MaxNearbyStations {
+		siblings = siblings[:MaxNearbyStations]
+	}
+	return siblings
+}
+
+// nearbyRoutes returns the routes that replace the departure or the arrival
+// of a route with a sibling station, one end at a time
+func (h *Handler) nearbyRoutes(departureID, arrivalID int) []route {
+	var routes []route
+
+	departures := h.nearbyStations(departureID, func(s Station) bool { return containsID(s.PairIDs, arrivalID) })
+	for _, departure := range departures {
+		routes = append(routes, route{departure.ID, arrivalID})
+	}
+
+	if departure, ok := h.station(departureID); ok {
+		arrivals := h.nearbyStations(arrivalID, func(s Station) bool { return containsID(departure.PairIDs, s.ID) })
+		for _, arrival := range arrivals {
+			routes = append(routes, route{departureID, arrival.ID})
+		}
+	}
+	return routes
+}
+
+// watchedRoutes returns the routes checked for a subscription, its own first.
+// Connections only ever use the stations they were made for.
+func (h *Handler) watchedRoutes(sub store.Subscription) []route {
+	routes := []route{{sub.DepartureStationID, sub.ArrivalStationID}}
+	if !sub.Nearby || sub.ViaStationID != 0 {
+		return routes
+	}
+	return append(routes, h.nearbyRoutes(sub.DepartureStationID, sub.ArrivalStationID)...)
+}
+
+func containsID(ids []int, id int) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// nearbyLine points out the sibling station that has seats
+func (h *Handler) nearbyLine(job worker.Job, sub worker.Subscriber) string {
+	if job.DepartureStation != sub.HomeDeparture {
+		alternative, home := h.routeNames(job.DepartureStation, sub.HomeDeparture)
+		return fmt.Sprintf("📍 *Alternatif kalkış:* %s (%s yerine)\n", alternative, home)
+	}
+	alternative, home := h.routeNames(job.ArrivalStation, sub.HomeArrival)
+	return fmt.Sprintf("📍 *Alternatif varış:* %s (%s yerine)\n", alternative, home)
+}
+
+// nearbyNames lists the sibling stations a route could also use, departures
+// and arrivals apart
+func (h *Handler) nearbyNames(departureID, arrivalID int) string {
+	var departures, arrivals []string
+	for _, r := range h.nearbyRoutes(departureID, arrivalID) {
+		if r.departure != departureID {
+			name, _ := h.routeNames(r.departure, 0)
+			departures = append(departures, name)
+		} else {
+			name, _ := h.routeNames(r.arrival, 0)
+			arrivals = append(arrivals, name)
+		}
+	}
+
+	var parts []string
+	if len(departures) > 0 {
+		parts = append(parts, "Kalkış "+strings.Join(departures, ", "))
+	}
+	if len(arrivals) > 0 {
+		parts = append(parts, "Varış "+strings.Join(arrivals, ", "))
+	}
+	return strings.Join(parts, " • ")
+}
+
+// handleNearbyToggle turns watching sibling stations on or off
+func (h *Handler) handleNearbyToggle(ctx context.Context, callback messenger.Callback) {
+	chatID := callback.ChatID
+	subscriptionID, err := strconv.ParseInt(strings.TrimPrefix(callback.Data, NearbyPrefix), 10, 64)
+	if err != nil {
+		h.msgr.AnswerCallback(callback.ID, "")
+		return
+	}
+
+	sub, err := h.subs.Get(ctx, subscriptionID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Error getting subscription %d: %v", subscriptionID, err)
+		h.msgr.AnswerCallback(callback.ID, "Bir hata oluştu. Lütfen daha sonra tekrar deneyin.")
+		return
+	}
+	if err != nil || sub.ChatID != chatID {
+		h.msgr.AnswerCallback(callback.ID, "Bu takip artık aktif değil.")
+		return
+	}
+
+	// Both legs of a round trip share the setting
+	ids := []int64{sub.ID}
+	if sub.IsRoundTrip() {
+		subs, err := h.subs.ListActiveByChat(ctx, chatID)
+		if err != nil {
+			log.Printf("Error listing subscriptions: %v", err)
+			h.msgr.AnswerCallback(callback.ID, "Bir hata oluştu. Lütfen daha sonra tekrar deneyin.")
+			return
+		}
+		ids = nil
+		for _, leg := range subs {
+			if leg.RoundTripID == sub.RoundTripID {
+				ids = append(ids, leg.ID)
+			}
+		}
+	}
+	for _, id := range ids {
+		if err := h.subs.SetNearby(ctx, id, !sub.Nearby); err != nil {
+			log.Printf("Error setting nearby stations of subscription %d: %v", id, err)
+			h.msgr.AnswerCallback(callback.ID, "Bir hata oluştu. Lütfen daha sonra tekrar deneyin.")
+			return
+		}
+	}
+
+	note := "📍 Yakın istasyonların takibi kapatıldı."
+	if !sub.Nearby {
+		note = "📍 Yakın istasyonlar da izlenecek: " + h.nearbyNames(sub.DepartureStationID, sub.ArrivalStationID)
+	}
+	h.msgr.Edit(chatID, callback.MessageID, callback.MessageText+"\n\n"+note, messenger.Plain, nil)
+	h.msgr.AnswerCallback(callback.ID, "")
+}
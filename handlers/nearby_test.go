@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNearbyRoutes(t *testing.T) {
+	h := &Handler{stations: []Station{
+		{ID: 1, Name: "ANKARA GAR", CityName: "ANKARA", PairIDs: []int{10, 11, 12}},
+		{ID: 2, Name: "ERYAMAN YHT", CityName: "ANKARA", PairIDs: []int{10, 11, 12, 13}},
+		{ID: 3, Name: "SİNCAN", CityName: "ANKARA", PairIDs: []int{10}},
+		{ID: 4, Name: "POLATLI", CityName: "ANKARA", PairIDs: []int{11}},
+		{ID: 5, Name: "ESKİŞEHİR", CityName: "ESKİŞEHİR", PairIDs: []int{10}},
+		{ID: 10, Name: "BOSTANCI", CityName: "İSTANBUL", PairIDs: []int{1, 2, 3, 5}},
+		{ID: 11, Name: "PENDİK", CityName: "İSTANBUL", PairIDs: []int{1, 2, 4}},
+		{ID: 12, Name: "HALKALI", CityName: "İSTANBUL", PairIDs: []int{1, 2}},
+		{ID: 13, Name: "BAKIRKÖY", CityName: "İSTANBUL", PairIDs: []int{2}},
+		{ID: 20, Name: "DURAK", PairIDs: []int{1}},
+	}}
+
+	tests := []struct {
+		name               string
+		departure, arrival int
+		want               []route
+	}{
+		// Siblings without trains to the other end are left out, the
+		// busiest come first
+		{"both ends", 1, 10, []route{{2, 10}, {3, 10}, {1, 11}, {1, 12}}},
+		{"other end", 1, 11, []route{{2, 11}, {4, 11}, {1, 10}, {1, 12}}},
+		{"departure siblings only", 4, 11, []route{{2, 11}, {1, 11}}},
+		{"no siblings", 5, 10, nil},
+		{"station without a city", 20, 1, nil},
+		{"unknown station", 99, 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.nearbyRoutes(tt.departure, tt.arrival); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNearbyRoutesLimit(t *testing.T) {
+	h := &Handler{stations: []Station{{ID: 1, CityName: "ANKARA", PairIDs: []int{10}}, {ID: 10, CityName: "İSTANBUL"}}}
+	for id := 2; id < 2+MaxNearbyStations+2; id++ {
+		h.stations = append(h.stations, Station{ID: id, CityName: "ANKARA", PairIDs: []int{10}})
+	}
+
+	if got := h.nearbyRoutes(1, 10); len(got) != MaxNearbyStations {
+		t.Fatalf("got %d routes, want %d", len(got), MaxNearbyStations)
+	}
+}
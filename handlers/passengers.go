@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"tcddbot/messenger"
+	"tcddbot/model"
+)
+
+const msgSelectPassengers = "👥 *Yolcu Sayısı*\n\n" +
+	"Kaç kişi seyahat edeceksiniz?\n" +
+	"• Yalnızca tüm grup aynı trende ve aynı sınıfta yer bulabildiğinde bildirim alırsınız\n" +
+	"• Sayıları ➖ / ➕ ile ayarlayıp *Devam*'a basın"
+
+// passengerKeyboard shows one counter row per passenger type
+func passengerKeyboard(passengers model.Passengers) [][]messenger.Button {
+	var keyboard [][]messenger.Button
+	for _, passengerType := range model.PassengerTypes {
+		id := strconv.Itoa(passengerType.ID)
+		keyboard = append(keyboard, []messenger.Button{
+			{Text: "➖", Data: CallbackPassengerDec + id},
+			{Text: fmt.Sprintf("%s: %d", passengerType.Name, passengers.Count(passengerType.ID)), Data: CallbackPassengerNoop},
+			{Text: "➕", Data: CallbackPassengerInc + id},
+		})
+	}
+	return append(keyboard, []messenger.Button{
+		{Text: fmt.Sprintf("Devam ➡️ (%d yolcu)", passengers.Total()), Data: CallbackPassengerDone},
+	})
+}
+
+// askPassengers starts the passenger step with a single adult selected
+func (h *Handler) askPassengers(chatID int64, state *UserState) {
+	h.statesMux.Lock()
+	state.State = StateSelectPassengers
+	state.Passengers = model.SingleAdult
+	keyboard := passengerKeyboard(state.Passengers)
+	h.statesMux.Unlock()
+
+	h.msgr.SendWithButtons(chatID, msgSelectPassengers, messenger.Markdown, keyboard)
+}
+
+// handlePassengerSelection adjusts the group or finishes the step
+func (h *Handler) handlePassengerSelection(ctx context.Context, callback messenger.Callback) {
+	chatID := callback.ChatID
+
+	h.statesMux.Lock()
+	state := h.userStates[chatID]
+	if state == nil || state.State != StateSelectPassengers {
+		h.statesMux.Unlock()
+		h.msgr.AnswerCallback(callback.ID, msgStaleSelection)
+		return
+	}
+
+	var notice string
+	switch {
+	case callback.Data == CallbackPassengerNoop:
+		h.statesMux.Unlock()
+		h.msgr.AnswerCallback(callback.ID, "")
+		return
+	case callback.Data == CallbackPassengerDone:
+		if state.Passengers.Total() == 0 {
+			h.statesMux.Unlock()
+			h.msgr.AnswerCallback(callback.ID, "En az bir yolcu seçmelisiniz.")
+			return
+		}
+		passengers := state.Passengers
+		h.statesMux.Unlock()
+
+		h.msgr.AnswerCallback(callback.ID, "")
+		h.msgr.Edit(chatID, callback.MessageID, "👥 *Yolcular:* "+passengers.Describe(), messenger.Markdown, nil)
+		h.checkRoute(ctx, chatID, state)
+		return
+	case strings.HasPrefix(callback.Data, CallbackPassengerInc):
+		typeID, err := strconv.Atoi(strings.TrimPrefix(callback.Data, CallbackPassengerInc))
+		if err != nil || !model.IsPassengerType(typeID) {
+			break
+		}
+		if state.Passengers.Total() >= MaxPassengers {
+			notice = fmt.Sprintf("En fazla %d yolcu seçebilirsiniz.", MaxPassengers)
+			break
+		}
+		state.Passengers = state.Passengers.With(typeID, state.Passengers.Count(typeID)+1)
+	case strings.HasPrefix(callback.Data, CallbackPassengerDec):
+		typeID, _ := strconv.Atoi(strings.TrimPrefix(callback.Data, CallbackPassengerDec))
+		if count := state.Passengers.Count(typeID); count > 0 {
+			state.Passengers = state.Passengers.With(typeID, count-1)
+		}
+	}
+	keyboard := passengerKeyboard(state.Passengers)
+	h.statesMux.Unlock()
+
+	h.msgr.AnswerCallback(callback.ID, notice)
+	h.msgr.Edit(chatID, callback.MessageID, msgSelectPassengers, messenger.Markdown, keyboard)
+}
@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"tcddbot/chart"
+	"tcddbot/messenger"
+	"tcddbot/model"
+	"tcddbot/store"
+	"tcddbot/util"
+	"tcddbot/worker"
+	"time"
+)
+
+const (
+	msgNoPriceHistory = "📈 Bu takip için henüz fiyat kaydı yok.\n" +
+		"Fiyatlar her kontrolde kaydedilir, biraz sonra tekrar deneyin."
+
+	// Latest changes shown per train and cabin class in the text history
+	priceHistoryLines = 6
+	// Telegram rejects messages over 4096 characters
+	priceHistoryMaxLength = 3500
+)
+
+// recordPrices stores the fares of a check. Failures only cost history, so
+// they are logged and the check goes on.
+func (h *Handler) recordPrices(ctx context.Context, departureID, arrivalID int, travelDate string, response *model.TCDDResponse) {
+	if response == nil {
+		return
+	}
+
+	check := store.PriceCheck{
+		DepartureStationID: departureID,
+		ArrivalStationID:   arrivalID,
+		TravelDate:         travelDate,
+	}
+	for _, quote := range util.Quotes(response.TrainLegs) {
+		check.Fares = append(check.Fares, store.PricePoint{
+			TrainNumber:    quote.Train.Number,
+			DepartureTime:  quote.DepartureTime,
+			CabinClass:     quote.CabinClass.Code,
+			CabinClassName: quote.CabinClass.Name,
+			Price:          quote.Fare.Amount,
+			Currency:       quote.Fare.Currency,
+		})
+	}
+
+	if err := h.prices.Record(ctx, check); err != nil {
+		log.Printf("Error recording prices of %d-%d on %s: %v", departureID, arrivalID, travelDate, err)
+	}
+}
+
+// cheapestSeat returns the cheapest priced seat among the seats a subscriber
+// was matched with.
+func cheapestSeat(availableSeats []util.SeatAvailability) (seat util.SeatAvailability, cabinClass string, fare util.Fare, ok bool) {
+	for _, s := range availableSeats {
+		for name, f := range s.Fares {
+			if ok && (f.Amount > fare.Amount || (f.Amount == fare.Amount && name >= cabinClass)) {
+				continue
+			}
+			seat, cabinClass, fare, ok = s, name, f, true
+		}
+	}
+	return seat, cabinClass, fare, ok
+}
+
+// watchesPrice reports whether a price drop could be reported to sub
+func (h *Handler) watchesPrice(sub worker.Subscriber) bool {
+	return h.cfg.PriceDropThreshold > 0 && sub.AlertPrice > 0
+}
+
+// resetAlertPrice measures later price drops on the day of job from the
+// current cheapest fare
+func (h *Handler) resetAlertPrice(ctx context.Context, job worker.Job, sub worker.Subscriber, availableSeats []util.SeatAvailability) error {
+	_, _, fare, ok := cheapestSeat(availableSeats)
+	if !ok || fare.Amount == sub.AlertPrice {
+		return nil
+	}
+	if err := h.subs.SetAlertPrice(ctx, sub.SubscriptionID, job.TravelDate, fare.Amount); err != nil {
+		return fmt.Errorf("update alert price: %w", err)
+	}
+	return nil
+}
+
+// checkPriceDrop notifies a subscriber whose cheapest fare on the day of job
+// fell by more than the threshold since the last alert on that day.
+func (h *Handler) checkPriceDrop(ctx context.Context, job worker.Job, sub worker.Subscriber, availableSeats []util.SeatAvailability) error {
+	seat, cabinClass, fare, ok := cheapestSeat(availableSeats)
+	if !ok || h.cfg.PriceDropThreshold <= 0 {
+		return nil
+	}
+	// The job was built at the last sync, an alert since then moved the price
+	alertPrice, err := h.subs.AlertPrice(ctx, sub.SubscriptionID, job.TravelDate)
+	if err != nil {
+		return fmt.Errorf("get alert price: %w", err)
+	}
+	sub.AlertPrice = alertPrice
+	if sub.AlertPrice == 0 {
+		return h.resetAlertPrice(ctx, job, sub, availableSeats)
+	}
+	if sub.AlertPrice-fare.Amount <= h.cfg.PriceDropThreshold {
+		return nil
+	}
+
+	if err := h.notifyPriceDrop(sub.ChatID, job, seat, cabinClass, sub.AlertPrice, fare); err != nil {
+		return fmt.Errorf("notify price drop: %w", err)
+	}
+	if err := h.subs.SetAlertPrice(ctx, sub.SubscriptionID, job.TravelDate, fare.Amount); err != nil {
+		return fmt.Errorf("update alert price: %w", err)
+	}
+	return nil
+}
+
+func (h *Handler) notifyPriceDrop(chatID int64, job worker.Job, seat util.SeatAvailability, cabinClass string, previous float64, fare util.Fare) error {
+	departureStationName, arrivalStationName := h.routeNames(job.DepartureStation, job.ArrivalStation)
+	previousFare := util.Fare{Amount: previous, Currency: fare.Currency}
+
+	msgText := fmt.Sprintf("📉 *Fiyat Düştü!*\n\n"+
+		"🚉 *Güzergah:* %s → %s\n"+
+		"🕒 *Kalkış Zamanı:* %s\n"+
+		"🎫 *Tren:* %s (%s)\n"+
+		"💺 *Sınıf:* %s\n"+
+		"💰 *Fiyat:* %s → %s (%s ucuzladı)\n\n"+
+		"📈 Fiyat geçmişi için /%s",
+		departureStationName,
+		arrivalStationName,
+		seat.DepartureTime.In(util.Istanbul).Format("02.01.2006 15:04"),
+		seat.Train.Name,
+		seat.Train.Type,
+		cabinClass,
+		previousFare.String(),
+		fare.String(),
+		util.Fare{Amount: previous - fare.Amount, Currency: fare.Currency}.String(),
+		CommandPriceHistory)
+
+	return h.msgr.SendText(chatID, msgText, messenger.Markdown)
+}
+
+// routeNames returns the names of the stations of a route
+func (h *Handler) routeNames(departureID, arrivalID int) (departure, arrival string) {
+	h.stationsMux.RLock()
+	defer h.stationsMux.RUnlock()
+
+	for _, station := range h.stations {
+		if station.ID == departureID {
+			departure = station.Name
+		}
+		if station.ID == arrivalID {
+			arrival = station.Name
+		}
+	}
+	return departure, arrival
+}
+
+// handlePriceHistoryStart lets the user pick the subscription to show
+func (h *Handler) handlePriceHistoryStart(ctx context.Context, msg messenger.Message) {
+	chatID := msg.ChatID
+
+	subscriptions, err := h.getActiveSubscriptions(ctx, chatID)
+	if err != nil {
+		log.Printf("Error getting subscriptions: %v", err)
+		h.msgr.SendText(chatID, "Abonelikleriniz getirilirken bir hata oluştu.", messenger.Plain)
+		return
+	}
+	if len(subscriptions) == 0 {
+		h.msgr.SendText(chatID, "Aktif aboneliğiniz bulunmamaktadır.", messenger.Plain)
+		return
+	}
+
+	var keyboard [][]messenger.Button
+	for _, sub := range subscriptions {
+		keyboard = append(keyboard, []messenger.Button{{
+			Text: fmt.Sprintf("📈 %s → %s (%s)", sub.DepartureStation, sub.ArrivalStation, sub.TravelDate),
+			Data: fmt.Sprintf("%s%d", PriceHistoryPrefix, sub.ID),
+		}})
+	}
+
+	h.msgr.SendWithButtons(chatID, "📈 Fiyat geçmişini görmek istediğiniz takibi seçin:", messenger.Plain, keyboard)
+}
+
+// handlePriceHistorySelection shows the history of a subscription as text
+// or as a chart
+func (h *Handler) handlePriceHistorySelection(ctx context.Context, callback messenger.Callback) {
+	chatID := callback.ChatID
+	asChart := strings.HasPrefix(callback.Data, PriceChartPrefix)
+	id := strings.TrimPrefix(strings.TrimPrefix(callback.Data, PriceHistoryPrefix), PriceChartPrefix)
+
+	subscriptionID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		log.Printf("Error parsing subscription ID: %v", err)
+		return
+	}
+
+	sub, err := h.subs.Get(ctx, subscriptionID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && sub.ChatID != chatID) {
+		h.msgr.AnswerCallback(callback.ID, "Bu takip artık aktif değil.")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting subscription %d: %v", subscriptionID, err)
+		h.msgr.AnswerCallback(callback.ID, "Bir hata oluştu. Lütfen daha sonra tekrar deneyin.")
+		return
+	}
+
+	// Every day of a range has its own history, a weekly series keeps the
+	// history of the days ahead only
+	since := time.Time{}
+	if sub.Recurring {
+		since = time.Now()
+	}
+	var points []store.PricePoint
+	for _, date := range sub.Dates(since) {
+		history, err := h.prices.History(ctx, sub.DepartureStationID, sub.ArrivalStationID, date)
+		if err != nil {
+			log.Printf("Error getting price history of subscription %d: %v", sub.ID, err)
+			h.msgr.AnswerCallback(callback.ID, "Bir hata oluştu. Lütfen daha sonra tekrar deneyin.")
+			return
+		}
+		points = append(points, history...)
+	}
+	series := priceSeries(points, seatFilter(*sub))
+
+	h.msgr.AnswerCallback(callback.ID, "")
+	if len(series) == 0 {
+		h.msgr.SendText(chatID, msgNoPriceHistory, messenger.Plain)
+		return
+	}
+
+	departure, arrival := h.routeNames(sub.DepartureStationID, sub.ArrivalStationID)
+	travelDates := formatTravelDates(sub.TravelDate, sub.TravelDateEnd)
+	if sub.Recurring {
+		travelDates = formatRecurrence(sub.Weekdays)
+	}
+	if !asChart {
+		h.msgr.SendWithButtons(chatID, formatPriceHistory(departure, arrival, travelDates, series), messenger.Markdown,
+			[][]messenger.Button{{{Text: "📈 Grafik olarak gönder", Data: fmt.Sprintf("%s%d", PriceChartPrefix, sub.ID)}}})
+		return
+	}
+
+	trend := lowestPrices(series)
+	if len(trend) == 0 {
+		h.msgr.SendText(chatID, msgNoPriceHistory, messenger.Plain)
+		return
+	}
+	png, err := chart.Steps(trend, time.Now(), util.Istanbul, util.FormatAmount)
+	if err != nil {
+		log.Printf("Error drawing price chart of subscription %d: %v", sub.ID, err)
+		h.msgr.SendText(chatID, "Grafik oluşturulurken bir hata oluştu.", messenger.Plain)
+		return
+	}
+	caption := fmt.Sprintf("📈 *%s → %s* (%s)\nUygun seferlerdeki en düşük kişi başı fiyat", departure, arrival, travelDates)
+	h.msgr.SendPhoto(chatID, "fiyat-gecmisi.png", png, caption, messenger.Markdown)
+}
+
+// fareSeries is the price history of one cabin class of one train
+type fareSeries struct {
+	TravelDate     string
+	TrainNumber    string
+	DepartureTime  time.Time
+	CabinClassName string
+	Points         []store.PricePoint // Zero prices mark the cabin class sold out
+}
+
+// priceKey identifies a train and cabin class on one day
+type priceKey struct {
+	date, train, cabin string
+}
+
+func pointKey(point store.PricePoint) priceKey {
+	return priceKey{point.TravelDate, point.TrainNumber, point.CabinClass}
+}
+
+// priceSeries splits history into one series per train and cabin class the
+// subscription accepts, ordered by departure time.
+func priceSeries(points []store.PricePoint, filter util.SeatFilter) []*fareSeries {
+	byKey := make(map[priceKey]*fareSeries)
+	var all []*fareSeries
+
+	for _, point := range points {
+		k := pointKey(point)
+		series := byKey[k]
+		if series == nil {
+			series = &fareSeries{TravelDate: point.TravelDate, TrainNumber: point.TrainNumber}
+			byKey[k] = series
+			all = append(all, series)
+		}
+		// Sold out markers only carry the train and cabin class
+		if !point.DepartureTime.IsZero() {
+			series.DepartureTime = point.DepartureTime
+			series.CabinClassName = point.CabinClassName
+		}
+		series.Points = append(series.Points, point)
+	}
+
+	var accepted []*fareSeries
+	for _, series := range all {
+		cabinClass := model.CabinClass{Code: series.Points[0].CabinClass, Name: series.CabinClassName}
+		if series.DepartureTime.IsZero() || !filter.Accepts(series.DepartureTime, cabinClass) {
+			continue
+		}
+		accepted = append(accepted, series)
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].DepartureTime.Before(accepted[j].DepartureTime) })
+	return accepted
+}
+
+// lowestPrices returns how the cheapest fare across series changed over
+// time. Periods where nothing is on sale keep the previous value.
+func lowestPrices(series []*fareSeries) []chart.Point {
+	var points []store.PricePoint
+	for _, s := range series {
+		points = append(points, s.Points...)
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].ObservedAt.Before(points[j].ObservedAt) })
+
+	latest := make(map[priceKey]float64)
+	var trend []chart.Point
+	for i, point := range points {
+		latest[pointKey(point)] = point.Price
+		// Evaluate once every point of a check is applied
+		if i+1 < len(points) && points[i+1].ObservedAt.Equal(point.ObservedAt) {
+			continue
+		}
+
+		lowest := 0.0
+		for _, price := range latest {
+			if price > 0 && (lowest == 0 || price < lowest) {
+				lowest = price
+			}
+		}
+		if lowest == 0 || (len(trend) > 0 && trend[len(trend)-1].Value == lowest) {
+			continue
+		}
+		trend = append(trend, chart.Point{Time: point.ObservedAt, Value: lowest})
+	}
+	return trend
+}
+
+// formatPriceHistory renders the latest changes of every series
+func formatPriceHistory(departure, arrival, travelDates string, series []*fareSeries) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "📈 *Fiyat Geçmişi*\n\n🚉 %s → %s\n📅 %s\n", departure, arrival, travelDates)
+
+	for i, s := range series {
+		var block strings.Builder
+		fmt.Fprintf(&block, "\n🚆 %s • %s • %s\n", s.TrainNumber,
+			s.DepartureTime.In(util.Istanbul).Format("02.01 15:04"), s.CabinClassName)
+
+		points := s.Points
+		if len(points) > priceHistoryLines {
+			points = points[len(points)-priceHistoryLines:]
+		}
+		previous := 0.0
+		if skipped := len(s.Points) - len(points); skipped > 0 {
+			previous = s.Points[skipped-1].Price
+		}
+		for _, point := range points {
+			observedAt := point.ObservedAt.In(util.Istanbul).Format("02.01 15:04")
+			if point.Price == 0 {
+				fmt.Fprintf(&block, "   %s  tükendi\n", observedAt)
+				previous = 0
+				continue
+			}
+
+			trend := ""
+			switch {
+			case previous > 0 && point.Price < previous:
+				trend = " 📉"
+			case previous > 0 && point.Price > previous:
+				trend = " 📈"
+			}
+			fare := util.Fare{Amount: point.Price, Currency: point.Currency}
+			fmt.Fprintf(&block, "   %s  %s%s\n", observedAt, fare.String(), trend)
+			previous = point.Price
+		}
+
+		if text.Len()+block.Len() > priceHistoryMaxLength {
+			fmt.Fprintf(&text, "\n… ve %d sefer/sınıf daha", len(series)-i)
+			break
+		}
+		text.WriteString(block.String())
+	}
+	return text.String()
+}
@@ -0,0 +1,116 @@
+package handlers_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"tcddbot/handlers/handlertest"
+	"tcddbot/store"
+	"tcddbot/util"
+)
+
+// The fixture has seats on a conventional train only, at 500, then 400, then
+// 380 per passenger a minute apart.
+
+func TestPriceDropAlert(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "price_drop.json"))
+	date := tomorrow()
+	subscribe(t, h, 42, 98, 1323, date)
+	user := h.User(42)
+
+	// The first alert lists the fares and sets the baseline
+	user.Watch()
+	h.RunChecks()
+	user.ExpectReply("ANKARA EKSPRESİ")
+
+	// 100 TL less is more than the threshold of 50
+	h.TCDD.Advance(time.Minute)
+	user.Watch()
+	h.RunChecks()
+	msg := user.ExpectReply("Fiyat Düştü")
+	if !strings.Contains(msg.Text, "500 TRY → 400 TRY") {
+		t.Fatalf("price drop alert: got %q, want 500 TRY → 400 TRY", msg.Text)
+	}
+
+	// The alert moved the baseline, 20 TL less is not worth another
+	h.TCDD.Advance(time.Minute)
+	user.Watch()
+	h.RunChecks()
+	user.ExpectNoReply()
+}
+
+func TestPriceDropBaselinePerDate(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "price_drop.json"))
+	subscribe(t, h, 42, 98, 1323, tomorrow())
+	user := h.User(42)
+
+	user.Watch()
+	h.RunChecks()
+	user.ExpectReply("ANKARA EKSPRESİ")
+
+	// A new day starts from its own fares, not from the baseline of another
+	dayAfter := time.Now().In(util.Istanbul).AddDate(0, 0, 2).Format(store.TravelDateLayout)
+	subscribe(t, h, 43, 98, 1323, dayAfter)
+	h.TCDD.Advance(time.Minute)
+	other := h.User(43).Watch()
+	user.Watch()
+	h.RunChecks()
+	user.ExpectReply("Fiyat Düştü")
+	other.ExpectReply("ANKARA EKSPRESİ")
+	for _, event := range other.Replies() {
+		if strings.Contains(event.Message.Text, "Fiyat Düştü") {
+			t.Fatalf("chat 43 got a price drop alert on its first check")
+		}
+	}
+}
+
+func TestPriceHistoryCommand(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "price_drop.json"))
+	subscribe(t, h, 42, 98, 1323, tomorrow())
+	user := h.User(42)
+
+	user.Say("/fiyatgecmisi")
+	user.Press("ANKARA GAR")
+	user.ExpectReply("henüz fiyat kaydı yok")
+
+	// Every check is recorded, unchanged prices only once
+	h.RunChecks()
+	h.TCDD.Advance(time.Minute)
+	h.RunChecks()
+	h.RunChecks()
+
+	user.Say("/fiyatgecmisi")
+	user.Press("ANKARA GAR")
+	msg := user.ExpectReply("Fiyat Geçmişi")
+	if !strings.Contains(msg.Text, "22001") {
+		t.Fatalf("history: got %q, want the conventional train 22001", msg.Text)
+	}
+	if got := strings.Count(msg.Text, "400 TRY 📉"); got != 1 {
+		t.Fatalf("history: got %d drops to 400 TRY, want 1 in %q", got, msg.Text)
+	}
+
+	user.Press("Grafik olarak gönder")
+	chart := user.LastMessage()
+	if len(chart.Photo) == 0 || !strings.Contains(chart.Text, "en düşük kişi başı fiyat") {
+		t.Fatalf("chart: got %+v, want a photo with its caption", chart)
+	}
+}
+
+func TestPriceHistoryOfAnotherChat(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "price_drop.json"))
+	subscribe(t, h, 42, 98, 1323, tomorrow())
+	h.RunChecks()
+
+	// Another chat can't read the history by pressing a copied button
+	user := h.User(42)
+	user.Say("/fiyatgecmisi")
+	button, ok := user.LastMessage().Button("ANKARA GAR")
+	if !ok {
+		t.Fatalf("no subscription button")
+	}
+	other := h.User(43)
+	other.Say("/start")
+	h.Dispatch(h.Telegram.CallbackUpdate(other.LastMessage(), button.Data))
+	other.ExpectReply("Bu takip artık aktif değil")
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"tcddbot/messenger"
+	"tcddbot/service"
+	"tcddbot/store"
+	"time"
+)
+
+const msgSelectRecurringWeekdays = "🔁 *Her Hafta*\n\n" +
+	"Her hafta hangi günler takip edilsin?\n" +
+	"• Birden fazla gün seçebilirsiniz\n" +
+	"• Seçim yapmazsanız tüm günler takip edilir"
+
+const msgPauseWeeks = "⏸ *Hafta Duraklatma*\n\n" +
+	"Duraklatılan haftada takip yapılmaz, sonraki haftalar devam eder.\n" +
+	"Duraklatmak veya devam ettirmek istediğiniz haftaya dokunun."
+
+// formatRecurrence describes the days a recurring subscription repeats on
+func formatRecurrence(weekdays []time.Weekday) string {
+	if len(weekdays) == 0 {
+		return "Her gün"
+	}
+	return "Her hafta " + formatWeekdays(weekdays)
+}
+
+// formatWeek shows the Monday to Sunday span of a week, e.g. 20.10 – 26.10
+func formatWeek(monday time.Time) string {
+	return monday.Format("02.01") + " – " + monday.AddDate(0, 0, 6).Format("02.01")
+}
+
+// upcomingWeeks returns the Mondays of the weeks a recurring subscription
+// checking the given number of weeks from today touches, the current week
+// first
+func upcomingWeeks(weeks int, today time.Time) []time.Time {
+	y, m, d := today.Date()
+	first := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 0, 7*max(weeks, 1)-1)
+	monday, _ := time.Parse(store.TravelDateLayout, store.WeekOf(first))
+
+	var mondays []time.Time
+	for ; !monday.After(last); monday = monday.AddDate(0, 0, 7) {
+		mondays = append(mondays, monday)
+	}
+	return mondays
+}
+
+// withinSaleHorizon reports whether TCDD already sells tickets for date
+func (h *Handler) withinSaleHorizon(date string, today time.Time) bool {
+	return service.OnSale(date, today, h.cfg.SaleHorizonDays)
+}
+
+// askRecurring switches the date step to a weekly series starting today
+func (h *Handler) askRecurring(callback messenger.Callback) {
+	chatID := callback.ChatID
+
+	h.statesMux.Lock()
+	state := h.userStates[chatID]
+	if state == nil || state.State != StateSelectDate {
+		h.statesMux.Unlock()
+		h.msgr.AnswerCallback(callback.ID, msgStaleSelection)
+		return
+	}
+	state.clearDates()
+	state.TravelDate = time.Now().Format(store.TravelDateLayout)
+	state.Recurring = true
+	state.Weeks = h.cfg.RecurringWeeks
+	// A match only settles its own day, the series goes on
+	state.KeepWatching = true
+	state.State = StateSelectWeekdays
+	keyboard := weekdayKeyboard(state.Weekdays)
+	h.statesMux.Unlock()
+
+	h.msgr.AnswerCallback(callback.ID, "")
+	h.msgr.SendWithButtons(chatID, msgSelectRecurringWeekdays, messenger.Markdown, keyboard)
+}
+
+// handlePauseMenu lists the upcoming weeks of a recurring subscription
+func (h *Handler) handlePauseMenu(ctx context.Context, callback messenger.Callback) {
+	subscriptionID, err := strconv.ParseInt(strings.TrimPrefix(callback.Data, PauseMenuPrefix), 10, 64)
+	if err != nil {
+		h.msgr.AnswerCallback(callback.ID, "")
+		return
+	}
+
+	sub := h.recurringSubscription(ctx, callback, subscriptionID)
+	if sub == nil {
+		return
+	}
+
+	h.msgr.AnswerCallback(callback.ID, "")
+	h.msgr.SendWithButtons(callback.ChatID, msgPauseWeeks, messenger.Markdown, pauseWeekKeyboard(*sub, time.Now()))
+}
+
+// handlePauseWeek pauses or resumes one week of a recurring subscription
+func (h *Handler) handlePauseWeek(ctx context.Context, callback messenger.Callback) {
+	idText, week, ok := strings.Cut(strings.TrimPrefix(callback.Data, PauseWeekPrefix), "_")
+	subscriptionID, err := strconv.ParseInt(idText, 10, 64)
+	if !ok || err != nil {
+		h.msgr.AnswerCallback(callback.ID, "")
+		return
+	}
+	monday, err := time.Parse(store.TravelDateLayout, week)
+	if err != nil {
+		h.msgr.AnswerCallback(callback.ID, "")
+		return
+	}
+
+	sub := h.recurringSubscription(ctx, callback, subscriptionID)
+	if sub == nil {
+		return
+	}
+
+	// Weeks already over are dropped while at it
+	thisWeek := upcomingWeeks(1, time.Now())[0]
+	var paused []string
+	resumed := false
+	for _, pausedWeek := range sub.PausedWeeks {
+		day, err := time.Parse(store.TravelDateLayout, pausedWeek)
+		switch {
+		case err != nil || day.Before(thisWeek):
+		case pausedWeek == week:
+			resumed = true
+		default:
+			paused = append(paused, pausedWeek)
+		}
+	}
+	if !resumed {
+		paused = append(paused, week)
+	}
+
+	if err := h.subs.SetPausedWeeks(ctx, sub.ID, paused); err != nil {
+		log.Printf("Error pausing week %s of subscription %d: %v", week, sub.ID, err)
+		h.msgr.AnswerCallback(callback.ID, "Bir hata oluştu. Lütfen daha sonra tekrar deneyin.")
+		return
+	}
+	sub.PausedWeeks = paused
+
+	answer := formatWeek(monday) + " haftası duraklatıldı."
+	if resumed {
+		answer = formatWeek(monday) + " haftası yeniden takip ediliyor."
+	}
+	h.msgr.AnswerCallback(callback.ID, answer)
+	h.msgr.Edit(callback.ChatID, callback.MessageID, msgPauseWeeks, messenger.Markdown, pauseWeekKeyboard(*sub, time.Now()))
+}
+
+// recurringSubscription loads a recurring subscription of the chat, answering
+// the callback when there is none
+func (h *Handler) recurringSubscription(ctx context.Context, callback messenger.Callback, subscriptionID int64) *store.Subscription {
+	sub, err := h.subs.Get(ctx, subscriptionID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Error getting subscription %d: %v", subscriptionID, err)
+		h.msgr.AnswerCallback(callback.ID, "Bir hata oluştu. Lütfen daha sonra tekrar deneyin.")
+		return nil
+	}
+	if err != nil || sub.ChatID != callback.ChatID || !sub.Recurring {
+		h.msgr.AnswerCallback(callback.ID, "Bu takip artık aktif değil.")
+		return nil
+	}
+	return sub
+}
+
+// pauseWeekKeyboard has a toggle for every week the subscription checks
+func pauseWeekKeyboard(sub store.Subscription, today time.Time) [][]messenger.Button {
+	paused := make(map[string]bool)
+	for _, week := range sub.PausedWeeks {
+		paused[week] = true
+	}
+
+	var keyboard [][]messenger.Button
+	for _, monday := range upcomingWeeks(sub.Weeks, today) {
+		week := monday.Format(store.TravelDateLayout)
+		text := "⏸ " + formatWeek(monday)
+		if paused[week] {
+			text = "▶️ " + formatWeek(monday) + " (duraklatıldı)"
+		}
+		keyboard = append(keyboard, []messenger.Button{
+			{Text: text, Data: fmt.Sprintf("%s%d_%s", PauseWeekPrefix, sub.ID, week)},
+		})
+	}
+	return keyboard
+}
+
+// pausedWeeksSummary lists the paused weeks that are not over yet
+func pausedWeeksSummary(pausedWeeks []string, today time.Time) string {
+	thisWeek := upcomingWeeks(1, today)[0]
+
+	var weeks []string
+	for _, week := range pausedWeeks {
+		monday, err := time.Parse(store.TravelDateLayout, week)
+		if err == nil && !monday.Before(thisWeek) {
+			weeks = append(weeks, formatWeek(monday))
+		}
+	}
+	return strings.Join(weeks, ", ")
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"tcddbot/messenger"
+	"tcddbot/store"
+)
+
+func TestUpcomingWeeks(t *testing.T) {
+	tests := []struct {
+		name  string
+		weeks int
+		today time.Time
+		want  []string
+	}{
+		{"from a Wednesday", 3, time.Date(2030, 6, 12, 8, 0, 0, 0, time.UTC), []string{"10-06-2030", "17-06-2030", "24-06-2030", "01-07-2030"}},
+		{"from a Monday", 1, time.Date(2030, 6, 10, 8, 0, 0, 0, time.UTC), []string{"10-06-2030"}},
+		{"from a Sunday", 1, time.Date(2030, 6, 16, 23, 0, 0, 0, time.UTC), []string{"10-06-2030", "17-06-2030"}},
+		{"no weeks", 0, time.Date(2030, 6, 10, 8, 0, 0, 0, time.UTC), []string{"10-06-2030"}},
+		{"over the new year", 1, time.Date(2030, 12, 31, 8, 0, 0, 0, time.UTC), []string{"30-12-2030", "06-01-2031"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, monday := range upcomingWeeks(tt.weeks, tt.today) {
+				got = append(got, monday.Format(store.TravelDateLayout))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPauseWeekKeyboard(t *testing.T) {
+	sub := store.Subscription{ID: 7, Recurring: true, Weeks: 1, PausedWeeks: []string{"03-06-2030", "17-06-2030"}}
+	// A Sunday, the series still reaches into the next week
+	today := time.Date(2030, 6, 16, 10, 0, 0, 0, time.UTC)
+
+	want := [][]messenger.Button{
+		{{Text: "⏸ 10.06 – 16.06", Data: "pause_week_7_10-06-2030"}},
+		{{Text: "▶️ 17.06 – 23.06 (duraklatıldı)", Data: "pause_week_7_17-06-2030"}},
+	}
+	got := pauseWeekKeyboard(sub, today)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if len(got[i]) != 1 || got[i][0] != want[i][0] {
+			t.Fatalf("row %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"tcddbot/messenger"
+	"tcddbot/service"
+	"tcddbot/store"
+	"tcddbot/util"
+	"tcddbot/worker"
+	"time"
+)
+
+const msgSelectRoundTripDates = "↔️ *Gidiş-Dönüş*\n\n" +
+	"Gidiş ve dönüş yönleri birlikte takip edilir.\n" +
+	"• Gidiş ve dönüş tarihini yazın, örn: 20-12-2026 27-12-2026\n" +
+	"• Yıl yazmazsanız en yakın tarih alınır, örn: 20-12 27-12"
+
+const msgSelectRoundTripMode = "🔔 *Bildirim Şekli*\n\n" +
+	"Gidiş-dönüş için ne zaman haber verilsin?"
+
+// legName names the direction of a round trip leg, empty for one way
+func legName(roundTrip, returnLeg bool) string {
+	switch {
+	case !roundTrip:
+		return ""
+	case returnLeg:
+		return "Dönüş"
+	}
+	return "Gidiş"
+}
+
+// askRoundTrip switches the date step to a round trip
+func (h *Handler) askRoundTrip(callback messenger.Callback) {
+	chatID := callback.ChatID
+
+	h.statesMux.Lock()
+	state := h.userStates[chatID]
+	if state == nil || (state.State != StateSelectDate && state.State != StateSelectRoundTripDates) {
+		h.statesMux.Unlock()
+		h.msgr.AnswerCallback(callback.ID, msgStaleSelection)
+		return
+	}
+	if state.ViaStation != "" {
+		h.statesMux.Unlock()
+		h.msgr.AnswerCallback(callback.ID, "Aktarmalı yolculuklarda gidiş-dönüş takibi desteklenmiyor.")
+		return
+	}
+	state.State = StateSelectRoundTripDates
+	h.statesMux.Unlock()
+
+	h.msgr.AnswerCallback(callback.ID, "")
+	h.msgr.SendText(chatID, msgSelectRoundTripDates, messenger.Markdown)
+}
+
+// handleRoundTripInput handles the typed outbound and return dates and asks
+// when to notify
+func (h *Handler) handleRoundTripInput(ctx context.Context, chatID int64, text string) {
+	h.statesMux.RLock()
+	state := h.userStates[chatID]
+	h.statesMux.RUnlock()
+	if state == nil || state.State != StateSelectRoundTripDates {
+		return
+	}
+
+	outbound, inbound, err := parseDateRange(text, time.Now())
+	if err != nil {
+		h.msgr.SendText(chatID, fmt.Sprintf("❌ Geçersiz tarihler. Dönüş gidişten önce olamaz ve en fazla %d gün "+
+			"sonra olabilir, örn: 20-12-2026 27-12-2026", MaxDateRangeDays), messenger.Plain)
+		return
+	}
+
+	depID, _ := strconv.Atoi(state.DepartureStation)
+	arrID, _ := strconv.Atoi(state.ArrivalStation)
+	outboundDate := outbound.Format(store.TravelDateLayout)
+	exists, err := h.subs.ExistsActive(ctx, chatID, depID, arrID, outboundDate)
+	if err != nil {
+		log.Printf("Error checking existing subscription: %v", err)
+		h.msgr.SendText(chatID, "Bir hata oluştu. Lütfen daha sonra tekrar deneyin.", messenger.Plain)
+		return
+	}
+	if exists {
+		h.msgr.SendText(chatID, "Bu güzergah için zaten bir takibiniz bulunmaktadır.", messenger.Plain)
+		return
+	}
+
+	h.statesMux.Lock()
+	state.clearDates()
+	state.TravelDate = outboundDate
+	state.ReturnDate = inbound.Format(store.TravelDateLayout)
+	state.State = StateSelectRoundTripMode
+	h.statesMux.Unlock()
+
+	h.msgr.SendText(chatID, fmt.Sprintf("↔️ *Gidiş:* %s\n↩️ *Dönüş:* %s", state.TravelDate, state.ReturnDate), messenger.Markdown)
+	h.msgr.SendWithButtons(chatID, msgSelectRoundTripMode, messenger.Markdown, [][]messenger.Button{
+		{{Text: "🔗 İki yönde de yer olunca", Data: CallbackRoundTripBoth}},
+		{{Text: "↔️ Her yön için ayrı ayrı", Data: CallbackRoundTripEach}},
+	})
+}
+
+// handleRoundTripMode stores the notification mode and asks for passengers
+func (h *Handler) handleRoundTripMode(ctx context.Context, callback messenger.Callback) {
+	chatID := callback.ChatID
+
+	h.statesMux.Lock()
+	state := h.userStates[chatID]
+	if state == nil || state.State != StateSelectRoundTripMode {
+		h.statesMux.Unlock()
+		h.msgr.AnswerCallback(callback.ID, msgStaleSelection)
+		return
+	}
+	state.BothLegs = callback.Data == CallbackRoundTripBoth
+	h.statesMux.Unlock()
+
+	h.msgr.AnswerCallback(callback.ID, "")
+	h.msgr.Edit(chatID, callback.MessageID, "🔔 *Bildirim:* "+roundTripModeName(state.BothLegs), messenger.Markdown, nil)
+	h.askPassengers(chatID, state)
+}
+
+// roundTripModeName describes when a round trip is reported
+func roundTripModeName(bothLegs bool) string {
+	if bothLegs {
+		return "İki yönde de yer olunca"
+	}
+	return "Her yön için ayrı ayrı"
+}
+
+// createRoundTrip stores both legs of the round trip being built. The time
+// window was chosen for the outbound leg, the way back accepts any time.
+func (h *Handler) createRoundTrip(ctx context.Context, chatID int64, state *UserState) error {
+	depID, _ := strconv.Atoi(state.DepartureStation)
+	arrID, _ := strconv.Atoi(state.ArrivalStation)
+
+	outbound := store.Subscription{
+		ChatID:             chatID,
+		DepartureStationID: depID,
+		ArrivalStationID:   arrID,
+		TravelDate:         state.TravelDate,
+		BothLegs:           state.BothLegs,
+		CabinClasses:       state.CabinClasses,
+		EarliestDeparture:  state.EarliestDeparture,
+		LatestDeparture:    state.LatestDeparture,
+		Passengers:         state.Passengers,
+		MaxPrice:           state.MaxPrice,
+	}
+	inbound := outbound
+	inbound.DepartureStationID, inbound.ArrivalStationID = arrID, depID
+	inbound.TravelDate = state.ReturnDate
+	inbound.EarliestDeparture, inbound.LatestDeparture = "", ""
+
+	return h.subs.CreateRoundTrip(ctx, &outbound, &inbound)
+}
+
+// returnLeg finds the active return leg of a round trip, nil if it is gone
+func (h *Handler) returnLeg(ctx context.Context, sub worker.Subscriber) (*store.Subscription, error) {
+	subs, err := h.subs.ListActiveByChat(ctx, sub.ChatID)
+	if err != nil {
+		return nil, fmt.Errorf("list round trip legs: %w", err)
+	}
+	for _, leg := range subs {
+		if leg.RoundTripID == sub.RoundTripID && leg.ReturnLeg {
+			return &leg, nil
+		}
+	}
+	return nil, nil
+}
+
+// notifyRoundTrip reports a round trip that waits for both legs once the
+// way back has seats too. Only the outbound leg is scheduled, it checks the
+// return leg itself.
+func (h *Handler) notifyRoundTrip(ctx context.Context, job worker.Job, sub worker.Subscriber, outboundSeats []util.SeatAvailability) error {
+	inbound, err := h.returnLeg(ctx, sub)
+	if err != nil {
+		return err
+	}
+	if inbound == nil {
+		sub.RoundTripID = 0
+		return h.notifySubscriber(ctx, job, sub, outboundSeats)
+	}
+
+	response, err := h.trainSvc.CheckAvailability(ctx, inbound.DepartureStationID, inbound.ArrivalStationID,
+		inbound.TravelDate, inbound.Passengers.OrDefault())
+	if err != nil {
+		if errors.Is(err, service.ErrNoServiceOnDate) || errors.Is(err, service.ErrSalesNotOpen) {
+			return nil
+		}
+		return fmt.Errorf("check return leg: %w", err)
+	}
+	h.recordPrices(ctx, inbound.DepartureStationID, inbound.ArrivalStationID, inbound.TravelDate, response)
+
+	inboundSeats := util.FindAvailableSeats(response.TrainLegs, seatFilter(*inbound))
+	if len(inboundSeats) == 0 {
+		return nil
+	}
+
+	// A nearby route of the trip may have notified already
+	if !h.claimNotification(sub) {
+		return nil
+	}
+	if err := h.msgr.SendText(sub.ChatID, "↔️ *Gidiş-Dönüş:* İki yönde de uygun koltuk var!", messenger.Markdown); err != nil {
+		h.releaseNotification(sub)
+		return fmt.Errorf("notify round trip: %w", err)
+	}
+	outboundYHT, err := h.notifyLeg(sub.ChatID, outboundSeats, job.DepartureStation, job.ArrivalStation, "Gidiş")
+	if err != nil {
+		return err
+	}
+	inboundYHT, err := h.notifyLeg(sub.ChatID, inboundSeats, inbound.DepartureStationID, inbound.ArrivalStationID, "Dönüş")
+	if err != nil {
+		return err
+	}
+
+	// A YHT both ways settles the trip, otherwise both legs go on hourly. A
+	// nearby station on the way out never settles it.
+	for _, id := range []int64{sub.SubscriptionID, inbound.ID} {
+		if outboundYHT && inboundYHT && !sub.Nearby {
+			err = h.subs.Deactivate(ctx, id)
+		} else {
+			err = h.subs.MarkNotified(ctx, id)
+		}
+		if err != nil {
+			return fmt.Errorf("update round trip leg %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// notifyLeg reports the seats of one leg the way notifySubscriber does, the
+// first YHT alone if there is one. It reports whether a YHT was found.
+func (h *Handler) notifyLeg(chatID int64, seats []util.SeatAvailability, departureStationID, arrivalStationID int, leg string) (bool, error) {
+	for _, seat := range seats {
+		if seat.IsYHT {
+			if err := h.notifyAvailability(chatID, seat, departureStationID, arrivalStationID, availabilityDetails("", leg)); err != nil {
+				return false, fmt.Errorf("notify YHT availability: %w", err)
+			}
+			return true, nil
+		}
+	}
+
+	for _, seat := range seats {
+		if err := h.notifyAvailability(chatID, seat, departureStationID, arrivalStationID, availabilityDetails("", leg)); err != nil {
+			return false, fmt.Errorf("notify availability: %w", err)
+		}
+	}
+	return false, nil
+}
+
+// deactivateOrphanLegs ends return legs that wait for an outbound leg which
+// expired or was settled on its own
+func (h *Handler) deactivateOrphanLegs(ctx context.Context) (int, error) {
+	subs, err := h.subs.ListActive(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	outbound := make(map[int64]bool)
+	for _, sub := range subs {
+		if sub.IsRoundTrip() && !sub.ReturnLeg {
+			outbound[sub.RoundTripID] = true
+		}
+	}
+
+	count := 0
+	for _, sub := range subs {
+		if !sub.ReturnLeg || !sub.BothLegs || outbound[sub.RoundTripID] {
+			continue
+		}
+		if err := h.subs.Deactivate(ctx, sub.ID); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,70 @@
+package handlers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tcddbot/handlers/handlertest"
+	"tcddbot/store"
+	"tcddbot/util"
+)
+
+// subscribeRoundTrip stores a round trip from Ankara to Bostancı tomorrow
+// and back the day after that is reported once both legs have seats.
+func subscribeRoundTrip(t *testing.T, h *handlertest.Harness) (outbound, inbound store.Subscription) {
+	t.Helper()
+	outbound = store.Subscription{ChatID: 42, DepartureStationID: 98, ArrivalStationID: 1323, TravelDate: tomorrow(), BothLegs: true}
+	inbound = outbound
+	inbound.DepartureStationID, inbound.ArrivalStationID = 1323, 98
+	inbound.TravelDate = time.Now().In(util.Istanbul).AddDate(0, 0, 2).Format(store.TravelDateLayout)
+	if err := h.Store.Subscriptions.CreateRoundTrip(context.Background(), &outbound, &inbound); err != nil {
+		t.Fatalf("create round trip: %v", err)
+	}
+	return outbound, inbound
+}
+
+func TestRoundTripWaitsForBothLegs(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "round_trip.json"))
+	_, inbound := subscribeRoundTrip(t, h)
+	user := h.User(42)
+
+	// Seats on the way out only
+	user.Watch()
+	h.RunChecks()
+	user.ExpectNoReply()
+	if hits := h.TCDD.Hits(1323, 98, inbound.TravelDate); hits != 1 {
+		t.Fatalf("return leg checks: got %d, want 1", hits)
+	}
+
+	// The way back has seats a minute in
+	h.TCDD.Advance(time.Minute)
+	user.Watch()
+	h.RunChecks()
+	user.ExpectReply("İki yönde de uygun koltuk var")
+	user.ExpectReply("*Yön:* Gidiş")
+	user.ExpectReply("*Yön:* Dönüş")
+	// A YHT both ways settles the trip
+	if subs := h.ActiveSubscriptions(42); len(subs) != 0 {
+		t.Fatalf("active legs after a YHT both ways: %+v", subs)
+	}
+}
+
+func TestRoundTripWithoutOutboundSeats(t *testing.T) {
+	h := handlertest.New(t, loadFixture(t, "round_trip.json"))
+	_, inbound := subscribeRoundTrip(t, h)
+	user := h.User(42)
+
+	// The way back has seats but the way out is sold out
+	h.TCDD.Advance(2 * time.Minute)
+	user.Watch()
+	h.RunChecks()
+	user.ExpectNoReply()
+	// The return leg is only checked for the outbound leg
+	if hits := h.TCDD.Hits(1323, 98, inbound.TravelDate); hits != 0 {
+		t.Fatalf("return leg checks: got %d, want 0", hits)
+	}
+	if subs := h.ActiveSubscriptions(42); len(subs) != 2 {
+		t.Fatalf("active legs: got %d, want 2", len(subs))
+	}
+}
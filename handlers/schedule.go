@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"tcddbot/store"
+	"tcddbot/util"
+	"tcddbot/worker"
+	"time"
+)
+
+// checkIntervals sets how often a route is checked by days to departure.
+// Seats of close departures come and go quickly, a trip a month away can
+// wait. Routes with moving seats are checked up to four times as often.
+var checkIntervals = []struct {
+	days     int
+	interval time.Duration
+}{
+	{1, 15 * time.Second},
+	{3, time.Minute},
+	{7, 3 * time.Minute},
+	{14, 10 * time.Minute},
+}
+
+// farCheckInterval applies beyond the last entry of checkIntervals
+const farCheckInterval = 30 * time.Minute
+
+// checkInterval is how often a job is checked while its seats stay put
+func checkInterval(job worker.Job, now time.Time) time.Duration {
+	date, err := time.ParseInLocation(store.TravelDateLayout, job.TravelDate, util.Istanbul)
+	if err != nil {
+		return farCheckInterval
+	}
+	y, m, d := now.In(util.Istanbul).Date()
+	days := int(date.Sub(time.Date(y, m, d, 0, 0, 0, 0, util.Istanbul)).Hours() / 24)
+
+	for _, step := range checkIntervals {
+		if days <= step.days {
+			return step.interval
+		}
+	}
+	return farCheckInterval
+}
+
+// syncSchedule loads the current subscriptions into the scheduler
+func (h *Handler) syncSchedule(ctx context.Context, now time.Time) {
+	jobs, err := h.collectJobs(ctx)
+	if err != nil {
+		log.Printf("Error querying subscriptions: %v", err)
+		return
+	}
+	h.scheduler.Sync(jobs, now)
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"tcddbot/util"
+	"tcddbot/worker"
+)
+
+func TestCheckIntervalByDaysToDeparture(t *testing.T) {
+	// Days are counted by the calendar in Istanbul, not in hours from now
+	now := time.Date(2030, 6, 1, 23, 30, 0, 0, util.Istanbul)
+	tests := []struct {
+		date string
+		want time.Duration
+	}{
+		{"01-06-2030", 15 * time.Second},
+		{"02-06-2030", 15 * time.Second},
+		{"03-06-2030", time.Minute},
+		{"04-06-2030", time.Minute},
+		{"05-06-2030", 3 * time.Minute},
+		{"08-06-2030", 3 * time.Minute},
+		{"09-06-2030", 10 * time.Minute},
+		{"15-06-2030", 10 * time.Minute},
+		{"16-06-2030", farCheckInterval},
+		{"01-09-2030", farCheckInterval},
+		{"not a date", farCheckInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.date, func(t *testing.T) {
+			got := checkInterval(worker.Job{TravelDate: tt.date}, now)
+			if got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
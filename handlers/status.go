@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"tcddbot/messenger"
+	"tcddbot/service"
+	"time"
+)
+
+// breakerStateNames describes the circuit breaker states to the admin
+var breakerStateNames = map[service.BreakerState]string{
+	service.BreakerClosed:   "✅ Erişilebilir",
+	service.BreakerOpen:     "⚠️ Erişilemiyor, kontroller duraklatıldı",
+	service.BreakerHalfOpen: "🔄 Tekrar deneniyor",
+}
+
+// handleStatus shows the admin how the periodic checks are doing. Other
+// users get no answer, as for an unknown command.
+func (h *Handler) handleStatus(msg messenger.Message) {
+	if msg.ChatID != h.cfg.AdminChatID {
+		return
+	}
+
+	stats := h.workerPool.Stats()
+	schedule := h.scheduler.Stats(time.Now())
+	budget := "sınırsız"
+	if schedule.Budget > 0 {
+		budget = fmt.Sprintf("%d / %d", schedule.Used, schedule.Budget)
+	}
+
+	msgText := fmt.Sprintf("📊 *Bot Durumu*\n\n"+
+		"*Zamanlama*\n"+
+		"• Takip edilen sefer: %d\n"+
+		"• Sırası gelmiş, bekleyen: %d\n"+
+		"• Bu dakikaki istek: %s\n\n"+
+		"*Kontroller*\n"+
+		"• Kuyrukta: %d\n"+
+		"• Çalışan: %d\n"+
+		"• Başarılı: %d\n"+
+		"• Başarısız: %d\n"+
+		"• Kuyruk dolu olduğu için atlanan: %d\n"+
+		"• Önceki kontrol sürdüğü için atlanan: %d\n\n"+
+		"🔌 *TCDD API:* %s\n\n"+
+		"%s\n\n"+
+		"%s\n\n"+
+		"🕒 Tarih: %s",
+		schedule.Jobs,
+		schedule.Due,
+		budget,
+		stats.Queued,
+		stats.Running,
+		stats.Succeeded,
+		stats.Failed,
+		stats.Dropped,
+		stats.Deduped,
+		breakerStateNames[h.trainSvc.Breaker().State()],
+		limiterSummary(h.trainSvc.Limiter().Stats()),
+		cacheSummary(h.trainSvc.Cache().Stats()),
+		time.Now().Format("02.01.2006 15:04:05"))
+
+	h.msgr.SendText(msg.ChatID, msgText, messenger.Markdown)
+}
+
+// limiterSummary describes how much of the TCDD request budget is in use
+func limiterSummary(stats service.LimiterStats) string {
+	if stats.Rate <= 0 {
+		return "🚦 *TCDD İstek Sınırı:* Yok"
+	}
+	return fmt.Sprintf("🚦 *TCDD İstek Sınırı:* Saniyede %s istek, en fazla %d art arda\n"+
+		"• Kullanılabilir: %d\n"+
+		"• Bu dakika: %d kullanıcı, %d arka plan\n"+
+		"• Bekleyen: %d kullanıcı, %d arka plan",
+		strconv.FormatFloat(stats.Rate, 'f', -1, 64),
+		stats.Burst,
+		stats.Available,
+		stats.Granted[service.PriorityInteractive],
+		stats.Granted[service.PriorityBackground],
+		stats.Waiting[service.PriorityInteractive],
+		stats.Waiting[service.PriorityBackground])
+}
+
+// cacheSummary shows how many searches the response cache saved
+func cacheSummary(stats service.CacheStats) string {
+	if stats.TTL <= 0 {
+		return "🗄 *Yanıt Önbelleği:* Kapalı"
+	}
+	return fmt.Sprintf("🗄 *Yanıt Önbelleği:* %d sn\n"+
+		"• Kayıt: %d\n"+
+		"• Önbellekten: %d\n"+
+		"• Aynı anda sorulan, ortak yanıt: %d\n"+
+		"• TCDD'ye giden: %d",
+		int(stats.TTL.Seconds()),
+		stats.Entries,
+		stats.Hits,
+		stats.Shared,
+		stats.Misses)
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"context"
+
+	"tcddbot/messenger"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// HandleUpdate dispatches a Telegram update to HandleMessage or HandleCallback
+func (h *Handler) HandleUpdate(ctx context.Context, update tgbotapi.Update) {
+	msg, callback := messenger.FromTelegram(update)
+	switch {
+	case callback != nil:
+		h.HandleCallback(ctx, *callback)
+	case msg != nil:
+		h.HandleMessage(ctx, *msg)
+	}
+}
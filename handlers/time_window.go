@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"tcddbot/messenger"
+	"time"
+)
+
+const msgSelectTimeWindow = "🕒 *Kalkış Saati Aralığı*\n\n" +
+	"Hangi saatler arasında kalkan trenleri takip edelim?\n" +
+	"• Hazır aralıklardan birini seçin\n" +
+	"• Ya da aralığı yazın, örn: 07:00-09:30\n" +
+	"• Yalnızca başlangıç (07:00-) veya bitiş (-09:30) da yazabilirsiniz"
+
+const msgInvalidTimeWindow = "❌ Geçersiz saat aralığı. Lütfen SS:DD-SS:DD formatında girin, örn: 07:00-09:30"
+
+// timeWindowPresets are offered as buttons, the callback data carries the window
+var timeWindowPresets = []struct {
+	label  string
+	window string
+}{
+	{"🌅 Sabah (06:00-12:00)", "06:00-12:00"},
+	{"☀️ Öğle (12:00-18:00)", "12:00-18:00"},
+	{"🌙 Akşam (18:00-23:59)", "18:00-23:59"},
+}
+
+func timeWindowKeyboard() [][]messenger.Button {
+	var keyboard [][]messenger.Button
+	for _, preset := range timeWindowPresets {
+		keyboard = append(keyboard, []messenger.Button{
+			{Text: preset.label, Data: CallbackTimePrefix + preset.window},
+		})
+	}
+	return append(keyboard, []messenger.Button{
+		{Text: "Tüm Gün", Data: CallbackTimeAny},
+	})
+}
+
+// parseTimeWindow parses "07:00-09:30", "07:00-" or "-09:30". Dots are
+// accepted in place of colons.
+func parseTimeWindow(text string) (earliest, latest string, err error) {
+	text = strings.ReplaceAll(strings.TrimSpace(text), ".", ":")
+	from, to, found := strings.Cut(text, "-")
+	if !found {
+		return "", "", errors.New("missing separator")
+	}
+
+	parse := func(value string) (string, error) {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return "", nil
+		}
+		clock, err := time.Parse("15:04", value)
+		if err != nil {
+			return "", err
+		}
+		return clock.Format("15:04"), nil
+	}
+
+	if earliest, err = parse(from); err != nil {
+		return "", "", err
+	}
+	if latest, err = parse(to); err != nil {
+		return "", "", err
+	}
+	if earliest == "" && latest == "" {
+		return "", "", errors.New("empty window")
+	}
+	if earliest != "" && latest != "" && earliest > latest {
+		return "", "", errors.New("window ends before it starts")
+	}
+	return earliest, latest, nil
+}
+
+// formatTimeWindow describes a departure window for the user, empty if open
+func formatTimeWindow(earliest, latest string) string {
+	switch {
+	case earliest != "" && latest != "":
+		return earliest + "-" + latest
+	case earliest != "":
+		return earliest + " sonrası"
+	case latest != "":
+		return latest + " öncesi"
+	}
+	return ""
+}
+
+// askTimeWindow starts the departure time step once the date is known
+func (h *Handler) askTimeWindow(chatID int64, state *UserState) {
+	h.statesMux.Lock()
+	state.State = StateSelectTimeWindow
+	state.EarliestDeparture = ""
+	state.LatestDeparture = ""
+	h.statesMux.Unlock()
+
+	h.msgr.SendWithButtons(chatID, msgSelectTimeWindow, messenger.Markdown, timeWindowKeyboard())
+}
+
+// handleTimeWindowSelection handles the preset and "all day" buttons
+func (h *Handler) handleTimeWindowSelection(ctx context.Context, callback messenger.Callback) {
+	chatID := callback.ChatID
+	state := h.timeWindowState(chatID)
+	if state == nil {
+		h.msgr.AnswerCallback(callback.ID, msgStaleSelection)
+		return
+	}
+	h.msgr.AnswerCallback(callback.ID, "")
+
+	var earliest, latest string
+	if callback.Data != CallbackTimeAny {
+		var err error
+		earliest, latest, err = parseTimeWindow(strings.TrimPrefix(callback.Data, CallbackTimePrefix))
+		if err != nil {
+			return
+		}
+	}
+
+	summary := formatTimeWindow(earliest, latest)
+	if summary == "" {
+		summary = "Tüm gün"
+	}
+	h.msgr.Edit(chatID, callback.MessageID, "🕒 *Kalkış Saati:* "+summary, messenger.Markdown, nil)
+	h.setTimeWindow(ctx, chatID, state, earliest, latest)
+}
+
+// handleTimeWindowInput handles a window typed by the user
+func (h *Handler) handleTimeWindowInput(ctx context.Context, chatID int64, text string) {
+	state := h.timeWindowState(chatID)
+	if state == nil {
+		return
+	}
+
+	earliest, latest, err := parseTimeWindow(text)
+	if err != nil {
+		h.msgr.SendText(chatID, msgInvalidTimeWindow, messenger.Plain)
+		return
+	}
+
+	h.msgr.SendText(chatID, "🕒 *Kalkış Saati:* "+formatTimeWindow(earliest, latest), messenger.Markdown)
+	h.setTimeWindow(ctx, chatID, state, earliest, latest)
+}
+
+func (h *Handler) timeWindowState(chatID int64) *UserState {
+	h.statesMux.RLock()
+	defer h.statesMux.RUnlock()
+
+	state := h.userStates[chatID]
+	if state == nil || state.State != StateSelectTimeWindow {
+		return nil
+	}
+	return state
+}
+
+func (h *Handler) setTimeWindow(ctx context.Context, chatID int64, state *UserState, earliest, latest string) {
+	h.statesMux.Lock()
+	state.EarliestDeparture = earliest
+	state.LatestDeparture = latest
+	h.statesMux.Unlock()
+
+	h.askCabinClass(ctx, chatID, state)
+}
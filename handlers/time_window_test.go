@@ -0,0 +1,63 @@
+package handlers
+
+import "testing"
+
+func TestParseTimeWindow(t *testing.T) {
+	tests := []struct {
+		text             string
+		earliest, latest string
+		ok               bool
+	}{
+		{"07:00-09:30", "07:00", "09:30", true},
+		{" 7:00 - 9:30 ", "07:00", "09:30", true},
+		{"07.00-09.30", "07:00", "09:30", true},
+		{"07:00-", "07:00", "", true},
+		{"-09:30", "", "09:30", true},
+		{"18:00-23:59", "18:00", "23:59", true},
+		{"00:00-23:59", "00:00", "23:59", true},
+		{"09:30-09:30", "09:30", "09:30", true},
+		{"09:30-07:00", "", "", false},
+		{"18:00-24:00", "", "", false},
+		{"25:00-", "", "", false},
+		{"07:60-", "", "", false},
+		{"-", "", "", false},
+		{"07:00", "", "", false},
+		{"sabah", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			earliest, latest, err := parseTimeWindow(tt.text)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got error %v, want ok %v", err, tt.ok)
+			}
+			if earliest != tt.earliest || latest != tt.latest {
+				t.Fatalf("got %q-%q, want %q-%q", earliest, latest, tt.earliest, tt.latest)
+			}
+		})
+	}
+}
+
+func TestTimeWindowPresetsParse(t *testing.T) {
+	for _, preset := range timeWindowPresets {
+		if _, _, err := parseTimeWindow(preset.window); err != nil {
+			t.Errorf("preset %s: %v", preset.label, err)
+		}
+	}
+}
+
+func TestFormatTimeWindow(t *testing.T) {
+	tests := []struct {
+		earliest, latest string
+		want             string
+	}{
+		{"07:00", "09:30", "07:00-09:30"},
+		{"07:00", "", "07:00 sonrası"},
+		{"", "09:30", "09:30 öncesi"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := formatTimeWindow(tt.earliest, tt.latest); got != tt.want {
+			t.Errorf("formatTimeWindow(%q, %q): got %q, want %q", tt.earliest, tt.latest, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Package messenger abstracts the chat front-end the bot talks through, so
+// the subscription logic in handlers does not depend on Telegram.
+package messenger
+
+// Format tells the front-end how to render message text.
+type Format int
+
+const (
+	Plain Format = iota
+	Markdown
+)
+
+// Button is an inline button; Data is handed back in Callback.Data when the
+// user presses it.
+type Button struct {
+	Text string
+	Data string
+}
+
+// Messenger sends messages to users.
+type Messenger interface {
+	SendText(chatID int64, text string, format Format) error
+	SendWithButtons(chatID int64, text string, format Format, buttons [][]Button) error
+	Edit(chatID int64, messageID int, text string, format Format, buttons [][]Button) error
+	AnswerCallback(callbackID, text string) error
+	// SendPhoto sends a PNG image named name with a caption below it.
+	SendPhoto(chatID int64, name string, png []byte, caption string, format Format) error
+}
+
+// User describes the sender of an incoming message.
+type User struct {
+	UserName  string
+	FirstName string
+	LastName  string
+}
+
+// Message is an incoming text message. Command is set, without the leading
+// slash, when the message is a bot command; Arguments holds the rest.
+type Message struct {
+	ChatID    int64
+	MessageID int
+	Text      string
+	Command   string
+	Arguments string
+	From      User
+}
+
+// Callback is an inline button press on a message the bot sent earlier.
+type Callback struct {
+	ID          string
+	ChatID      int64
+	MessageID   int
+	MessageText string
+	Data        string
+}
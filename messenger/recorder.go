@@ -0,0 +1,91 @@
+package messenger
+
+import (
+	"strings"
+	"sync"
+)
+
+// Sent is one call recorded by Recorder.
+type Sent struct {
+	Method     string // SendText, SendWithButtons, Edit, AnswerCallback or SendPhoto
+	ChatID     int64
+	MessageID  int
+	Text       string // Photo caption for SendPhoto
+	Format     Format
+	Buttons    [][]Button
+	CallbackID string
+	Photo      []byte
+}
+
+// Recorder is an in-memory Messenger for unit tests.
+type Recorder struct {
+	mu   sync.Mutex
+	sent []Sent
+	Err  error // Returned by every call when set
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) SendText(chatID int64, text string, format Format) error {
+	return r.record(Sent{Method: "SendText", ChatID: chatID, Text: text, Format: format})
+}
+
+func (r *Recorder) SendWithButtons(chatID int64, text string, format Format, buttons [][]Button) error {
+	return r.record(Sent{Method: "SendWithButtons", ChatID: chatID, Text: text, Format: format, Buttons: buttons})
+}
+
+func (r *Recorder) Edit(chatID int64, messageID int, text string, format Format, buttons [][]Button) error {
+	return r.record(Sent{Method: "Edit", ChatID: chatID, MessageID: messageID, Text: text, Format: format, Buttons: buttons})
+}
+
+func (r *Recorder) AnswerCallback(callbackID, text string) error {
+	return r.record(Sent{Method: "AnswerCallback", CallbackID: callbackID, Text: text})
+}
+
+func (r *Recorder) SendPhoto(chatID int64, name string, png []byte, caption string, format Format) error {
+	return r.record(Sent{Method: "SendPhoto", ChatID: chatID, Text: caption, Format: format, Photo: png})
+}
+
+func (r *Recorder) record(sent Sent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, sent)
+	return r.Err
+}
+
+// Sent returns every recorded call in order.
+func (r *Recorder) Sent() []Sent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Sent(nil), r.sent...)
+}
+
+// SentTo returns the recorded calls addressed to chatID.
+func (r *Recorder) SentTo(chatID int64) []Sent {
+	var sent []Sent
+	for _, s := range r.Sent() {
+		if s.ChatID == chatID {
+			sent = append(sent, s)
+		}
+	}
+	return sent
+}
+
+// Contains reports whether any message sent to chatID contains text.
+func (r *Recorder) Contains(chatID int64, text string) bool {
+	for _, s := range r.SentTo(chatID) {
+		if strings.Contains(s.Text, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reset forgets every recorded call.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.sent = nil
+	r.mu.Unlock()
+}
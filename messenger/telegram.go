@@ -0,0 +1,111 @@
+package messenger
+
+import (
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram implements Messenger on top of the Telegram Bot API.
+type Telegram struct {
+	bot *tgbotapi.BotAPI
+}
+
+func NewTelegram(bot *tgbotapi.BotAPI) *Telegram {
+	return &Telegram{bot: bot}
+}
+
+// Bot returns the underlying Bot API client.
+func (t *Telegram) Bot() *tgbotapi.BotAPI {
+	return t.bot
+}
+
+func (t *Telegram) SendText(chatID int64, text string, format Format) error {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = parseMode(format)
+	_, err := t.bot.Send(msg)
+	return err
+}
+
+func (t *Telegram) SendWithButtons(chatID int64, text string, format Format, buttons [][]Button) error {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = parseMode(format)
+	if len(buttons) > 0 {
+		msg.ReplyMarkup = keyboard(buttons)
+	}
+	_, err := t.bot.Send(msg)
+	return err
+}
+
+func (t *Telegram) Edit(chatID int64, messageID int, text string, format Format, buttons [][]Button) error {
+	msg := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	msg.ParseMode = parseMode(format)
+	if len(buttons) > 0 {
+		markup := keyboard(buttons)
+		msg.ReplyMarkup = &markup
+	}
+	_, err := t.bot.Send(msg)
+	return err
+}
+
+func (t *Telegram) AnswerCallback(callbackID, text string) error {
+	// answerCallbackQuery returns true rather than a Message, so use Request
+	_, err := t.bot.Request(tgbotapi.NewCallback(callbackID, text))
+	return err
+}
+
+func (t *Telegram) SendPhoto(chatID int64, name string, png []byte, caption string, format Format) error {
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: name, Bytes: png})
+	photo.Caption = caption
+	photo.ParseMode = parseMode(format)
+	_, err := t.bot.Send(photo)
+	return err
+}
+
+// FromTelegram converts a Telegram update into the front-end neutral types.
+// At most one of the results is non-nil.
+func FromTelegram(update tgbotapi.Update) (*Message, *Callback) {
+	if update.CallbackQuery != nil {
+		cq := update.CallbackQuery
+		callback := &Callback{ID: cq.ID, Data: cq.Data}
+		if cq.Message != nil {
+			callback.ChatID = cq.Message.Chat.ID
+			callback.MessageID = cq.Message.MessageID
+			callback.MessageText = cq.Message.Text
+		}
+		return nil, callback
+	}
+
+	if update.Message == nil {
+		return nil, nil
+	}
+
+	msg := &Message{
+		ChatID:    update.Message.Chat.ID,
+		MessageID: update.Message.MessageID,
+		Text:      update.Message.Text,
+		Command:   update.Message.Command(),
+		Arguments: update.Message.CommandArguments(),
+	}
+	if from := update.Message.From; from != nil {
+		msg.From = User{UserName: from.UserName, FirstName: from.FirstName, LastName: from.LastName}
+	}
+	return msg, nil
+}
+
+func parseMode(format Format) string {
+	if format == Markdown {
+		return tgbotapi.ModeMarkdown
+	}
+	return ""
+}
+
+func keyboard(buttons [][]Button) tgbotapi.InlineKeyboardMarkup {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(buttons))
+	for _, row := range buttons {
+		var keyboardRow []tgbotapi.InlineKeyboardButton
+		for _, button := range row {
+			keyboardRow = append(keyboardRow, tgbotapi.NewInlineKeyboardButtonData(button.Text, button.Data))
+		}
+		rows = append(rows, keyboardRow)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
@@ -0,0 +1,138 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Passenger type IDs of the TCDD e-bilet API, sent in passengerTypeCounts.
+// Only the adult ID is confirmed by the requests the site sends; child,
+// student and 65+ belong here once their IDs are seen in a captured request.
+const (
+	PassengerAdult = 0
+)
+
+// PassengerTypes lists the passenger types users can pick, in display order.
+var PassengerTypes = []struct {
+	ID   int
+	Name string
+}{
+	{PassengerAdult, "Yetişkin"},
+}
+
+// IsPassengerType reports whether id is one of PassengerTypes.
+func IsPassengerType(id int) bool {
+	for _, passengerType := range PassengerTypes {
+		if passengerType.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// PassengerTypeCount is one entry of passengerTypeCounts in a search request.
+type PassengerTypeCount struct {
+	ID    int `json:"id"`
+	Count int `json:"count"`
+}
+
+// Passengers is the group travelling together. A nil value is one adult.
+type Passengers []PassengerTypeCount
+
+// SingleAdult is what the bot searched for before group subscriptions.
+var SingleAdult = Passengers{{ID: PassengerAdult, Count: 1}}
+
+// OrDefault returns p, or a single adult when p is empty.
+func (p Passengers) OrDefault() Passengers {
+	if p.Total() == 0 {
+		return SingleAdult
+	}
+	return p
+}
+
+// IsSingleAdult reports whether p is the default group of one adult.
+func (p Passengers) IsSingleAdult() bool {
+	return p.OrDefault().String() == SingleAdult.String()
+}
+
+// Total returns the number of seats the group needs.
+func (p Passengers) Total() int {
+	total := 0
+	for _, count := range p {
+		total += count.Count
+	}
+	return total
+}
+
+// Count returns how many passengers of a type are in the group.
+func (p Passengers) Count(typeID int) int {
+	for _, count := range p {
+		if count.ID == typeID {
+			return count.Count
+		}
+	}
+	return 0
+}
+
+// With returns a copy of p with the count of a type replaced. Types with a
+// zero count are dropped and the result is ordered by type ID.
+func (p Passengers) With(typeID, count int) Passengers {
+	var out Passengers
+	for _, c := range p {
+		if c.ID != typeID && c.Count > 0 {
+			out = append(out, c)
+		}
+	}
+	if count > 0 {
+		out = append(out, PassengerTypeCount{ID: typeID, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// String encodes the group as "id:count,..." for storage and grouping keys.
+func (p Passengers) String() string {
+	parts := make([]string, 0, len(p))
+	for _, count := range p {
+		parts = append(parts, fmt.Sprintf("%d:%d", count.ID, count.Count))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParsePassengers decodes the output of Passengers.String.
+func ParsePassengers(s string) (Passengers, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var p Passengers
+	for _, part := range strings.Split(s, ",") {
+		id, count, found := strings.Cut(part, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid passenger count %q", part)
+		}
+		typeID, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid passenger type %q", id)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return nil, fmt.Errorf("invalid passenger count %q", count)
+		}
+		p = append(p, PassengerTypeCount{ID: typeID, Count: n})
+	}
+	return p, nil
+}
+
+// Describe returns a human readable summary such as "2 Yetişkin".
+func (p Passengers) Describe() string {
+	var parts []string
+	for _, passengerType := range PassengerTypes {
+		if n := p.Count(passengerType.ID); n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, passengerType.Name))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
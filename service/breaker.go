@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker stops calls to the TCDD API after a run of consecutive
+// failures and lets a single probe through once the cooldown has elapsed.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu            sync.Mutex
+	state         BreakerState
+	failures      int
+	openedAt      time.Time
+	probeInFlight bool
+	onChange      func(from, to BreakerState)
+	now           func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// OnStateChange registers fn to be called after every state transition.
+// fn is called without the breaker lock held.
+func (b *CircuitBreaker) OnStateChange(fn func(from, to BreakerState)) {
+	b.mu.Lock()
+	b.onChange = fn
+	b.mu.Unlock()
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Ready reports whether a call would currently be let through, without
+// reserving the half-open probe.
+func (b *CircuitBreaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		return b.now().Sub(b.openedAt) >= b.cooldown
+	case BreakerHalfOpen:
+		return !b.probeInFlight
+	}
+	return true
+}
+
+// Allow returns ErrCircuitOpen if the call must not be made. Every nil
+// return must be followed by a call to Record.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			b.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		b.probeInFlight = true
+		b.transition(BreakerHalfOpen)
+		return nil
+	case BreakerHalfOpen:
+		if b.probeInFlight {
+			b.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		b.probeInFlight = true
+	}
+
+	b.mu.Unlock()
+	return nil
+}
+
+// Record reports the outcome of a call let through by Allow. Only errors
+// that indicate the API is down count as failures; a caller cancelling or
+// running out of its own deadline leaves the breaker untouched.
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	b.probeInFlight = false
+
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		b.mu.Unlock()
+	case isRetryable(err):
+		b.failures++
+		if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+			b.openedAt = b.now()
+			b.transition(BreakerOpen)
+			return
+		}
+		b.mu.Unlock()
+	default:
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.transition(BreakerClosed)
+			return
+		}
+		b.mu.Unlock()
+	}
+}
+
+// transition changes state, releases the lock and fires the callback.
+func (b *CircuitBreaker) transition(to BreakerState) {
+	from := b.state
+	b.state = to
+	fn := b.onChange
+	b.mu.Unlock()
+
+	if fn != nil && from != to {
+		fn(from, to)
+	}
+}
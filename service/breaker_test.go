@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// transitions records the state changes reported to OnStateChange.
+type transitions struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (tr *transitions) record(from, to BreakerState) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.got = append(tr.got, from.String()+" -> "+to.String())
+}
+
+func (tr *transitions) check(t *testing.T, want ...string) {
+	t.Helper()
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if len(tr.got) != len(want) {
+		t.Fatalf("transitions: got %v, want %v", tr.got, want)
+	}
+	for i := range want {
+		if tr.got[i] != want[i] {
+			t.Fatalf("transitions: got %v, want %v", tr.got, want)
+		}
+	}
+}
+
+// newTestBreaker returns a breaker on a clock the test moves by hand.
+func newTestBreaker(threshold int, cooldown time.Duration) (*CircuitBreaker, *time.Time, *transitions) {
+	now := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := NewCircuitBreaker(threshold, cooldown)
+	b.now = func() time.Time { return now }
+	tr := &transitions{}
+	b.OnStateChange(tr.record)
+	return b, &now, tr
+}
+
+func call(t *testing.T, b *CircuitBreaker, err error) {
+	t.Helper()
+	if allowErr := b.Allow(); allowErr != nil {
+		t.Fatalf("allow: %v", allowErr)
+	}
+	b.Record(err)
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	b, now, tr := newTestBreaker(3, time.Minute)
+	down := &APIError{StatusCode: 503, Err: ErrUnavailable}
+
+	// Fewer failures than the threshold keep it closed
+	call(t, b, down)
+	call(t, b, down)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("after 2 failures: got %v, want closed", got)
+	}
+
+	call(t, b, down)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("after 3 failures: got %v, want open", got)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("allow while open: got %v, want ErrCircuitOpen", err)
+	}
+	if b.Ready() {
+		t.Fatalf("ready while cooling down")
+	}
+
+	// After the cooldown a single probe goes through
+	*now = now.Add(time.Minute)
+	if !b.Ready() {
+		t.Fatalf("not ready after the cooldown")
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Fatalf("during the probe: got %v, want half-open", got)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second call during the probe: got %v, want ErrCircuitOpen", err)
+	}
+
+	// A failed probe opens it again for another cooldown
+	b.Record(down)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("after a failed probe: got %v, want open", got)
+	}
+	*now = now.Add(time.Minute)
+	call(t, b, nil)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("after a successful probe: got %v, want closed", got)
+	}
+
+	tr.check(t,
+		"closed -> open",
+		"open -> half-open",
+		"half-open -> open",
+		"open -> half-open",
+		"half-open -> closed")
+}
+
+func TestBreakerIgnoresFatalErrors(t *testing.T) {
+	b, _, tr := newTestBreaker(2, time.Minute)
+	down := &APIError{StatusCode: 503, Err: ErrUnavailable}
+
+	// A day without trains is an answer and ends the run of failures
+	call(t, b, down)
+	call(t, b, &APIError{StatusCode: 400, Code: codeNoServiceOnDate, Err: ErrNoServiceOnDate})
+	call(t, b, down)
+	// Calls given up by the caller say nothing about the API
+	call(t, b, context.Canceled)
+	call(t, b, context.DeadlineExceeded)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("got %v, want closed", got)
+	}
+	tr.check(t)
+}
+
+func TestBreakerStopsRequests(t *testing.T) {
+	u := newUpstream(t, func(w http.ResponseWriter, r *http.Request) { writeErrorResponse(w, 503, 503) })
+	svc := newTestService(u.URL)
+	svc.retry.MaxAttempts = 1
+	svc.breaker = NewCircuitBreaker(2, time.Minute)
+	tr := &transitions{}
+	svc.Breaker().OnStateChange(tr.record)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := svc.CheckAvailability(ctx, 98, 1323, "01-06-2030", nil); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("call %d: got %v, want ErrUnavailable", i+1, err)
+		}
+	}
+	tr.check(t, "closed -> open")
+
+	// The API is not asked while the breaker is open
+	if _, err := svc.CheckAvailability(ctx, 98, 1323, "01-06-2030", nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("call while open: got %v, want ErrCircuitOpen", err)
+	}
+	if got := u.hits.Load(); got != 2 {
+		t.Fatalf("requests: got %d, want 2", got)
+	}
+}
+
+func TestBreakerIgnoresCallerDeadline(t *testing.T) {
+	u := newUpstream(t, slowResponse)
+	svc := newTestService(u.URL)
+	svc.breaker = NewCircuitBreaker(1, time.Minute)
+
+	// The job ran out of time, the API itself answers fine
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := svc.CheckAvailability(ctx, 98, 1323, "01-06-2030", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	if got := svc.Breaker().State(); got != BreakerClosed {
+		t.Fatalf("got %v, want closed", got)
+	}
+}
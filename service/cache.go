@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"tcddbot/model"
+	"time"
+)
+
+// ResponseCache keeps availability responses for a short while so the
+// wizard and the periodic checks share them. Identical searches made at the
+// same time go out once and every caller gets that answer. Cached responses
+// are shared and must not be modified.
+type ResponseCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	flights map[string]*flight
+	hits    int
+	misses  int
+	shared  int
+	now     func() time.Time
+}
+
+// CacheStats is a snapshot of the cache for the admin.
+type CacheStats struct {
+	TTL     time.Duration // 0 when the cache is disabled
+	Entries int
+	Hits    int // Answered from the cache
+	Misses  int // Sent to TCDD
+	Shared  int // Waited for an identical search already on its way
+}
+
+type cacheEntry struct {
+	response *model.TCDDResponse
+	err      error
+	expires  time.Time
+}
+
+// flight is a search on its way to TCDD
+type flight struct {
+	priority Priority
+	done     chan struct{}
+	response *model.TCDDResponse
+	err      error
+}
+
+// NewResponseCache creates a cache keeping answers for ttl, 0 disables it.
+func NewResponseCache(ttl time.Duration) *ResponseCache {
+	return &ResponseCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+		flights: make(map[string]*flight),
+		now:     time.Now,
+	}
+}
+
+func cacheKey(departureID, arrivalID int, date string, passengers model.Passengers) string {
+	return fmt.Sprintf("%d-%d-%s-%s", departureID, arrivalID, date, passengers.OrDefault())
+}
+
+// get answers from the cache, joins an identical search in flight or calls
+// fetch. An interactive search does not wait behind a background one, which
+// may still be queued at the rate limiter.
+func (c *ResponseCache) get(ctx context.Context, key string, fetch func(context.Context) (*model.TCDDResponse, error)) (*model.TCDDResponse, error) {
+	if c.ttl <= 0 {
+		return fetch(ctx)
+	}
+	priority := priorityOf(ctx)
+
+	for {
+		c.mu.Lock()
+		if entry, ok := c.entries[key]; ok && c.now().Before(entry.expires) {
+			c.hits++
+			c.mu.Unlock()
+			return entry.response, entry.err
+		}
+
+		if f, ok := c.flights[key]; ok && f.priority >= priority {
+			c.shared++
+			c.mu.Unlock()
+
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			// The search was given up by its caller, not answered
+			if isContextError(f.err) {
+				continue
+			}
+			return f.response, f.err
+		}
+
+		c.misses++
+		f := &flight{priority: priority, done: make(chan struct{})}
+		c.flights[key] = f
+		c.mu.Unlock()
+
+		f.response, f.err = fetch(ctx)
+
+		c.mu.Lock()
+		if c.flights[key] == f {
+			delete(c.flights, key)
+		}
+		if cacheable(f.err) {
+			c.prune()
+			c.entries[key] = cacheEntry{response: f.response, err: f.err, expires: c.now().Add(c.ttl)}
+		}
+		c.mu.Unlock()
+		close(f.done)
+
+		return f.response, f.err
+	}
+}
+
+// Stats returns the current counters.
+func (c *ResponseCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prune()
+	return CacheStats{TTL: c.ttl, Entries: len(c.entries), Hits: c.hits, Misses: c.misses, Shared: c.shared}
+}
+
+// prune drops expired entries. The caller holds the lock.
+func (c *ResponseCache) prune() {
+	now := c.now()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// cacheable reports whether an answer holds for other callers too. Besides
+// results, a day without trains is an answer, failures are not.
+func cacheable(err error) bool {
+	return err == nil || errors.Is(err, ErrNoServiceOnDate)
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
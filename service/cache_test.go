@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tcddbot/model"
+)
+
+// testCache returns a cache on a clock the test moves by hand.
+func testCache(ttl time.Duration) (*ResponseCache, *time.Time) {
+	now := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := NewResponseCache(ttl)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+// counter is a fetch that answers with a new response every call.
+type counter struct {
+	calls atomic.Int32
+	err   error
+}
+
+func (f *counter) fetch(context.Context) (*model.TCDDResponse, error) {
+	f.calls.Add(1)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &model.TCDDResponse{}, nil
+}
+
+// blocked is a fetch that waits for release, or for its caller to give up.
+type blocked struct {
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlocked() *blocked {
+	return &blocked{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (f *blocked) fetch(ctx context.Context) (*model.TCDDResponse, error) {
+	f.calls.Add(1)
+	f.started <- struct{}{}
+	select {
+	case <-f.release:
+		return &model.TCDDResponse{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// waitShared waits until n callers joined a search in flight.
+func waitShared(t *testing.T, c *ResponseCache, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for c.Stats().Shared < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d shared searches, got %d", n, c.Stats().Shared)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c, now := testCache(time.Minute)
+	f := &counter{}
+	ctx := context.Background()
+
+	first, _ := c.get(ctx, "k", f.fetch)
+	second, _ := c.get(ctx, "k", f.fetch)
+	if first != second || f.calls.Load() != 1 {
+		t.Fatalf("within the TTL: got %d fetches, want 1 shared answer", f.calls.Load())
+	}
+
+	*now = now.Add(time.Minute)
+	if third, _ := c.get(ctx, "k", f.fetch); third == first || f.calls.Load() != 2 {
+		t.Fatalf("after the TTL: got %d fetches, want a new answer", f.calls.Load())
+	}
+
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 2 || stats.Entries != 1 {
+		t.Fatalf("stats: got %+v, want 1 hit, 2 misses and 1 entry", stats)
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c, _ := testCache(0)
+	f := &counter{}
+
+	c.get(context.Background(), "k", f.fetch)
+	c.get(context.Background(), "k", f.fetch)
+	if got := f.calls.Load(); got != 2 {
+		t.Fatalf("fetches: got %d, want 2", got)
+	}
+}
+
+func TestCacheKeys(t *testing.T) {
+	c, _ := testCache(time.Minute)
+	f := &counter{}
+
+	// Another group of passengers is another search
+	c.get(context.Background(), cacheKey(98, 1323, "01-06-2030", nil), f.fetch)
+	c.get(context.Background(), cacheKey(98, 1323, "01-06-2030", model.SingleAdult), f.fetch)
+	c.get(context.Background(), cacheKey(98, 1323, "01-06-2030", model.Passengers{{ID: model.PassengerAdult, Count: 2}}), f.fetch)
+	c.get(context.Background(), cacheKey(98, 1323, "02-06-2030", nil), f.fetch)
+	if got := f.calls.Load(); got != 3 {
+		t.Fatalf("fetches: got %d, want 3", got)
+	}
+}
+
+func TestCacheSharesSearchInFlight(t *testing.T) {
+	c, _ := testCache(time.Minute)
+	f := newBlocked()
+
+	const callers = 5
+	responses := make([]*model.TCDDResponse, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i], _ = c.get(context.Background(), "k", f.fetch)
+		}(i)
+	}
+	waitShared(t, c, callers-1)
+	close(f.release)
+	wg.Wait()
+
+	if got := f.calls.Load(); got != 1 {
+		t.Fatalf("fetches: got %d, want 1", got)
+	}
+	for i, response := range responses {
+		if response == nil || response != responses[0] {
+			t.Fatalf("caller %d got another answer", i)
+		}
+	}
+}
+
+func TestCacheInteractiveSkipsBackgroundSearch(t *testing.T) {
+	c, _ := testCache(time.Minute)
+	background := newBlocked()
+	interactive := &counter{}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.get(context.Background(), "k", background.fetch)
+	}()
+	<-background.started
+
+	// The background search may still wait for the rate limiter
+	ctx := WithPriority(context.Background(), PriorityInteractive)
+	if _, err := c.get(ctx, "k", interactive.fetch); err != nil {
+		t.Fatalf("interactive search: %v", err)
+	}
+	if got := interactive.calls.Load(); got != 1 {
+		t.Fatalf("interactive fetches: got %d, want 1", got)
+	}
+
+	close(background.release)
+	<-done
+}
+
+func TestCacheBackgroundJoinsInteractiveSearch(t *testing.T) {
+	c, _ := testCache(time.Minute)
+	f := newBlocked()
+
+	ctx := WithPriority(context.Background(), PriorityInteractive)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.get(ctx, "k", f.fetch)
+	}()
+	<-f.started
+
+	joined := make(chan struct{})
+	go func() {
+		defer close(joined)
+		c.get(context.Background(), "k", f.fetch)
+	}()
+	waitShared(t, c, 1)
+	close(f.release)
+	<-done
+	<-joined
+
+	if got := f.calls.Load(); got != 1 {
+		t.Fatalf("fetches: got %d, want 1", got)
+	}
+}
+
+func TestCacheKeepsAnswersNotFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		cached bool
+	}{
+		{"no trains on the day", &APIError{StatusCode: 400, Code: codeNoServiceOnDate, Err: ErrNoServiceOnDate}, true},
+		{"upstream down", &APIError{StatusCode: 503, Err: ErrUnavailable}, false},
+		{"throttled", &APIError{StatusCode: 429, Err: ErrThrottled}, false},
+		{"caller gave up", context.Canceled, false},
+		{"caller ran out of time", context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := testCache(time.Minute)
+			f := &counter{err: tt.err}
+
+			c.get(context.Background(), "k", f.fetch)
+			_, err := c.get(context.Background(), "k", f.fetch)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			want := int32(2)
+			if tt.cached {
+				want = 1
+			}
+			if got := f.calls.Load(); got != want {
+				t.Fatalf("fetches: got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestCacheRetriesSearchGivenUpByItsCaller(t *testing.T) {
+	c, _ := testCache(time.Minute)
+	f := newBlocked()
+
+	// The first caller gives up while another one waits for its answer
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan error)
+	go func() {
+		_, err := c.get(leaderCtx, "k", f.fetch)
+		leaderDone <- err
+	}()
+	<-f.started
+
+	followerDone := make(chan error)
+	go func() {
+		_, err := c.get(context.Background(), "k", f.fetch)
+		followerDone <- err
+	}()
+	waitShared(t, c, 1)
+
+	cancel()
+	if err := <-leaderDone; !errors.Is(err, context.Canceled) {
+		t.Fatalf("leader: got %v, want context.Canceled", err)
+	}
+	// The follower searches on its own instead of taking the cancellation
+	<-f.started
+	close(f.release)
+	if err := <-followerDone; err != nil {
+		t.Fatalf("follower: %v", err)
+	}
+	if got := f.calls.Load(); got != 2 {
+		t.Fatalf("fetches: got %d, want 2", got)
+	}
+}
+
+func TestCacheWaiterGivesUp(t *testing.T) {
+	c, _ := testCache(time.Minute)
+	f := newBlocked()
+
+	go c.get(context.Background(), "k", f.fetch)
+	<-f.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.get(ctx, "k", f.fetch); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	close(f.release)
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Errors returned by TrainService, classified from the upstream response.
+// Use errors.Is to branch on them; the concrete error is usually an *APIError
+// carrying the upstream TraceId.
+var (
+	ErrNoServiceOnDate   = errors.New("tcdd: no trains available on date")
+	ErrSalesNotOpen      = errors.New("tcdd: ticket sales not open yet") // Told by the sale horizon, see OnSale
+	ErrUnauthorized      = errors.New("tcdd: unauthorized")
+	ErrThrottled         = errors.New("tcdd: throttled")
+	ErrMalformedResponse = errors.New("tcdd: malformed response")
+	ErrTimeout           = errors.New("tcdd: request timed out")
+	ErrUnavailable       = errors.New("tcdd: service unavailable")
+	ErrCircuitOpen       = errors.New("tcdd: circuit breaker open")
+)
+
+// Codes reported in ErrorResponse.Code that have a dedicated classification.
+// Only codes the API is known to send belong here, others fall back to the
+// HTTP status rather than risk a wrong message or a cached failure.
+const (
+	codeNoServiceOnDate = 604
+)
+
+var errorsByCode = map[int]error{
+	codeNoServiceOnDate: ErrNoServiceOnDate,
+}
+
+// APIError describes a failed call to the TCDD API.
+type APIError struct {
+	StatusCode int           // HTTP status, 0 if no response was received
+	Code       int           // ErrorResponse.Code reported by TCDD
+	TraceID    string        // ErrorResponse.TraceId, quote it when reporting issues upstream
+	Message    string        // ErrorResponse.Message or transport error text
+	RetryAfter time.Duration // Retry-After hint sent with 429/503 responses
+	Err        error         // One of the Err* classifications above, may be nil
+}
+
+func (e *APIError) Error() string {
+	kind := "tcdd: request failed"
+	if e.Err != nil {
+		kind = e.Err.Error()
+	}
+	if e.StatusCode != 0 {
+		kind = fmt.Sprintf("%s (status %d)", kind, e.StatusCode)
+	}
+	if e.TraceID != "" {
+		kind = fmt.Sprintf("%s [trace %s]", kind, e.TraceID)
+	}
+	if e.Message == "" {
+		return kind
+	}
+	return kind + ": " + e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// classifyStatus maps an HTTP status code to one of the Err* sentinels.
+func classifyStatus(status int) error {
+	switch {
+	case status == 401 || status == 403:
+		return ErrUnauthorized
+	case status == 429:
+		return ErrThrottled
+	case status >= 500:
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// newAPIError classifies an upstream error response, preferring the TCDD
+// error code over the HTTP status.
+func newAPIError(status int, errorResp ErrorResponse) *APIError {
+	kind, ok := errorsByCode[errorResp.Code]
+	if !ok {
+		kind = classifyStatus(status)
+	}
+	return &APIError{
+		StatusCode: status,
+		Code:       errorResp.Code,
+		TraceID:    errorResp.TraceId,
+		Message:    errorResp.Message,
+		Err:        kind,
+	}
+}
+
+// TraceID returns the upstream TraceId carried by err, if any.
+func TraceID(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.TraceID
+	}
+	return ""
+}
+
+// classifyTransport wraps an error returned by http.Client.Do.
+func classifyTransport(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &APIError{Message: err.Error(), Err: ErrTimeout}
+	}
+	return &APIError{Message: err.Error(), Err: ErrUnavailable}
+}
+
+// isRetryable reports whether a request that failed with err may succeed
+// if it is sent again.
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrTimeout) ||
+		errors.Is(err, ErrThrottled) ||
+		errors.Is(err, ErrUnavailable)
+}
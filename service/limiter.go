@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Priority orders callers waiting for the rate limiter.
+type Priority int
+
+const (
+	// PriorityBackground is periodic polling, it waits for user requests.
+	PriorityBackground Priority = iota
+	// PriorityInteractive is a request a user is waiting on in the chat.
+	PriorityInteractive
+)
+
+type priorityKey struct{}
+
+// WithPriority marks the TCDD requests made with ctx. Requests without a
+// priority are background requests.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityOf(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// Limiter is a token bucket shared by every request to the TCDD API. Tokens
+// come back at rate per second up to burst. Background requests only take a
+// token while no interactive request is waiting for one.
+type Limiter struct {
+	rate  float64
+	burst int
+
+	mu          sync.Mutex
+	tokens      float64
+	last        time.Time
+	waiting     [2]int
+	windowStart time.Time
+	granted     [2]int
+	now         func() time.Time
+}
+
+// LimiterStats is a snapshot of the limiter for the admin.
+type LimiterStats struct {
+	Rate      float64 // Tokens per second, 0 when the limiter is disabled
+	Burst     int
+	Available int    // Tokens left right now
+	Granted   [2]int // Requests let through in the current minute by priority
+	Waiting   [2]int // Requests waiting for a token by priority
+}
+
+// NewLimiter creates a limiter starting with a full bucket. A rate of 0
+// lets every request through.
+func NewLimiter(rate float64, burst int) *Limiter {
+	burst = max(burst, 1)
+	return &Limiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// Wait blocks until a token is available for the priority of ctx or ctx is
+// done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	p := priorityOf(ctx)
+
+	l.mu.Lock()
+	l.waiting[p]++
+	defer func() {
+		l.mu.Lock()
+		l.waiting[p]--
+		l.mu.Unlock()
+	}()
+
+	for {
+		now := l.now()
+		l.refill(now)
+		yield := p == PriorityBackground && l.waiting[PriorityInteractive] > 0
+		if l.rate <= 0 || (l.tokens >= 1 && !yield) {
+			if l.rate > 0 {
+				l.tokens--
+			}
+			l.count(p, now)
+			l.mu.Unlock()
+			return nil
+		}
+
+		// Sleep until the next token, a background request that yielded
+		// looks again after it
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		if yield || delay <= 0 {
+			delay = time.Duration(float64(time.Second) / l.rate)
+		}
+		l.mu.Unlock()
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+		l.mu.Lock()
+	}
+}
+
+// Stats returns the current state of the limiter.
+func (l *Limiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.refill(now)
+	stats := LimiterStats{Rate: l.rate, Burst: l.burst, Available: int(l.tokens), Waiting: l.waiting}
+	if now.Sub(l.windowStart) < time.Minute {
+		stats.Granted = l.granted
+	}
+	return stats
+}
+
+func (l *Limiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, float64(l.burst))
+	}
+	l.last = now
+}
+
+func (l *Limiter) count(p Priority, now time.Time) {
+	if now.Sub(l.windowStart) >= time.Minute {
+		l.windowStart = now
+		l.granted = [2]int{}
+	}
+	l.granted[p]++
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitWaiting waits until n callers of priority p wait for a token.
+func waitWaiting(t *testing.T, l *Limiter, p Priority, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for l.Stats().Waiting[p] < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d callers of priority %d", n, p)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterRespectsBudget(t *testing.T) {
+	l := NewLimiter(50, 5)
+
+	// The burst goes through at once, the rest at 50 per second
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("wait %d: %v", i, err)
+		}
+		if i == 4 {
+			if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+				t.Fatalf("burst took %v, want no wait", elapsed)
+			}
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Fatalf("15 requests took %v, want at least 200ms", elapsed)
+	}
+	if stats := l.Stats(); stats.Granted[PriorityBackground] != 15 || stats.Available != 0 {
+		t.Fatalf("stats: got %+v, want 15 granted and no token left", stats)
+	}
+}
+
+func TestLimiterBackgroundGivesWay(t *testing.T) {
+	l := NewLimiter(10, 1)
+	l.Wait(context.Background())
+
+	order := make(chan Priority, 2)
+	go func() {
+		l.Wait(context.Background())
+		order <- PriorityBackground
+	}()
+	waitWaiting(t, l, PriorityBackground, 1)
+
+	// The interactive request comes later but takes the next token
+	go func() {
+		l.Wait(WithPriority(context.Background(), PriorityInteractive))
+		order <- PriorityInteractive
+	}()
+
+	if first := <-order; first != PriorityInteractive {
+		t.Fatalf("first token went to priority %d, want interactive", first)
+	}
+	if second := <-order; second != PriorityBackground {
+		t.Fatalf("second token went to priority %d, want background", second)
+	}
+	if granted := l.Stats().Granted; granted[PriorityInteractive] != 1 || granted[PriorityBackground] != 2 {
+		t.Fatalf("granted: got %v, want 2 background and 1 interactive", granted)
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := NewLimiter(0, 1)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		l.Wait(context.Background())
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("100 requests took %v, want no wait", elapsed)
+	}
+}
+
+func TestLimiterWaitGivesUp(t *testing.T) {
+	l := NewLimiter(0.1, 1)
+	l.Wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	if waiting := l.Stats().Waiting; waiting != [2]int{} {
+		t.Fatalf("waiting after giving up: got %v, want none", waiting)
+	}
+}
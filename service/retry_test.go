@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tcddbot/config"
+	"tcddbot/model"
+)
+
+// upstream is an httptest stand-in for the availability endpoint that
+// answers with a scripted handler and counts requests.
+type upstream struct {
+	*httptest.Server
+	hits atomic.Int32
+}
+
+func newUpstream(t *testing.T, handler http.HandlerFunc) *upstream {
+	t.Helper()
+	u := &upstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.hits.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func newTestService(endpoint string) *TrainService {
+	return NewTrainService(&config.Config{
+		APIEndpoint:      endpoint,
+		RequestTimeout:   time.Second,
+		MaxAttempts:      3,
+		RetryBaseDelay:   time.Millisecond,
+		RetryMaxDelay:    5 * time.Millisecond,
+		BreakerThreshold: 100,
+		BreakerCooldown:  time.Minute,
+	})
+}
+
+func writeErrorResponse(w http.ResponseWriter, status, code int) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Code: code, TraceId: "trace-1", Message: http.StatusText(status)})
+}
+
+func TestRetryClassification(t *testing.T) {
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		want      error
+		wantHits  int32
+		retryable bool
+	}{
+		{
+			name:      "no trains is fatal",
+			handler:   func(w http.ResponseWriter, r *http.Request) { writeErrorResponse(w, 400, codeNoServiceOnDate) },
+			want:      ErrNoServiceOnDate,
+			wantHits:  1,
+			retryable: false,
+		},
+		{
+			name:      "unauthorized is fatal",
+			handler:   func(w http.ResponseWriter, r *http.Request) { writeErrorResponse(w, 401, 401) },
+			want:      ErrUnauthorized,
+			wantHits:  1,
+			retryable: false,
+		},
+		{
+			name:      "malformed response is fatal",
+			handler:   func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<html>")) },
+			want:      ErrMalformedResponse,
+			wantHits:  1,
+			retryable: false,
+		},
+		{
+			name:      "throttling is retried",
+			handler:   func(w http.ResponseWriter, r *http.Request) { writeErrorResponse(w, 429, 429) },
+			want:      ErrThrottled,
+			wantHits:  3,
+			retryable: true,
+		},
+		{
+			name:      "server errors are retried",
+			handler:   func(w http.ResponseWriter, r *http.Request) { writeErrorResponse(w, 503, 503) },
+			want:      ErrUnavailable,
+			wantHits:  3,
+			retryable: true,
+		},
+		{
+			name: "timeouts are retried",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(time.Second):
+				case <-r.Context().Done():
+				}
+			},
+			want:      ErrTimeout,
+			wantHits:  3,
+			retryable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newUpstream(t, tt.handler)
+			svc := newTestService(u.URL)
+			svc.client.Timeout = 50 * time.Millisecond
+
+			_, err := svc.CheckAvailability(context.Background(), 98, 1323, "01-06-2030", nil)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error: got %v, want %v", err, tt.want)
+			}
+			if got := isRetryable(err); got != tt.retryable {
+				t.Fatalf("isRetryable(%v): got %v, want %v", err, got, tt.retryable)
+			}
+			if got := u.hits.Load(); got != tt.wantHits {
+				t.Fatalf("requests: got %d, want %d", got, tt.wantHits)
+			}
+		})
+	}
+}
+
+// slowResponse answers well after the deadlines of the tests, or when the
+// client goes away.
+func slowResponse(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-time.After(500 * time.Millisecond):
+		json.NewEncoder(w).Encode(model.TCDDResponse{})
+	case <-r.Context().Done():
+	}
+}
+
+func TestRetryStopsAtCallerDeadline(t *testing.T) {
+	u := newUpstream(t, slowResponse)
+	svc := newTestService(u.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := svc.CheckAvailability(ctx, 98, 1323, "01-06-2030", nil)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want the caller's context.DeadlineExceeded", err)
+	}
+	if got := u.hits.Load(); got != 1 {
+		t.Fatalf("requests: got %d, want 1", got)
+	}
+}
+
+func TestRetryUpstreamTimeout(t *testing.T) {
+	u := newUpstream(t, slowResponse)
+	svc := newTestService(u.URL)
+	svc.client.Timeout = 20 * time.Millisecond
+
+	// The upstream being slow is its own fault and worth another try
+	_, err := svc.CheckAvailability(context.Background(), 98, 1323, "01-06-2030", nil)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	if got := u.hits.Load(); got != 3 {
+		t.Fatalf("requests: got %d, want 3", got)
+	}
+}
+
+func TestRetryRecovers(t *testing.T) {
+	u := newUpstream(t, nil)
+	u.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u.hits.Add(1) < 3 {
+			writeErrorResponse(w, 502, 502)
+			return
+		}
+		json.NewEncoder(w).Encode(model.TCDDResponse{})
+	})
+	svc := newTestService(u.URL)
+
+	if _, err := svc.CheckAvailability(context.Background(), 98, 1323, "01-06-2030", nil); err != nil {
+		t.Fatalf("third attempt: %v", err)
+	}
+	if got := u.hits.Load(); got != 3 {
+		t.Fatalf("requests: got %d, want 3", got)
+	}
+}
+
+func TestRetryTraceID(t *testing.T) {
+	u := newUpstream(t, func(w http.ResponseWriter, r *http.Request) { writeErrorResponse(w, 400, codeNoServiceOnDate) })
+	svc := newTestService(u.URL)
+
+	_, err := svc.CheckAvailability(context.Background(), 98, 1323, "01-06-2030", nil)
+	if got := TraceID(err); got != "trace-1" {
+		t.Fatalf("TraceID: got %q, want trace-1", got)
+	}
+}
+
+func TestBackoffCappedAtMaxDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	// The first retries stay below the doubling base delay
+	for attempt, ceiling := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond} {
+		for i := 0; i < 100; i++ {
+			if d := policy.backoff(attempt, nil); d < 0 || d > ceiling {
+				t.Fatalf("backoff(%d): got %v, want between 0 and %v", attempt, d, ceiling)
+			}
+		}
+	}
+
+	// Later ones never exceed MaxDelay, even once the doubling overflows
+	for attempt := 4; attempt <= 70; attempt++ {
+		for i := 0; i < 100; i++ {
+			if d := policy.backoff(attempt, nil); d < 0 || d > policy.MaxDelay {
+				t.Fatalf("backoff(%d): got %v, want between 0 and %v", attempt, d, policy.MaxDelay)
+			}
+		}
+	}
+}
+
+func TestBackoffHonorsRetryAfter(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	err := &APIError{StatusCode: 429, RetryAfter: 2 * time.Second, Err: ErrThrottled}
+
+	if d := policy.backoff(1, err); d != 2*time.Second {
+		t.Fatalf("backoff after Retry-After: got %v, want 2s", d)
+	}
+}
+
+func TestSalesNotOpenSkipsRequest(t *testing.T) {
+	u := newUpstream(t, func(w http.ResponseWriter, r *http.Request) { json.NewEncoder(w).Encode(model.TCDDResponse{}) })
+	svc := newTestService(u.URL)
+	svc.cfg.SaleHorizonDays = 30
+
+	date := time.Now().AddDate(0, 0, 35).Format("02-01-2006")
+	if _, err := svc.CheckAvailability(context.Background(), 98, 1323, date, nil); !errors.Is(err, ErrSalesNotOpen) {
+		t.Fatalf("got %v, want ErrSalesNotOpen", err)
+	}
+	if got := u.hits.Load(); got != 0 {
+		t.Fatalf("requests: got %d, want 0", got)
+	}
+}
+
+func TestOnSale(t *testing.T) {
+	today := time.Date(2030, 6, 1, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		date    string
+		horizon int
+		want    bool
+	}{
+		{"01-06-2030", 30, true},
+		{"01-07-2030", 30, true},
+		{"02-07-2030", 30, false},
+		{"01-01-2031", 0, true},
+		{"not a date", 30, false},
+	}
+	for _, tt := range tests {
+		if got := OnSale(tt.date, today, tt.horizon); got != tt.want {
+			t.Errorf("OnSale(%q, horizon %d): got %v, want %v", tt.date, tt.horizon, got, tt.want)
+		}
+	}
+}
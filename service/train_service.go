@@ -1,146 +1,232 @@
 package service
 
 import (
-    "bytes"
-    "context"
-    "encoding/json"
-    "fmt"
-    "io"
-    "net/http"
-    "tcddbot/config"
-    "tcddbot/model"
-    "time"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"tcddbot/config"
+	"tcddbot/model"
+	"time"
 )
 
 type TrainService struct {
-    cfg    *config.Config
-    client *http.Client
+	cfg     *config.Config
+	client  *http.Client
+	retry   RetryPolicy
+	breaker *CircuitBreaker
+	limiter *Limiter
+	cache   *ResponseCache
 }
 
 func NewTrainService(cfg *config.Config) *TrainService {
-    return &TrainService{
-        cfg: cfg,
-        client: &http.Client{
-            Timeout: 10 * time.Second,
-        },
-    }
+	return &TrainService{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: cfg.RequestTimeout,
+		},
+		retry: RetryPolicy{
+			MaxAttempts: cfg.MaxAttempts,
+			BaseDelay:   cfg.RetryBaseDelay,
+			MaxDelay:    cfg.RetryMaxDelay,
+		},
+		breaker: NewCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		limiter: NewLimiter(cfg.UpstreamRate, cfg.UpstreamBurst),
+		cache:   NewResponseCache(cfg.CacheTTL),
+	}
 }
 
-func (s *TrainService) CheckAvailability(ctx context.Context, departureID, arrivalID int, date string) (*model.TCDDResponse, error) {
-    adjustedDate, err := s.adjustDate(date)
-    if (err != nil) {
-        return nil, fmt.Errorf("date adjustment failed: %w", err)
-    }
-
-    reqBody := map[string]interface{}{
-        "searchRoutes": []map[string]interface{}{
-            {
-                "departureStationId": departureID,
-                "arrivalStationId":   arrivalID,
-                "departureDate":      adjustedDate,
-            },
-        },
-        "passengerTypeCounts": []map[string]interface{}{
-            {"id": 0, "count": 1},
-        },
-        "searchReservation": false,
-    }
-
-    return s.makeRequest(ctx, reqBody)
+// Breaker returns the circuit breaker guarding upstream calls.
+func (s *TrainService) Breaker() *CircuitBreaker {
+	return s.breaker
+}
+
+// Limiter returns the rate limiter every upstream call waits on.
+func (s *TrainService) Limiter() *Limiter {
+	return s.limiter
+}
+
+// Cache returns the cache availability searches are answered from.
+func (s *TrainService) Cache() *ResponseCache {
+	return s.cache
+}
+
+// CheckAvailability searches the trains of a day with seats for the given
+// passengers. No passengers means a single adult. The response may come
+// from the cache and is shared, callers must not modify it. Days TCDD does
+// not sell yet fail with ErrSalesNotOpen without a request.
+func (s *TrainService) CheckAvailability(ctx context.Context, departureID, arrivalID int, date string, passengers model.Passengers) (*model.TCDDResponse, error) {
+	adjustedDate, err := s.adjustDate(date)
+	if err != nil {
+		return nil, fmt.Errorf("date adjustment failed: %w", err)
+	}
+	if !OnSale(date, time.Now(), s.cfg.SaleHorizonDays) {
+		return nil, fmt.Errorf("%w: %s", ErrSalesNotOpen, date)
+	}
+
+	key := cacheKey(departureID, arrivalID, date, passengers)
+	return s.cache.get(ctx, key, func(ctx context.Context) (*model.TCDDResponse, error) {
+		return s.makeRequest(ctx, searchRequest(departureID, arrivalID, adjustedDate, passengers.OrDefault()))
+	})
+}
+
+func searchRequest(departureID, arrivalID int, adjustedDate string, passengers model.Passengers) map[string]interface{} {
+	return map[string]interface{}{
+		"searchRoutes": []map[string]interface{}{
+			{
+				"departureStationId": departureID,
+				"arrivalStationId":   arrivalID,
+				"departureDate":      adjustedDate,
+			},
+		},
+		"passengerTypeCounts": passengers,
+		"searchReservation":   false,
+	}
 }
 
 func (s *TrainService) CheckTrainAvailability(departureStationID, arrivalStationID int, travelDate string) (bool, error) {
-    adjustedDate, err := s.adjustDate(travelDate)
-    if err != nil {
-        return false, fmt.Errorf("date adjustment failed: %w", err)
-    }
-
-    reqBody := map[string]interface{}{
-        "searchRoutes": []map[string]interface{}{
-            {
-                "departureStationId": departureStationID,
-                "arrivalStationId":   arrivalStationID,
-                "departureDate":      adjustedDate,
-            },
-        },
-        "passengerTypeCounts": []map[string]interface{}{
-            {"id": 0, "count": 1},
-        },
-        "searchReservation": false,
-    }
-
-    resp, err := s.makeRequest(context.Background(), reqBody)
-    if err != nil {
-        return false, err
-    }
-
-    // Check if response indicates no availability
-    if len(resp.TrainLegs) == 0 {
-        return false, nil
-    }
-
-    return true, nil
+	adjustedDate, err := s.adjustDate(travelDate)
+	if err != nil {
+		return false, fmt.Errorf("date adjustment failed: %w", err)
+	}
+
+	resp, err := s.makeRequest(context.Background(), searchRequest(departureStationID, arrivalStationID, adjustedDate, model.SingleAdult))
+	if err != nil {
+		return false, err
+	}
+
+	// Check if response indicates no availability
+	if len(resp.TrainLegs) == 0 {
+		return false, nil
+	}
+
+	return true, nil
 }
 
 type ErrorResponse struct {
-    Timestamp string `json:"timestamp"`
-    TraceId   string `json:"traceId"`
-    Status    string `json:"status"`
-    Type      string `json:"type"`
-    Code      int    `json:"code"`
-    Message   string `json:"message"`
-    Detail    string `json:"detail"`
-    Title     string `json:"title"`
+	Timestamp string `json:"timestamp"`
+	TraceId   string `json:"traceId"`
+	Status    string `json:"status"`
+	Type      string `json:"type"`
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	Detail    string `json:"detail"`
+	Title     string `json:"title"`
 }
 
 func (s *TrainService) makeRequest(ctx context.Context, reqBody interface{}) (*model.TCDDResponse, error) {
-    jsonBody, err := json.Marshal(reqBody)
-    if err != nil {
-        return nil, fmt.Errorf("marshal request body: %w", err)
-    }
-
-    req, err := http.NewRequestWithContext(ctx, "POST", s.cfg.APIEndpoint+"/train-availability", bytes.NewBuffer(jsonBody))
-    if err != nil {
-        return nil, fmt.Errorf("create request: %w", err)
-    }
-
-    req.Header.Set("Accept", "application/json")
-    req.Header.Set("Authorization", s.cfg.AuthToken)
-    req.Header.Set("unit-id", s.cfg.UnitID)
-    req.Header.Set("Content-Type", "application/json")
-
-    resp, err := s.client.Do(req)
-    if err != nil {
-        return nil, fmt.Errorf("do request: %w", err)
-    }
-    defer resp.Body.Close()
-
-    body, err := io.ReadAll(resp.Body)
-    if err != nil {
-        return nil, fmt.Errorf("read response body: %w", err)
-    }
-
-    // First try to unmarshal as error response
-    var errorResp ErrorResponse
-    if err := json.Unmarshal(body, &errorResp); err == nil {
-        if errorResp.Code == 604 {
-            return nil, fmt.Errorf("no trains available: %s", errorResp.Message)
-        }
-    }
-
-    var response model.TCDDResponse
-    if err := json.Unmarshal(body, &response); err != nil {
-        return nil, fmt.Errorf("unmarshal response: %w", err)
-    }
-
-    return &response, nil
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < max(s.retry.MaxAttempts, 1); attempt++ {
+		if attempt > 0 {
+			if err := sleepContext(ctx, s.retry.backoff(attempt, lastErr)); err != nil {
+				return nil, lastErr
+			}
+		}
+
+		// Retries are requests too and take their own token
+		if err := s.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		if err := s.breaker.Allow(); err != nil {
+			return nil, err
+		}
+
+		response, err := s.doRequest(ctx, jsonBody)
+		s.breaker.Record(err)
+		if err == nil {
+			return response, nil
+		}
+
+		lastErr = err
+		if !isRetryable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	return nil, lastErr
+}
+
+func (s *TrainService) doRequest(ctx context.Context, jsonBody []byte) (*model.TCDDResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.cfg.APIEndpoint+"/train-availability", bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", s.cfg.AuthToken)
+	req.Header.Set("unit-id", s.cfg.UnitID)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// The caller gave up, this says nothing about the upstream
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, classifyTransport(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, classifyTransport(err)
+	}
+
+	// First try to unmarshal as error response
+	var errorResp ErrorResponse
+	if err := json.Unmarshal(body, &errorResp); err == nil {
+		if _, known := errorsByCode[errorResp.Code]; known {
+			return nil, newAPIError(resp.StatusCode, errorResp)
+		}
+	}
+
+	if resp.StatusCode >= 400 {
+		apiErr := newAPIError(resp.StatusCode, errorResp)
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, apiErr
+	}
+
+	var response model.TCDDResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: err.Error(), Err: ErrMalformedResponse}
+	}
+
+	return &response, nil
+}
+
+// OnSale reports whether TCDD already sells tickets for date, which is at
+// most horizonDays after today. A horizon of 0 puts no limit.
+func OnSale(date string, today time.Time, horizonDays int) bool {
+	day, err := time.Parse("02-01-2006", date)
+	if err != nil {
+		return false
+	}
+	if horizonDays == 0 {
+		return true
+	}
+	y, m, d := today.Date()
+	return !day.After(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).AddDate(0, 0, horizonDays))
 }
 
 func (s *TrainService) adjustDate(date string) (string, error) {
-    parsedDate, err := time.Parse("02-01-2006", date)
-    if err != nil {
-        return "", fmt.Errorf("parse date: %w", err)
-    }
-    adjustedDate := parsedDate.AddDate(0, 0, -1)
-    return adjustedDate.Format("02-01-2006") + " 21:00:00", nil
+	parsedDate, err := time.Parse("02-01-2006", date)
+	if err != nil {
+		return "", fmt.Errorf("parse date: %w", err)
+	}
+	adjustedDate := parsedDate.AddDate(0, 0, -1)
+	return adjustedDate.Format("02-01-2006") + " 21:00:00", nil
 }
@@ -0,0 +1,353 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"tcddbot/model"
+	"time"
+)
+
+// NewMemory returns in-memory stores for tests.
+func NewMemory() *Store {
+	return &Store{
+		Subscriptions: &memorySubscriptions{},
+		Users:         &memoryUsers{users: make(map[int64]User)},
+		Prices:        &memoryPrices{},
+		Conversations: &memoryConversations{conversations: make(map[int64]Conversation)},
+	}
+}
+
+type memorySubscription struct {
+	Subscription
+	deleted bool
+}
+
+type memorySubscriptions struct {
+	mu          sync.Mutex
+	nextID      int64
+	subs        []*memorySubscription
+	alertPrices map[AlertKey]float64
+}
+
+func (s *memorySubscriptions) Create(ctx context.Context, sub *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.create(sub)
+	return nil
+}
+
+func (s *memorySubscriptions) CreateRoundTrip(ctx context.Context, outbound, inbound *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	outbound.ReturnLeg, inbound.ReturnLeg = false, true
+	outbound.RoundTripID, inbound.RoundTripID = s.nextID+1, s.nextID+1
+	s.create(outbound)
+	s.create(inbound)
+	return nil
+}
+
+func (s *memorySubscriptions) create(sub *Subscription) {
+	s.nextID++
+	sub.ID = s.nextID
+	sub.CreatedAt = time.Now().UTC()
+	stored := &memorySubscription{Subscription: *sub}
+	stored.Weekdays = append([]time.Weekday(nil), sub.Weekdays...)
+	stored.SatisfiedDates = append([]string(nil), sub.SatisfiedDates...)
+	stored.PausedWeeks = append([]string(nil), sub.PausedWeeks...)
+	stored.CabinClasses = append([]string(nil), sub.CabinClasses...)
+	stored.Passengers = append(model.Passengers(nil), sub.Passengers...)
+	s.subs = append(s.subs, stored)
+}
+
+func (s *memorySubscriptions) find(id int64) *memorySubscription {
+	for _, sub := range s.subs {
+		if sub.ID == id && !sub.deleted {
+			return sub
+		}
+	}
+	return nil
+}
+
+func (s *memorySubscriptions) Get(ctx context.Context, id int64) (*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub := s.find(id)
+	if sub == nil {
+		return nil, ErrNotFound
+	}
+	copied := sub.Subscription
+	return &copied, nil
+}
+
+func (s *memorySubscriptions) ListActive(ctx context.Context) ([]Subscription, error) {
+	subs := s.filter(func(*memorySubscription) bool { return true })
+	sort.SliceStable(subs, func(i, j int) bool {
+		a, b := subs[i], subs[j]
+		if a.DepartureStationID != b.DepartureStationID {
+			return a.DepartureStationID < b.DepartureStationID
+		}
+		if a.ArrivalStationID != b.ArrivalStationID {
+			return a.ArrivalStationID < b.ArrivalStationID
+		}
+		return a.TravelDate < b.TravelDate
+	})
+	return subs, nil
+}
+
+func (s *memorySubscriptions) ListActiveByChat(ctx context.Context, chatID int64) ([]Subscription, error) {
+	subs := s.filter(func(sub *memorySubscription) bool { return sub.ChatID == chatID })
+	// Newest first
+	for i, j := 0, len(subs)-1; i < j; i, j = i+1, j-1 {
+		subs[i], subs[j] = subs[j], subs[i]
+	}
+	return subs, nil
+}
+
+func (s *memorySubscriptions) filter(keep func(*memorySubscription) bool) []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var subs []Subscription
+	for _, sub := range s.subs {
+		if !sub.deleted && keep(sub) {
+			subs = append(subs, sub.Subscription)
+		}
+	}
+	return subs
+}
+
+func (s *memorySubscriptions) ExistsActive(ctx context.Context, chatID int64, departureID, arrivalID int, travelDate string) (bool, error) {
+	subs := s.filter(func(sub *memorySubscription) bool {
+		return sub.ChatID == chatID && sub.DepartureStationID == departureID &&
+			sub.ArrivalStationID == arrivalID && sub.TravelDate == travelDate
+	})
+	return len(subs) > 0, nil
+}
+
+func (s *memorySubscriptions) MarkNotified(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subs {
+		if sub.ID == id {
+			sub.LastNotified = time.Now().UTC()
+		}
+	}
+	return nil
+}
+
+func (s *memorySubscriptions) SetSatisfiedDates(ctx context.Context, id int64, dates []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subs {
+		if sub.ID == id {
+			sub.SatisfiedDates = append([]string(nil), dates...)
+		}
+	}
+	return nil
+}
+
+func (s *memorySubscriptions) SetPausedWeeks(ctx context.Context, id int64, weeks []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subs {
+		if sub.ID == id {
+			sub.PausedWeeks = append([]string(nil), weeks...)
+		}
+	}
+	return nil
+}
+
+func (s *memorySubscriptions) SetNearby(ctx context.Context, id int64, nearby bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sub := range s.subs {
+		if sub.ID == id {
+			sub.Nearby = nearby
+		}
+	}
+	return nil
+}
+
+func (s *memorySubscriptions) AlertPrices(ctx context.Context) (map[AlertKey]float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prices := make(map[AlertKey]float64, len(s.alertPrices))
+	for key, price := range s.alertPrices {
+		prices[key] = price
+	}
+	return prices, nil
+}
+
+func (s *memorySubscriptions) AlertPrice(ctx context.Context, id int64, travelDate string) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.alertPrices[AlertKey{id, travelDate}], nil
+}
+
+func (s *memorySubscriptions) SetAlertPrice(ctx context.Context, id int64, travelDate string, price float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.alertPrices == nil {
+		s.alertPrices = make(map[AlertKey]float64)
+	}
+	s.alertPrices[AlertKey{id, travelDate}] = price
+	return nil
+}
+
+func (s *memorySubscriptions) Deactivate(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sub := s.find(id); sub != nil {
+		sub.deleted = true
+	}
+	return nil
+}
+
+func (s *memorySubscriptions) Cancel(ctx context.Context, chatID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub := s.find(id)
+	if sub == nil || sub.ChatID != chatID {
+		return ErrNotFound
+	}
+	sub.deleted = true
+	return nil
+}
+
+func (s *memorySubscriptions) DeactivateExpired(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, sub := range s.subs {
+		if !sub.deleted && !sub.Recurring && expired(sub.LastDate(), before) {
+			sub.deleted = true
+			count++
+		}
+	}
+	return count, nil
+}
+
+type memoryUsers struct {
+	mu    sync.Mutex
+	users map[int64]User
+}
+
+func (s *memoryUsers) Exists(ctx context.Context, chatID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.users[chatID]
+	return ok, nil
+}
+
+func (s *memoryUsers) Create(ctx context.Context, user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.ChatID]; ok {
+		return fmt.Errorf("user %d already exists", user.ChatID)
+	}
+	user.CreatedAt = time.Now().UTC()
+	s.users[user.ChatID] = user
+	return nil
+}
+
+func (s *memoryUsers) Count(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.users), nil
+}
+
+type memoryConversations struct {
+	mu            sync.Mutex
+	conversations map[int64]Conversation
+}
+
+func (s *memoryConversations) Save(ctx context.Context, conversation Conversation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conversations[conversation.ChatID] = conversation
+	return nil
+}
+
+func (s *memoryConversations) Delete(ctx context.Context, chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conversations, chatID)
+	return nil
+}
+
+func (s *memoryConversations) List(ctx context.Context) ([]Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conversations := make([]Conversation, 0, len(s.conversations))
+	for _, conversation := range s.conversations {
+		conversations = append(conversations, conversation)
+	}
+	sort.Slice(conversations, func(i, j int) bool { return conversations[i].ChatID < conversations[j].ChatID })
+	return conversations, nil
+}
+
+type memoryPrices struct {
+	mu     sync.Mutex
+	points []PricePoint
+}
+
+func (s *memoryPrices) Record(ctx context.Context, check PriceCheck) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	latest := make(map[priceSeries]float64)
+	for _, point := range s.points {
+		if point.DepartureStationID == check.DepartureStationID && point.ArrivalStationID == check.ArrivalStationID &&
+			point.TravelDate == check.TravelDate {
+			latest[priceSeries{point.TrainNumber, point.CabinClass}] = point.Price
+		}
+	}
+	s.points = append(s.points, changedPrices(check, latest)...)
+	return nil
+}
+
+func (s *memoryPrices) History(ctx context.Context, departureID, arrivalID int, travelDate string) ([]PricePoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var points []PricePoint
+	for _, point := range s.points {
+		if point.DepartureStationID == departureID && point.ArrivalStationID == arrivalID && point.TravelDate == travelDate {
+			points = append(points, point)
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].ObservedAt.Before(points[j].ObservedAt) })
+	return points, nil
+}
+
+func (s *memoryPrices) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.points[:0]
+	for _, point := range s.points {
+		if !expired(point.TravelDate, before) {
+			kept = append(kept, point)
+		}
+	}
+	count := len(s.points) - len(kept)
+	s.points = kept
+	return count, nil
+}
@@ -0,0 +1,467 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	tcdddb "tcddbot/db"
+	"tcddbot/model"
+)
+
+// NewSQL returns stores backed by a migrated SQLite or PostgreSQL database.
+func NewSQL(db *sql.DB, dialect tcdddb.Dialect) *Store {
+	return &Store{
+		Subscriptions: &sqlSubscriptions{db: db, dialect: dialect},
+		Users:         &sqlUsers{db: db, dialect: dialect},
+		Prices:        &sqlPrices{db: db, dialect: dialect},
+		Conversations: &sqlConversations{db: db, dialect: dialect},
+	}
+}
+
+type sqlSubscriptions struct {
+	db      *sql.DB
+	dialect tcdddb.Dialect
+}
+
+const subscriptionColumns = `id, chat_id, departure_station_id, arrival_station_id, travel_date, travel_date_end,
+        weekdays, keep_watching, satisfied_dates, recurring, weeks, paused_weeks, round_trip_id, return_leg, both_legs,
+        via_station_id, nearby, cabin_classes, earliest_departure, latest_departure, passengers, max_price, last_notified, created_at`
+
+func scanSubscription(row interface{ Scan(...any) error }) (Subscription, error) {
+	var sub Subscription
+	var weekdays, satisfiedDates, pausedWeeks, cabinClasses, passengers string
+	var lastNotified, createdAt sql.NullTime
+	err := row.Scan(&sub.ID, &sub.ChatID, &sub.DepartureStationID, &sub.ArrivalStationID,
+		&sub.TravelDate, &sub.TravelDateEnd, &weekdays, &sub.KeepWatching, &satisfiedDates,
+		&sub.Recurring, &sub.Weeks, &pausedWeeks, &sub.RoundTripID, &sub.ReturnLeg, &sub.BothLegs,
+		&sub.ViaStationID, &sub.Nearby, &cabinClasses, &sub.EarliestDeparture, &sub.LatestDeparture, &passengers,
+		&sub.MaxPrice, &lastNotified, &createdAt)
+	if err != nil {
+		return sub, err
+	}
+	if sub.Weekdays, err = splitWeekdays(weekdays); err != nil {
+		return sub, fmt.Errorf("subscription %d: %w", sub.ID, err)
+	}
+	sub.SatisfiedDates = splitCodes(satisfiedDates)
+	sub.PausedWeeks = splitCodes(pausedWeeks)
+	sub.CabinClasses = splitCodes(cabinClasses)
+	if sub.Passengers, err = model.ParsePassengers(passengers); err != nil {
+		return sub, fmt.Errorf("subscription %d: %w", sub.ID, err)
+	}
+	sub.LastNotified = lastNotified.Time
+	sub.CreatedAt = createdAt.Time
+	return sub, err
+}
+
+func (s *sqlSubscriptions) Create(ctx context.Context, sub *Subscription) error {
+	return s.insert(ctx, s.db, sub)
+}
+
+func (s *sqlSubscriptions) CreateRoundTrip(ctx context.Context, outbound, inbound *Subscription) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	outbound.ReturnLeg, inbound.ReturnLeg = false, true
+	if err := s.insert(ctx, tx, outbound); err != nil {
+		return err
+	}
+	outbound.RoundTripID, inbound.RoundTripID = outbound.ID, outbound.ID
+	if _, err := tx.ExecContext(ctx, s.dialect.Rebind(`
+        UPDATE subscriptions
+        SET round_trip_id = ?
+        WHERE id = ?`), outbound.ID, outbound.ID); err != nil {
+		return err
+	}
+	if err := s.insert(ctx, tx, inbound); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlSubscriptions) insert(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, sub *Subscription) error {
+	// lib/pq has no LastInsertId, RETURNING works on both dialects
+	return q.QueryRowContext(ctx, s.dialect.Rebind(`
+        INSERT INTO subscriptions (chat_id, departure_station_id, arrival_station_id, travel_date, travel_date_end,
+            weekdays, keep_watching, satisfied_dates, recurring, weeks, paused_weeks, round_trip_id, return_leg,
+            both_legs, via_station_id, nearby, cabin_classes, earliest_departure, latest_departure, passengers,
+            max_price)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        RETURNING id`),
+		sub.ChatID, sub.DepartureStationID, sub.ArrivalStationID, sub.TravelDate, sub.TravelDateEnd,
+		joinWeekdays(sub.Weekdays), sub.KeepWatching, joinCodes(sub.SatisfiedDates), sub.Recurring, sub.Weeks,
+		joinCodes(sub.PausedWeeks), sub.RoundTripID, sub.ReturnLeg, sub.BothLegs, sub.ViaStationID, sub.Nearby, joinCodes(sub.CabinClasses),
+		sub.EarliestDeparture, sub.LatestDeparture, sub.Passengers.String(), sub.MaxPrice).Scan(&sub.ID)
+}
+
+func (s *sqlSubscriptions) Get(ctx context.Context, id int64) (*Subscription, error) {
+	sub, err := scanSubscription(s.db.QueryRowContext(ctx, s.dialect.Rebind(`
+        SELECT `)+subscriptionColumns+`
+        FROM subscriptions
+        WHERE id = ? AND deleted_at IS NULL`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (s *sqlSubscriptions) ListActive(ctx context.Context) ([]Subscription, error) {
+	return s.list(ctx, `
+        SELECT `+subscriptionColumns+`
+        FROM subscriptions
+        WHERE deleted_at IS NULL
+        ORDER BY departure_station_id, arrival_station_id, travel_date, id`)
+}
+
+func (s *sqlSubscriptions) ListActiveByChat(ctx context.Context, chatID int64) ([]Subscription, error) {
+	return s.list(ctx, `
+        SELECT `+subscriptionColumns+`
+        FROM subscriptions
+        WHERE chat_id = ? AND deleted_at IS NULL
+        ORDER BY created_at DESC, id DESC`, chatID)
+}
+
+func (s *sqlSubscriptions) list(ctx context.Context, query string, args ...any) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, s.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []Subscription
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (s *sqlSubscriptions) ExistsActive(ctx context.Context, chatID int64, departureID, arrivalID int, travelDate string) (bool, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, s.dialect.Rebind(`
+        SELECT COUNT(*) FROM subscriptions
+        WHERE chat_id = ? AND departure_station_id = ? AND arrival_station_id = ? AND travel_date = ? AND deleted_at IS NULL`),
+		chatID, departureID, arrivalID, travelDate).Scan(&count)
+	return count > 0, err
+}
+
+func (s *sqlSubscriptions) MarkNotified(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(`
+        UPDATE subscriptions
+        SET last_notified = CURRENT_TIMESTAMP
+        WHERE id = ?`), id)
+	return err
+}
+
+func (s *sqlSubscriptions) SetSatisfiedDates(ctx context.Context, id int64, dates []string) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(`
+        UPDATE subscriptions
+        SET satisfied_dates = ?
+        WHERE id = ?`), joinCodes(dates), id)
+	return err
+}
+
+func (s *sqlSubscriptions) SetPausedWeeks(ctx context.Context, id int64, weeks []string) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(`
+        UPDATE subscriptions
+        SET paused_weeks = ?
+        WHERE id = ?`), joinCodes(weeks), id)
+	return err
+}
+
+func (s *sqlSubscriptions) SetNearby(ctx context.Context, id int64, nearby bool) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(`
+        UPDATE subscriptions
+        SET nearby = ?
+        WHERE id = ?`), nearby, id)
+	return err
+}
+
+func (s *sqlSubscriptions) AlertPrices(ctx context.Context) (map[AlertKey]float64, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT subscription_id, travel_date, price
+        FROM alert_prices`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := make(map[AlertKey]float64)
+	for rows.Next() {
+		var key AlertKey
+		var price float64
+		if err := rows.Scan(&key.SubscriptionID, &key.TravelDate, &price); err != nil {
+			return nil, err
+		}
+		prices[key] = price
+	}
+	return prices, rows.Err()
+}
+
+func (s *sqlSubscriptions) AlertPrice(ctx context.Context, id int64, travelDate string) (float64, error) {
+	var price float64
+	err := s.db.QueryRowContext(ctx, s.dialect.Rebind(`
+        SELECT price
+        FROM alert_prices
+        WHERE subscription_id = ? AND travel_date = ?`), id, travelDate).Scan(&price)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return price, err
+}
+
+func (s *sqlSubscriptions) SetAlertPrice(ctx context.Context, id int64, travelDate string, price float64) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(`
+        INSERT INTO alert_prices (subscription_id, travel_date, price)
+        VALUES (?, ?, ?)
+        ON CONFLICT (subscription_id, travel_date) DO UPDATE SET price = excluded.price`),
+		id, travelDate, price)
+	return err
+}
+
+func (s *sqlSubscriptions) Deactivate(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(`
+        UPDATE subscriptions
+        SET deleted_at = CURRENT_TIMESTAMP
+        WHERE id = ? AND deleted_at IS NULL`), id)
+	return err
+}
+
+func (s *sqlSubscriptions) Cancel(ctx context.Context, chatID, id int64) error {
+	result, err := s.db.ExecContext(ctx, s.dialect.Rebind(`
+        UPDATE subscriptions
+        SET deleted_at = CURRENT_TIMESTAMP
+        WHERE id = ? AND chat_id = ? AND deleted_at IS NULL`), id, chatID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlSubscriptions) DeactivateExpired(ctx context.Context, before time.Time) (int, error) {
+	// travel_date is GG-AA-YYYY and does not compare as text, filter in Go
+	subs, err := s.ListActive(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, sub := range subs {
+		if sub.Recurring || !expired(sub.LastDate(), before) {
+			continue
+		}
+		if err := s.Deactivate(ctx, sub.ID); err != nil {
+			return count, fmt.Errorf("deactivate subscription %d: %w", sub.ID, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+type sqlUsers struct {
+	db      *sql.DB
+	dialect tcdddb.Dialect
+}
+
+func (s *sqlUsers) Exists(ctx context.Context, chatID int64) (bool, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, s.dialect.Rebind(`SELECT COUNT(*) FROM users WHERE chat_id = ?`), chatID).Scan(&count)
+	return count > 0, err
+}
+
+func (s *sqlUsers) Create(ctx context.Context, user User) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(`
+        INSERT INTO users (chat_id, username, first_name, last_name)
+        VALUES (?, ?, ?, ?)`),
+		user.ChatID, user.UserName, user.FirstName, user.LastName)
+	return err
+}
+
+func (s *sqlUsers) Count(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&count)
+	return count, err
+}
+
+type sqlConversations struct {
+	db      *sql.DB
+	dialect tcdddb.Dialect
+}
+
+func (s *sqlConversations) Save(ctx context.Context, conversation Conversation) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(`
+        INSERT INTO conversations (chat_id, data, updated_at)
+        VALUES (?, ?, ?)
+        ON CONFLICT (chat_id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`),
+		conversation.ChatID, conversation.Data, conversation.UpdatedAt.UTC())
+	return err
+}
+
+func (s *sqlConversations) Delete(ctx context.Context, chatID int64) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(`DELETE FROM conversations WHERE chat_id = ?`), chatID)
+	return err
+}
+
+func (s *sqlConversations) List(ctx context.Context) ([]Conversation, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT chat_id, data, updated_at FROM conversations ORDER BY chat_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+	for rows.Next() {
+		var conversation Conversation
+		if err := rows.Scan(&conversation.ChatID, &conversation.Data, &conversation.UpdatedAt); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, rows.Err()
+}
+
+type sqlPrices struct {
+	db      *sql.DB
+	dialect tcdddb.Dialect
+}
+
+func (s *sqlPrices) Record(ctx context.Context, check PriceCheck) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	latest, err := s.latest(ctx, tx, check)
+	if err != nil {
+		return fmt.Errorf("load latest prices: %w", err)
+	}
+
+	for _, point := range changedPrices(check, latest) {
+		var departureTime sql.NullTime
+		if !point.DepartureTime.IsZero() {
+			departureTime = sql.NullTime{Time: point.DepartureTime.UTC(), Valid: true}
+		}
+		_, err := tx.ExecContext(ctx, s.dialect.Rebind(`
+            INSERT INTO price_history (departure_station_id, arrival_station_id, travel_date, train_number,
+                departure_time, cabin_class, cabin_class_name, price, currency, observed_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			point.DepartureStationID, point.ArrivalStationID, point.TravelDate, point.TrainNumber,
+			departureTime, point.CabinClass, point.CabinClassName, point.Price, point.Currency, point.ObservedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// latest returns the newest price of every train and cabin class of the
+// route and date of a check
+func (s *sqlPrices) latest(ctx context.Context, tx *sql.Tx, check PriceCheck) (map[priceSeries]float64, error) {
+	rows, err := tx.QueryContext(ctx, s.dialect.Rebind(`
+        SELECT train_number, cabin_class, price
+        FROM price_history
+        WHERE departure_station_id = ? AND arrival_station_id = ? AND travel_date = ?
+        ORDER BY observed_at, id`), check.DepartureStationID, check.ArrivalStationID, check.TravelDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	latest := make(map[priceSeries]float64)
+	for rows.Next() {
+		var series priceSeries
+		var price float64
+		if err := rows.Scan(&series.train, &series.cabin, &price); err != nil {
+			return nil, err
+		}
+		latest[series] = price
+	}
+	return latest, rows.Err()
+}
+
+func (s *sqlPrices) History(ctx context.Context, departureID, arrivalID int, travelDate string) ([]PricePoint, error) {
+	rows, err := s.db.QueryContext(ctx, s.dialect.Rebind(`
+        SELECT departure_station_id, arrival_station_id, travel_date, train_number, departure_time,
+            cabin_class, cabin_class_name, price, currency, observed_at
+        FROM price_history
+        WHERE departure_station_id = ? AND arrival_station_id = ? AND travel_date = ?
+        ORDER BY observed_at, id`), departureID, arrivalID, travelDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []PricePoint
+	for rows.Next() {
+		var point PricePoint
+		var departureTime sql.NullTime
+		err := rows.Scan(&point.DepartureStationID, &point.ArrivalStationID, &point.TravelDate,
+			&point.TrainNumber, &departureTime, &point.CabinClass, &point.CabinClassName,
+			&point.Price, &point.Currency, &point.ObservedAt)
+		if err != nil {
+			return nil, err
+		}
+		point.DepartureTime = departureTime.Time
+		points = append(points, point)
+	}
+	return points, rows.Err()
+}
+
+func (s *sqlPrices) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	// travel_date is GG-AA-YYYY and does not compare as text, filter in Go
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT travel_date FROM price_history`)
+	if err != nil {
+		return 0, err
+	}
+	var dates []string
+	for rows.Next() {
+		var date string
+		if err := rows.Scan(&date); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if expired(date, before) {
+			dates = append(dates, date)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, date := range dates {
+		result, err := s.db.ExecContext(ctx, s.dialect.Rebind(`DELETE FROM price_history WHERE travel_date = ?`), date)
+		if err != nil {
+			return count, fmt.Errorf("delete prices of %s: %w", date, err)
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return count, err
+		}
+		count += int(deleted)
+	}
+	return count, nil
+}
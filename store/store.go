@@ -0,0 +1,356 @@
+// Package store persists subscriptions and users behind interfaces so the
+// handlers do not depend on a particular database.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"tcddbot/model"
+	"time"
+)
+
+// ErrNotFound is returned when a record does not exist, is already deleted
+// or belongs to another chat.
+var ErrNotFound = errors.New("store: not found")
+
+// TravelDateLayout is the format of Subscription.TravelDate.
+const TravelDateLayout = "02-01-2006"
+
+// Subscription is a user's request to be notified about seats on a route.
+type Subscription struct {
+	ID                 int64
+	ChatID             int64
+	DepartureStationID int
+	ArrivalStationID   int
+	TravelDate         string           // GG-AA-YYYY, the first day of a date range
+	TravelDateEnd      string           // Last day of a date range, empty for a single date
+	Weekdays           []time.Weekday   // Days of a date range to watch, empty watches all
+	KeepWatching       bool             // Keep watching the other days of a range after a match
+	SatisfiedDates     []string         // Days of a range that already had a match
+	Recurring          bool             // Repeats on Weekdays every week from TravelDate on
+	Weeks              int              // Weeks ahead a recurring subscription watches
+	PausedWeeks        []string         // Mondays of the weeks a recurring subscription skips
+	RoundTripID        int64            // ID of the outbound leg shared by both legs of a round trip, 0 for one way
+	ReturnLeg          bool             // The return leg of a round trip
+	BothLegs           bool             // Report a round trip only when both legs have seats
+	ViaStationID       int              // Station to change trains at, 0 for a direct journey
+	Nearby             bool             // Also watch sibling stations of the departure and arrival
+	CabinClasses       []string         // Accepted cabin class codes, empty accepts all
+	EarliestDeparture  string           // HH:MM in Europe/Istanbul, empty is open ended
+	LatestDeparture    string           // HH:MM in Europe/Istanbul, empty is open ended
+	Passengers         model.Passengers // Nil for a single adult
+	MaxPrice           float64          // Highest fare per passenger, 0 accepts any
+	LastNotified       time.Time        // Zero if the user was never notified
+	CreatedAt          time.Time
+}
+
+// AlertKey identifies one day of a subscription. Every day of a date range
+// has its own fares, so price drops are measured per day.
+type AlertKey struct {
+	SubscriptionID int64
+	TravelDate     string
+}
+
+// SubscriptionStore stores subscriptions. Every operation on an existing
+// subscription is keyed by its ID.
+type SubscriptionStore interface {
+	// Create stores sub and sets its ID.
+	Create(ctx context.Context, sub *Subscription) error
+	// CreateRoundTrip stores both legs of a round trip, linking them through
+	// the ID of the outbound leg.
+	CreateRoundTrip(ctx context.Context, outbound, inbound *Subscription) error
+	Get(ctx context.Context, id int64) (*Subscription, error)
+	// ListActive returns every active subscription ordered by route and date.
+	ListActive(ctx context.Context) ([]Subscription, error)
+	// ListActiveByChat returns the active subscriptions of a chat, newest first.
+	ListActiveByChat(ctx context.Context, chatID int64) ([]Subscription, error)
+	// ExistsActive reports whether the chat already watches the route on date.
+	ExistsActive(ctx context.Context, chatID int64, departureID, arrivalID int, travelDate string) (bool, error)
+	MarkNotified(ctx context.Context, id int64) error
+	// SetSatisfiedDates replaces the days of a date range that already had
+	// a match.
+	SetSatisfiedDates(ctx context.Context, id int64, dates []string) error
+	// SetPausedWeeks replaces the weeks a recurring subscription skips.
+	SetPausedWeeks(ctx context.Context, id int64, weeks []string) error
+	// SetNearby turns watching the sibling stations of the route on or off.
+	SetNearby(ctx context.Context, id int64, nearby bool) error
+	// AlertPrices returns the fares price drops are measured against, by
+	// subscription and day.
+	AlertPrices(ctx context.Context) (map[AlertKey]float64, error)
+	// AlertPrice returns the fare price drops on one day of a subscription
+	// are measured against, 0 until it is first priced.
+	AlertPrice(ctx context.Context, id int64, travelDate string) (float64, error)
+	// SetAlertPrice records the fare later price drops on travelDate are
+	// measured against.
+	SetAlertPrice(ctx context.Context, id int64, travelDate string, price float64) error
+	// Deactivate soft deletes a subscription, e.g. once it has been satisfied.
+	Deactivate(ctx context.Context, id int64) error
+	// Cancel soft deletes a subscription on behalf of its owner.
+	Cancel(ctx context.Context, chatID, id int64) error
+	// DeactivateExpired soft deletes subscriptions whose travel date is
+	// before the given day and returns how many were affected. Recurring
+	// subscriptions never expire.
+	DeactivateExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+// IsRange reports whether the subscription watches more than one day.
+func (s Subscription) IsRange() bool {
+	return s.TravelDateEnd != "" || s.Recurring
+}
+
+// IsRoundTrip reports whether the subscription is one leg of a round trip.
+func (s Subscription) IsRoundTrip() bool {
+	return s.RoundTripID != 0
+}
+
+// LastDate returns the last day the subscription watches, empty for a
+// recurring subscription.
+func (s Subscription) LastDate() string {
+	switch {
+	case s.Recurring:
+		return ""
+	case s.TravelDateEnd != "":
+		return s.TravelDateEnd
+	}
+	return s.TravelDate
+}
+
+// Dates returns the days still to check, oldest first. Days of a range
+// before today, off the chosen weekdays, already satisfied or in a paused
+// week are left out. A recurring subscription covers Weeks weeks from today.
+func (s Subscription) Dates(today time.Time) []string {
+	if !s.IsRange() {
+		return []string{s.TravelDate}
+	}
+
+	start, err := time.Parse(TravelDateLayout, s.TravelDate)
+	if err != nil {
+		return nil
+	}
+	y, m, d := today.Date()
+	if first := time.Date(y, m, d, 0, 0, 0, 0, time.UTC); first.After(start) {
+		start = first
+	}
+
+	var end time.Time
+	if s.Recurring {
+		end = start.AddDate(0, 0, 7*max(s.Weeks, 1)-1)
+	} else if end, err = time.Parse(TravelDateLayout, s.TravelDateEnd); err != nil {
+		return nil
+	}
+
+	skipped := make(map[string]bool)
+	for _, date := range s.SatisfiedDates {
+		skipped[date] = true
+	}
+	paused := make(map[string]bool)
+	for _, week := range s.PausedWeeks {
+		paused[week] = true
+	}
+
+	var dates []string
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format(TravelDateLayout)
+		if skipped[date] || paused[WeekOf(day)] || !s.watchesWeekday(day.Weekday()) {
+			continue
+		}
+		dates = append(dates, date)
+	}
+	return dates
+}
+
+// WeekOf returns the Monday of the week of day, the key of PausedWeeks.
+func WeekOf(day time.Time) string {
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset).Format(TravelDateLayout)
+}
+
+func (s Subscription) watchesWeekday(weekday time.Weekday) bool {
+	if len(s.Weekdays) == 0 {
+		return true
+	}
+	for _, w := range s.Weekdays {
+		if w == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// User is a chat that has talked to the bot.
+type User struct {
+	ChatID    int64
+	UserName  string
+	FirstName string
+	LastName  string
+	CreatedAt time.Time
+}
+
+// UserStore stores users.
+type UserStore interface {
+	Exists(ctx context.Context, chatID int64) (bool, error)
+	Create(ctx context.Context, user User) error
+	Count(ctx context.Context) (int, error)
+}
+
+// Conversation is the state of a chat in the middle of the subscription
+// wizard. Data is encoded by the handlers, the store keeps it as is.
+type Conversation struct {
+	ChatID    int64
+	Data      string
+	UpdatedAt time.Time
+}
+
+// ConversationStore keeps unfinished wizards across restarts.
+type ConversationStore interface {
+	// Save creates or replaces the conversation of a chat.
+	Save(ctx context.Context, conversation Conversation) error
+	// Delete removes the conversation of a chat, if there is one.
+	Delete(ctx context.Context, chatID int64) error
+	// List returns every saved conversation.
+	List(ctx context.Context) ([]Conversation, error)
+}
+
+// PricePoint is the cheapest fare of a cabin class on one train at one point
+// in time. A zero Price marks the cabin class as no longer on sale.
+type PricePoint struct {
+	DepartureStationID int
+	ArrivalStationID   int
+	TravelDate         string // GG-AA-YYYY
+	TrainNumber        string
+	DepartureTime      time.Time
+	CabinClass         string // Cabin class code
+	CabinClassName     string
+	Price              float64
+	Currency           string
+	ObservedAt         time.Time
+}
+
+// PriceCheck is what one availability check saw on a route and date. The
+// route, date and time of its fares are taken from the check.
+type PriceCheck struct {
+	DepartureStationID int
+	ArrivalStationID   int
+	TravelDate         string
+	ObservedAt         time.Time // Now when zero
+	Fares              []PricePoint
+}
+
+// PriceHistoryStore stores the fares seen by availability checks.
+type PriceHistoryStore interface {
+	// Record stores the fares of a check. A fare equal to the latest stored
+	// price of its train and cabin class is skipped and a cabin class
+	// missing from the check gets a zero price, so the history only grows
+	// when something changes.
+	Record(ctx context.Context, check PriceCheck) error
+	// History returns the points of a route and date, oldest first.
+	History(ctx context.Context, departureID, arrivalID int, travelDate string) ([]PricePoint, error)
+	// DeleteExpired removes the points of travel dates before the given day
+	// and returns how many were removed.
+	DeleteExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+// Store groups the stores used by the bot.
+type Store struct {
+	Subscriptions SubscriptionStore
+	Users         UserStore
+	Prices        PriceHistoryStore
+	Conversations ConversationStore
+}
+
+// priceSeries identifies the train and cabin class a price point belongs to.
+type priceSeries struct {
+	train, cabin string
+}
+
+// changedPrices returns the points a check adds to a route whose latest
+// prices are known, in a stable order.
+func changedPrices(check PriceCheck, latest map[priceSeries]float64) []PricePoint {
+	observedAt := check.ObservedAt
+	if observedAt.IsZero() {
+		observedAt = time.Now()
+	}
+
+	var points []PricePoint
+	seen := make(map[priceSeries]bool)
+	for _, point := range check.Fares {
+		series := priceSeries{point.TrainNumber, point.CabinClass}
+		seen[series] = true
+		if price, ok := latest[series]; ok && price == point.Price {
+			continue
+		}
+		latest[series] = point.Price
+		points = append(points, point)
+	}
+
+	var closed []priceSeries
+	for series, price := range latest {
+		if price != 0 && !seen[series] {
+			closed = append(closed, series)
+		}
+	}
+	sort.Slice(closed, func(i, j int) bool {
+		if closed[i].train != closed[j].train {
+			return closed[i].train < closed[j].train
+		}
+		return closed[i].cabin < closed[j].cabin
+	})
+	for _, series := range closed {
+		latest[series] = 0
+		points = append(points, PricePoint{TrainNumber: series.train, CabinClass: series.cabin})
+	}
+
+	for i := range points {
+		points[i].DepartureStationID = check.DepartureStationID
+		points[i].ArrivalStationID = check.ArrivalStationID
+		points[i].TravelDate = check.TravelDate
+		points[i].ObservedAt = observedAt.UTC()
+	}
+	return points
+}
+
+// joinCodes and splitCodes store a list of codes in a single text column.
+func joinCodes(codes []string) string {
+	return strings.Join(codes, ",")
+}
+
+func splitCodes(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func joinWeekdays(weekdays []time.Weekday) string {
+	codes := make([]string, len(weekdays))
+	for i, weekday := range weekdays {
+		codes[i] = strconv.Itoa(int(weekday))
+	}
+	return joinCodes(codes)
+}
+
+func splitWeekdays(s string) ([]time.Weekday, error) {
+	var weekdays []time.Weekday
+	for _, code := range splitCodes(s) {
+		n, err := strconv.Atoi(code)
+		if err != nil || n < 0 || n > 6 {
+			return nil, fmt.Errorf("invalid weekday %q", code)
+		}
+		weekdays = append(weekdays, time.Weekday(n))
+	}
+	return weekdays, nil
+}
+
+// expired reports whether travelDate falls before the day of before.
+func expired(travelDate string, before time.Time) bool {
+	date, err := time.Parse(TravelDateLayout, travelDate)
+	if err != nil {
+		return false
+	}
+	y, m, d := before.Date()
+	return date.Before(time.Date(y, m, d, 0, 0, 0, 0, time.UTC))
+}
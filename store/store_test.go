@@ -0,0 +1,52 @@
+package store_test
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"tcddbot/store"
+	"tcddbot/store/storetest"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	storetest.StopLocalPostgres()
+	os.Exit(code)
+}
+
+func TestSQLite(t *testing.T)   { storetest.Run(t, storetest.SQLite) }
+func TestPostgres(t *testing.T) { storetest.Run(t, storetest.Postgres) }
+func TestMemory(t *testing.T)   { storetest.Run(t, storetest.Memory) }
+
+func TestSubscriptionDates(t *testing.T) {
+	// A Monday
+	today := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		sub  store.Subscription
+		want []string
+	}{
+		{"single date", store.Subscription{TravelDate: "01-06-2030"}, []string{"01-06-2030"}},
+		{"range from today on", store.Subscription{TravelDate: "01-06-2030", TravelDateEnd: "05-06-2030"},
+			[]string{"03-06-2030", "04-06-2030", "05-06-2030"}},
+		{"range over", store.Subscription{TravelDate: "01-06-2030", TravelDateEnd: "02-06-2030"}, nil},
+		{"weekdays", store.Subscription{TravelDate: "03-06-2030", TravelDateEnd: "16-06-2030", Weekdays: []time.Weekday{time.Monday, time.Wednesday}},
+			[]string{"03-06-2030", "05-06-2030", "10-06-2030", "12-06-2030"}},
+		{"satisfied days", store.Subscription{TravelDate: "03-06-2030", TravelDateEnd: "05-06-2030", SatisfiedDates: []string{"04-06-2030"}},
+			[]string{"03-06-2030", "05-06-2030"}},
+		{"recurring", store.Subscription{TravelDate: "01-06-2030", Recurring: true, Weeks: 2, Weekdays: []time.Weekday{time.Friday}},
+			[]string{"07-06-2030", "14-06-2030"}},
+		{"recurring paused week", store.Subscription{TravelDate: "01-06-2030", Recurring: true, Weeks: 2, Weekdays: []time.Weekday{time.Monday}, PausedWeeks: []string{"10-06-2030"}},
+			[]string{"03-06-2030"}},
+		{"bad date", store.Subscription{TravelDate: "2030-06-03", TravelDateEnd: "05-06-2030"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sub.Dates(today); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package storetest
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tcddbot/db"
+	"tcddbot/store"
+)
+
+// PostgresURLEnv names the variable holding the DSN of a PostgreSQL server
+// the suite may use. Each store gets its own schema in that database.
+const PostgresURLEnv = "TEST_DATABASE_URL"
+
+// Memory returns an in-memory store.
+func Memory(t testing.TB) *store.Store {
+	return store.NewMemory()
+}
+
+// SQLite returns a store on a migrated SQLite database in a temp dir.
+func SQLite(t testing.TB) *store.Store {
+	database, err := db.Initialize(db.SQLite, filepath.Join(t.TempDir(), "tcddbot.db"))
+	if err != nil {
+		t.Fatalf("initialize sqlite: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return store.NewSQL(database, db.SQLite)
+}
+
+// Postgres returns a store on a fresh, migrated schema. The server comes
+// from TEST_DATABASE_URL, else a throwaway cluster is started with the
+// initdb and pg_ctl binaries on PATH. Without either the test is skipped.
+func Postgres(t testing.TB) *store.Store {
+	dsn := os.Getenv(PostgresURLEnv)
+	if dsn == "" {
+		var err error
+		if dsn, err = localPostgres(); err != nil {
+			t.Skipf("postgres not available, set %s to use a running server: %v", PostgresURLEnv, err)
+		}
+	}
+
+	schema := fmt.Sprintf("storetest_%d_%d", os.Getpid(), schemaSeq.Add(1))
+	admin, err := db.Open(db.Postgres, dsn)
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	defer admin.Close()
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if admin, err := db.Open(db.Postgres, dsn); err == nil {
+			admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+			admin.Close()
+		}
+	})
+
+	scoped, err := withSearchPath(dsn, schema)
+	if err != nil {
+		t.Fatalf("postgres dsn: %v", err)
+	}
+	database, err := db.Initialize(db.Postgres, scoped)
+	if err != nil {
+		t.Fatalf("initialize postgres: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return store.NewSQL(database, db.Postgres)
+}
+
+var schemaSeq atomic.Int64
+
+// withSearchPath points every connection of a URL style DSN at schema.
+func withSearchPath(dsn, schema string) (string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return "", fmt.Errorf("%s must be a postgres:// URL", PostgresURLEnv)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+var local struct {
+	once sync.Once
+	dir  string
+	dsn  string
+	err  error
+}
+
+// localPostgres starts one cluster per test binary and returns its DSN.
+func localPostgres() (string, error) {
+	local.once.Do(func() {
+		local.dsn, local.err = startPostgres()
+	})
+	return local.dsn, local.err
+}
+
+func startPostgres() (string, error) {
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		return "", err
+	}
+	pgCtl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := os.MkdirTemp("", "tcddbot-pg-")
+	if err != nil {
+		return "", err
+	}
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("initdb: %v: %s", err, out)
+	}
+
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=''", port, dir)
+	start := exec.Command(pgCtl, "-D", data, "-l", filepath.Join(dir, "postgres.log"), "-o", opts, "-w", "-t", "30", "start")
+	if out, err := start.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("pg_ctl start: %v: %s", err, out)
+	}
+	local.dir = dir
+
+	dsn := fmt.Sprintf("postgres://postgres@/postgres?host=%s&port=%d&sslmode=disable", url.QueryEscape(dir), port)
+	return dsn, waitPostgres(dsn)
+}
+
+func waitPostgres(dsn string) error {
+	var err error
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		var database *sql.DB
+		if database, err = db.Open(db.Postgres, dsn); err == nil {
+			database.Close()
+			return nil
+		}
+	}
+	return err
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return strconv.Atoi(port)
+}
+
+// StopLocalPostgres stops the cluster started by Postgres, if any. Call it
+// from TestMain after m.Run.
+func StopLocalPostgres() {
+	if local.dir == "" {
+		return
+	}
+	if pgCtl, err := exec.LookPath("pg_ctl"); err == nil {
+		exec.Command(pgCtl, "-D", filepath.Join(local.dir, "data"), "-m", "immediate", "-w", "stop").Run()
+	}
+	os.RemoveAll(local.dir)
+	local.dir = ""
+}
@@ -0,0 +1,632 @@
+// Package storetest is a conformance suite for store implementations. Every
+// backend must pass the same cases so handlers behave alike on each:
+//
+//	func TestSQLite(t *testing.T)   { storetest.Run(t, storetest.SQLite) }
+//	func TestPostgres(t *testing.T) { storetest.Run(t, storetest.Postgres) }
+//	func TestMemory(t *testing.T)   { storetest.Run(t, storetest.Memory) }
+//
+// Postgres uses TEST_DATABASE_URL or starts a local cluster, which
+// StopLocalPostgres shuts down from TestMain.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tcddbot/model"
+	"tcddbot/store"
+)
+
+// Factory returns an empty store. It may skip t when the backend is not
+// available.
+type Factory func(t testing.TB) *store.Store
+
+// Run runs every case against a fresh store from newStore.
+func Run(t *testing.T, newStore Factory) {
+	cases := []struct {
+		name string
+		run  func(t *testing.T, st *store.Store)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"ListActive", testListActive},
+		{"ListActiveByChat", testListActiveByChat},
+		{"ExistsActive", testExistsActive},
+		{"MarkNotified", testMarkNotified},
+		{"SetAlertPrice", testSetAlertPrice},
+		{"SetNearby", testSetNearby},
+		{"DateRange", testDateRange},
+		{"Recurring", testRecurring},
+		{"RoundTrip", testRoundTrip},
+		{"Deactivate", testDeactivate},
+		{"Cancel", testCancel},
+		{"DeactivateExpired", testDeactivateExpired},
+		{"Users", testUsers},
+		{"Conversations", testConversations},
+		{"PriceHistory", testPriceHistory},
+		{"PriceHistoryDeleteExpired", testPriceHistoryDeleteExpired},
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.run(t, newStore(t))
+		})
+	}
+}
+
+func create(t *testing.T, st *store.Store, chatID int64, dep, arr int, date string) store.Subscription {
+	t.Helper()
+	sub := store.Subscription{ChatID: chatID, DepartureStationID: dep, ArrivalStationID: arr, TravelDate: date}
+	if err := st.Subscriptions.Create(context.Background(), &sub); err != nil {
+		t.Fatalf("create subscription: %v", err)
+	}
+	if sub.ID == 0 {
+		t.Fatalf("create subscription: ID not set")
+	}
+	return sub
+}
+
+func ids(subs []store.Subscription) []int64 {
+	out := make([]int64, len(subs))
+	for i, sub := range subs {
+		out[i] = sub.ID
+	}
+	return out
+}
+
+func expectIDs(t *testing.T, what string, got []store.Subscription, want ...int64) {
+	t.Helper()
+	gotIDs := ids(got)
+	if len(gotIDs) != len(want) {
+		t.Fatalf("%s: got IDs %v, want %v", what, gotIDs, want)
+	}
+	for i := range want {
+		if gotIDs[i] != want[i] {
+			t.Fatalf("%s: got IDs %v, want %v", what, gotIDs, want)
+		}
+	}
+}
+
+func testCreateAndGet(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	first := create(t, st, 1, 10, 20, "01-06-2030")
+	second := create(t, st, 1, 10, 20, "02-06-2030")
+	if first.ID == second.ID {
+		t.Fatalf("IDs are not unique: %d", first.ID)
+	}
+
+	got, err := st.Subscriptions.Get(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.ChatID != 1 || got.DepartureStationID != 10 || got.ArrivalStationID != 20 || got.TravelDate != "01-06-2030" {
+		t.Fatalf("get: unexpected subscription %+v", got)
+	}
+	if !got.LastNotified.IsZero() {
+		t.Fatalf("get: new subscription has LastNotified %v", got.LastNotified)
+	}
+	if got.CreatedAt.IsZero() {
+		t.Fatalf("get: CreatedAt not set")
+	}
+
+	if _, err := st.Subscriptions.Get(ctx, second.ID+1000); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("get missing: got %v, want ErrNotFound", err)
+	}
+
+	filtered := store.Subscription{ChatID: 1, DepartureStationID: 10, ArrivalStationID: 20, TravelDate: "03-06-2030",
+		CabinClasses: []string{"C", "Y1"}, EarliestDeparture: "07:00", LatestDeparture: "09:30",
+		Passengers: model.Passengers{{ID: model.PassengerAdult, Count: 3}},
+		MaxPrice:   612.5, ViaStationID: 30}
+	if err := st.Subscriptions.Create(ctx, &filtered); err != nil {
+		t.Fatalf("create filtered subscription: %v", err)
+	}
+	got, err = st.Subscriptions.Get(ctx, filtered.ID)
+	if err != nil {
+		t.Fatalf("get filtered: %v", err)
+	}
+	if len(got.CabinClasses) != 2 || got.CabinClasses[0] != "C" || got.CabinClasses[1] != "Y1" {
+		t.Fatalf("get filtered: got cabin classes %v, want [C Y1]", got.CabinClasses)
+	}
+	if got.EarliestDeparture != "07:00" || got.LatestDeparture != "09:30" {
+		t.Fatalf("get filtered: got departure window %q-%q, want 07:00-09:30", got.EarliestDeparture, got.LatestDeparture)
+	}
+	if got.Passengers.String() != "0:3" {
+		t.Fatalf("get filtered: got passengers %q, want 0:3", got.Passengers)
+	}
+	if got.MaxPrice != 612.5 {
+		t.Fatalf("get filtered: got max price %v, want 612.5", got.MaxPrice)
+	}
+	if got.ViaStationID != 30 {
+		t.Fatalf("get filtered: got via station %d, want 30", got.ViaStationID)
+	}
+	if len(second.CabinClasses) != 0 {
+		t.Fatalf("create: unfiltered subscription got cabin classes %v", second.CabinClasses)
+	}
+}
+
+func testListActive(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	c := create(t, st, 1, 20, 10, "01-06-2030")
+	a := create(t, st, 2, 10, 20, "02-06-2030")
+	b := create(t, st, 1, 10, 20, "01-06-2030")
+	d := create(t, st, 3, 10, 20, "01-06-2030")
+	if err := st.Subscriptions.Deactivate(ctx, d.ID); err != nil {
+		t.Fatalf("deactivate: %v", err)
+	}
+
+	subs, err := st.Subscriptions.ListActive(ctx)
+	if err != nil {
+		t.Fatalf("list active: %v", err)
+	}
+	expectIDs(t, "list active", subs, b.ID, a.ID, c.ID)
+}
+
+func testListActiveByChat(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	older := create(t, st, 1, 10, 20, "01-06-2030")
+	create(t, st, 2, 10, 20, "01-06-2030")
+	newer := create(t, st, 1, 30, 40, "01-06-2030")
+
+	subs, err := st.Subscriptions.ListActiveByChat(ctx, 1)
+	if err != nil {
+		t.Fatalf("list by chat: %v", err)
+	}
+	expectIDs(t, "list by chat", subs, newer.ID, older.ID)
+
+	subs, err = st.Subscriptions.ListActiveByChat(ctx, 99)
+	if err != nil {
+		t.Fatalf("list by chat: %v", err)
+	}
+	expectIDs(t, "list by unknown chat", subs)
+}
+
+func testExistsActive(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	sub := create(t, st, 1, 10, 20, "01-06-2030")
+
+	exists := func(chatID int64, dep, arr int, date string) bool {
+		t.Helper()
+		ok, err := st.Subscriptions.ExistsActive(ctx, chatID, dep, arr, date)
+		if err != nil {
+			t.Fatalf("exists: %v", err)
+		}
+		return ok
+	}
+
+	if !exists(1, 10, 20, "01-06-2030") {
+		t.Fatalf("exists: subscription not found")
+	}
+	if exists(2, 10, 20, "01-06-2030") || exists(1, 20, 10, "01-06-2030") || exists(1, 10, 20, "02-06-2030") {
+		t.Fatalf("exists: matched a different subscription")
+	}
+
+	if err := st.Subscriptions.Deactivate(ctx, sub.ID); err != nil {
+		t.Fatalf("deactivate: %v", err)
+	}
+	if exists(1, 10, 20, "01-06-2030") {
+		t.Fatalf("exists: matched a deactivated subscription")
+	}
+}
+
+func testMarkNotified(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	sub := create(t, st, 1, 10, 20, "01-06-2030")
+	if err := st.Subscriptions.MarkNotified(ctx, sub.ID); err != nil {
+		t.Fatalf("mark notified: %v", err)
+	}
+
+	got, err := st.Subscriptions.Get(ctx, sub.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.LastNotified.IsZero() {
+		t.Fatalf("mark notified: LastNotified not set")
+	}
+	if d := time.Since(got.LastNotified); d < -time.Minute || d > time.Minute {
+		t.Fatalf("mark notified: LastNotified %v is not now", got.LastNotified)
+	}
+}
+
+func testSetAlertPrice(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	sub := create(t, st, 1, 10, 20, "01-06-2030")
+	if price, err := st.Subscriptions.AlertPrice(ctx, sub.ID, "01-06-2030"); err != nil || price != 0 {
+		t.Fatalf("alert price of a new subscription: got %v, %v, want 0", price, err)
+	}
+
+	// Every day of a range keeps its own price
+	if err := st.Subscriptions.SetAlertPrice(ctx, sub.ID, "01-06-2030", 487.5); err != nil {
+		t.Fatalf("set alert price: %v", err)
+	}
+	if err := st.Subscriptions.SetAlertPrice(ctx, sub.ID, "02-06-2030", 500); err != nil {
+		t.Fatalf("set alert price: %v", err)
+	}
+	if err := st.Subscriptions.SetAlertPrice(ctx, sub.ID, "02-06-2030", 400); err != nil {
+		t.Fatalf("replace alert price: %v", err)
+	}
+
+	for date, want := range map[string]float64{"01-06-2030": 487.5, "02-06-2030": 400, "03-06-2030": 0} {
+		got, err := st.Subscriptions.AlertPrice(ctx, sub.ID, date)
+		if err != nil {
+			t.Fatalf("alert price on %s: %v", date, err)
+		}
+		if got != want {
+			t.Fatalf("alert price on %s: got %v, want %v", date, got, want)
+		}
+	}
+
+	prices, err := st.Subscriptions.AlertPrices(ctx)
+	if err != nil {
+		t.Fatalf("alert prices: %v", err)
+	}
+	want := map[store.AlertKey]float64{
+		{SubscriptionID: sub.ID, TravelDate: "01-06-2030"}: 487.5,
+		{SubscriptionID: sub.ID, TravelDate: "02-06-2030"}: 400,
+	}
+	if len(prices) != len(want) {
+		t.Fatalf("alert prices: got %v, want %v", prices, want)
+	}
+	for key, price := range want {
+		if prices[key] != price {
+			t.Fatalf("alert prices: got %v, want %v", prices, want)
+		}
+	}
+}
+
+func testSetNearby(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	sub := create(t, st, 1, 10, 20, "01-06-2030")
+	if sub.Nearby {
+		t.Fatalf("create: new subscription watches nearby stations")
+	}
+
+	for _, want := range []bool{true, false} {
+		if err := st.Subscriptions.SetNearby(ctx, sub.ID, want); err != nil {
+			t.Fatalf("set nearby %v: %v", want, err)
+		}
+		got, err := st.Subscriptions.Get(ctx, sub.ID)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if got.Nearby != want {
+			t.Fatalf("get: got nearby %v, want %v", got.Nearby, want)
+		}
+	}
+}
+
+func testDateRange(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	sub := store.Subscription{ChatID: 1, DepartureStationID: 10, ArrivalStationID: 20,
+		TravelDate: "01-06-2030", TravelDateEnd: "14-06-2030", KeepWatching: true,
+		Weekdays: []time.Weekday{time.Monday, time.Friday}}
+	if err := st.Subscriptions.Create(ctx, &sub); err != nil {
+		t.Fatalf("create range subscription: %v", err)
+	}
+
+	got, err := st.Subscriptions.Get(ctx, sub.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !got.IsRange() || got.TravelDateEnd != "14-06-2030" || !got.KeepWatching || len(got.Weekdays) != 2 ||
+		got.Weekdays[0] != time.Monday || got.Weekdays[1] != time.Friday {
+		t.Fatalf("get: unexpected range subscription %+v", got)
+	}
+
+	// 01-06-2030 is a Saturday
+	today := time.Date(2030, 6, 4, 8, 0, 0, 0, time.UTC)
+	expectDates(t, "dates", got.Dates(today), "07-06-2030", "10-06-2030", "14-06-2030")
+
+	if err := st.Subscriptions.SetSatisfiedDates(ctx, sub.ID, []string{"10-06-2030", "07-06-2030"}); err != nil {
+		t.Fatalf("set satisfied dates: %v", err)
+	}
+	got, err = st.Subscriptions.Get(ctx, sub.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	expectDates(t, "dates after satisfying", got.Dates(today), "14-06-2030")
+
+	// The range is only expired once its last day has passed
+	count, err := st.Subscriptions.DeactivateExpired(ctx, time.Date(2030, 6, 14, 12, 0, 0, 0, time.UTC))
+	if err != nil || count != 0 {
+		t.Fatalf("deactivate expired on last day: got %d, %v, want 0", count, err)
+	}
+	count, err = st.Subscriptions.DeactivateExpired(ctx, time.Date(2030, 6, 15, 12, 0, 0, 0, time.UTC))
+	if err != nil || count != 1 {
+		t.Fatalf("deactivate expired after last day: got %d, %v, want 1", count, err)
+	}
+}
+
+func testRecurring(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	// 03-06-2030 is a Monday
+	sub := store.Subscription{ChatID: 1, DepartureStationID: 10, ArrivalStationID: 20, TravelDate: "03-06-2030",
+		Recurring: true, Weeks: 3, KeepWatching: true, Weekdays: []time.Weekday{time.Monday, time.Friday},
+		EarliestDeparture: "07:00", LatestDeparture: "09:00"}
+	if err := st.Subscriptions.Create(ctx, &sub); err != nil {
+		t.Fatalf("create recurring subscription: %v", err)
+	}
+
+	got, err := st.Subscriptions.Get(ctx, sub.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !got.Recurring || got.Weeks != 3 || !got.IsRange() || got.LastDate() != "" {
+		t.Fatalf("get: unexpected recurring subscription %+v", got)
+	}
+
+	// Three weeks from Wednesday 12-06-2030
+	today := time.Date(2030, 6, 12, 8, 0, 0, 0, time.UTC)
+	expectDates(t, "dates", got.Dates(today), "14-06-2030", "17-06-2030", "21-06-2030", "24-06-2030", "28-06-2030", "01-07-2030")
+
+	if week := store.WeekOf(time.Date(2030, 6, 23, 0, 0, 0, 0, time.UTC)); week != "17-06-2030" {
+		t.Fatalf("week of Sunday 23-06-2030: got %s, want 17-06-2030", week)
+	}
+	if err := st.Subscriptions.SetPausedWeeks(ctx, sub.ID, []string{"17-06-2030"}); err != nil {
+		t.Fatalf("set paused weeks: %v", err)
+	}
+	if got, err = st.Subscriptions.Get(ctx, sub.ID); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	expectDates(t, "dates with a paused week", got.Dates(today), "14-06-2030", "24-06-2030", "28-06-2030", "01-07-2030")
+
+	count, err := st.Subscriptions.DeactivateExpired(ctx, time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil || count != 0 {
+		t.Fatalf("deactivate expired: got %d, %v, want recurring subscription kept", count, err)
+	}
+}
+
+func expectDates(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %v, want %v", what, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("%s: got %v, want %v", what, got, want)
+		}
+	}
+}
+
+func testRoundTrip(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	create(t, st, 1, 10, 20, "01-06-2030")
+	outbound := store.Subscription{ChatID: 1, DepartureStationID: 10, ArrivalStationID: 20, TravelDate: "05-06-2030",
+		BothLegs: true, EarliestDeparture: "07:00"}
+	inbound := store.Subscription{ChatID: 1, DepartureStationID: 20, ArrivalStationID: 10, TravelDate: "09-06-2030",
+		BothLegs: true}
+	if err := st.Subscriptions.CreateRoundTrip(ctx, &outbound, &inbound); err != nil {
+		t.Fatalf("create round trip: %v", err)
+	}
+	if outbound.ID == 0 || inbound.ID == 0 || outbound.ID == inbound.ID {
+		t.Fatalf("create round trip: got IDs %d and %d", outbound.ID, inbound.ID)
+	}
+
+	for _, want := range []store.Subscription{outbound, inbound} {
+		got, err := st.Subscriptions.Get(ctx, want.ID)
+		if err != nil {
+			t.Fatalf("get leg %d: %v", want.ID, err)
+		}
+		if got.RoundTripID != outbound.ID || got.ReturnLeg != (want.ID == inbound.ID) || !got.BothLegs || !got.IsRoundTrip() {
+			t.Fatalf("get leg %d: unexpected round trip fields %+v", want.ID, got)
+		}
+		if got.DepartureStationID != want.DepartureStationID || got.TravelDate != want.TravelDate {
+			t.Fatalf("get leg %d: got %+v, want %+v", want.ID, got, want)
+		}
+	}
+}
+
+func testDeactivate(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	sub := create(t, st, 1, 10, 20, "01-06-2030")
+	if err := st.Subscriptions.Deactivate(ctx, sub.ID); err != nil {
+		t.Fatalf("deactivate: %v", err)
+	}
+	if _, err := st.Subscriptions.Get(ctx, sub.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("get deactivated: got %v, want ErrNotFound", err)
+	}
+	if err := st.Subscriptions.Deactivate(ctx, sub.ID); err != nil {
+		t.Fatalf("deactivate twice: %v", err)
+	}
+}
+
+func testCancel(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	sub := create(t, st, 1, 10, 20, "01-06-2030")
+
+	if err := st.Subscriptions.Cancel(ctx, 2, sub.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("cancel by other chat: got %v, want ErrNotFound", err)
+	}
+	if err := st.Subscriptions.Cancel(ctx, 1, sub.ID); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if err := st.Subscriptions.Cancel(ctx, 1, sub.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("cancel twice: got %v, want ErrNotFound", err)
+	}
+}
+
+func testDeactivateExpired(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	past := create(t, st, 1, 10, 20, "31-05-2030")
+	today := create(t, st, 1, 10, 20, "01-06-2030")
+	future := create(t, st, 1, 10, 20, "15-01-2031")
+
+	count, err := st.Subscriptions.DeactivateExpired(ctx, time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("deactivate expired: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("deactivate expired: got %d, want 1", count)
+	}
+
+	subs, err := st.Subscriptions.ListActive(ctx)
+	if err != nil {
+		t.Fatalf("list active: %v", err)
+	}
+	expectIDs(t, "after cleanup", subs, today.ID, future.ID)
+	if _, err := st.Subscriptions.Get(ctx, past.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("get expired: got %v, want ErrNotFound", err)
+	}
+}
+
+func testUsers(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	ok, err := st.Users.Exists(ctx, 1)
+	if err != nil || ok {
+		t.Fatalf("exists before create: got %v, %v", ok, err)
+	}
+
+	if err := st.Users.Create(ctx, store.User{ChatID: 1, UserName: "ayse", FirstName: "Ayşe"}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := st.Users.Create(ctx, store.User{ChatID: 2}); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	ok, err = st.Users.Exists(ctx, 1)
+	if err != nil || !ok {
+		t.Fatalf("exists after create: got %v, %v", ok, err)
+	}
+	count, err := st.Users.Count(ctx)
+	if err != nil || count != 2 {
+		t.Fatalf("count: got %d, %v, want 2", count, err)
+	}
+}
+
+func testConversations(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	at := time.Date(2030, 6, 1, 9, 30, 0, 0, time.UTC)
+	if err := st.Conversations.Save(ctx, store.Conversation{ChatID: 2, Data: `{"State":1}`, UpdatedAt: at}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := st.Conversations.Save(ctx, store.Conversation{ChatID: 1, Data: `{"State":1}`, UpdatedAt: at}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	// Saving again replaces the conversation
+	if err := st.Conversations.Save(ctx, store.Conversation{ChatID: 1, Data: `{"State":2}`, UpdatedAt: at.Add(time.Minute)}); err != nil {
+		t.Fatalf("save again: %v", err)
+	}
+
+	conversations, err := st.Conversations.List(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(conversations) != 2 {
+		t.Fatalf("list: got %d conversations, want 2", len(conversations))
+	}
+	got := conversations[0]
+	if got.ChatID != 1 || got.Data != `{"State":2}` || !got.UpdatedAt.Equal(at.Add(time.Minute)) {
+		t.Fatalf("list: got %+v", got)
+	}
+
+	if err := st.Conversations.Delete(ctx, 1); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := st.Conversations.Delete(ctx, 3); err != nil {
+		t.Fatalf("delete missing: %v", err)
+	}
+	conversations, err = st.Conversations.List(ctx)
+	if err != nil || len(conversations) != 1 || conversations[0].ChatID != 2 {
+		t.Fatalf("list after delete: got %+v, %v", conversations, err)
+	}
+}
+
+func fare(train, cabin string, price float64) store.PricePoint {
+	return store.PricePoint{
+		TrainNumber:    train,
+		DepartureTime:  time.Date(2030, 6, 1, 5, 40, 0, 0, time.UTC),
+		CabinClass:     cabin,
+		CabinClassName: "EKONOMİ",
+		Price:          price,
+		Currency:       "TRY",
+	}
+}
+
+func priceCheck(date string, at time.Time, fares ...store.PricePoint) store.PriceCheck {
+	return store.PriceCheck{DepartureStationID: 10, ArrivalStationID: 20, TravelDate: date, ObservedAt: at, Fares: fares}
+}
+
+func testPriceHistory(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	start := time.Date(2030, 5, 20, 9, 0, 0, 0, time.UTC)
+	hour := func(n int) time.Time { return start.Add(time.Duration(n) * time.Hour) }
+
+	checks := []store.PriceCheck{
+		priceCheck("01-06-2030", hour(0), fare("81003", "Y1", 540), fare("81005", "Y1", 600)),
+		// Unchanged fares are not stored again
+		priceCheck("01-06-2030", hour(1), fare("81003", "Y1", 540), fare("81005", "Y1", 600)),
+		// 81005 sells out
+		priceCheck("01-06-2030", hour(2), fare("81003", "Y1", 450)),
+		priceCheck("01-06-2030", hour(3), fare("81003", "Y1", 450)),
+		priceCheck("02-06-2030", hour(3), fare("81003", "Y1", 700)),
+	}
+	for i, check := range checks {
+		if err := st.Prices.Record(ctx, check); err != nil {
+			t.Fatalf("record check %d: %v", i, err)
+		}
+	}
+
+	history, err := st.Prices.History(ctx, 10, 20, "01-06-2030")
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	want := []struct {
+		train string
+		price float64
+		at    time.Time
+	}{
+		{"81003", 540, hour(0)},
+		{"81005", 600, hour(0)},
+		{"81003", 450, hour(2)},
+		{"81005", 0, hour(2)},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("history: got %d points %+v, want %d", len(history), history, len(want))
+	}
+	for i, w := range want {
+		got := history[i]
+		if got.TrainNumber != w.train || got.Price != w.price || !got.ObservedAt.Equal(w.at) {
+			t.Fatalf("history[%d]: got %s %v at %v, want %s %v at %v", i, got.TrainNumber, got.Price, got.ObservedAt, w.train, w.price, w.at)
+		}
+	}
+	first := history[0]
+	if first.CabinClass != "Y1" || first.CabinClassName != "EKONOMİ" || first.Currency != "TRY" || first.TravelDate != "01-06-2030" ||
+		!first.DepartureTime.Equal(time.Date(2030, 6, 1, 5, 40, 0, 0, time.UTC)) {
+		t.Fatalf("history[0]: unexpected point %+v", first)
+	}
+
+	other, err := st.Prices.History(ctx, 20, 10, "01-06-2030")
+	if err != nil || len(other) != 0 {
+		t.Fatalf("history of reverse route: got %d points, %v", len(other), err)
+	}
+}
+
+func testPriceHistoryDeleteExpired(t *testing.T, st *store.Store) {
+	ctx := context.Background()
+	now := time.Date(2030, 5, 20, 9, 0, 0, 0, time.UTC)
+	checks := []store.PriceCheck{
+		priceCheck("31-05-2030", now, fare("81003", "Y1", 540), fare("81005", "Y1", 600)),
+		priceCheck("01-06-2030", now, fare("81003", "Y1", 450)),
+	}
+	for i, check := range checks {
+		if err := st.Prices.Record(ctx, check); err != nil {
+			t.Fatalf("record check %d: %v", i, err)
+		}
+	}
+
+	count, err := st.Prices.DeleteExpired(ctx, time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("delete expired: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("delete expired: got %d, want 2", count)
+	}
+
+	if history, _ := st.Prices.History(ctx, 10, 20, "31-05-2030"); len(history) != 0 {
+		t.Fatalf("history of expired date: got %d points", len(history))
+	}
+	if history, _ := st.Prices.History(ctx, 10, 20, "01-06-2030"); len(history) != 1 {
+		t.Fatalf("history of kept date: got %d points, want 1", len(history))
+	}
+}
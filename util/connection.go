@@ -0,0 +1,105 @@
+package util
+
+import (
+	"tcddbot/model"
+	"time"
+)
+
+// Connection is a journey changing trains once at an intermediate station.
+type Connection struct {
+	First    SeatAvailability
+	Second   SeatAvailability
+	Transfer time.Duration // Wait between the arrival of First and the departure of Second
+}
+
+// IsYHT reports whether both trains of the connection are high speed.
+func (c Connection) IsYHT() bool {
+	return c.First.IsYHT && c.Second.IsYHT
+}
+
+// Fare returns the cheapest fare of the whole journey per passenger. ok is
+// false when either train has no known price.
+func (c Connection) Fare() (fare Fare, ok bool) {
+	first, ok := cheapest(c.First.Fares)
+	if !ok {
+		return Fare{}, false
+	}
+	second, ok := cheapest(c.Second.Fares)
+	if !ok {
+		return Fare{}, false
+	}
+	return Fare{Amount: first.Amount + second.Amount, Currency: first.Currency}, true
+}
+
+// ArrivalTime returns when a train reaches the end of its last segment.
+func ArrivalTime(train model.Trains) time.Time {
+	if len(train.TrainSegments) == 0 {
+		return time.Time{}
+	}
+	arrival, _ := time.Parse("2006-01-02T15:04:05", train.TrainSegments[len(train.TrainSegments)-1].ArrivalTime)
+	return arrival
+}
+
+// LatestArrival returns when the last train with seats in trainLegs reaches
+// the end of its journey, zero if none has seats.
+func LatestArrival(trainLegs []model.TrainLegs) time.Time {
+	var latest time.Time
+	for _, seat := range FindAvailableSeats(trainLegs, SeatFilter{}) {
+		if arrival := ArrivalTime(seat.Train); arrival.After(latest) {
+			latest = arrival
+		}
+	}
+	return latest
+}
+
+// FindConnections pairs every train of the first leg with the earliest train
+// of the second leg leaving at least minTransfer after it arrives. The time
+// window of filter applies to the first departure only, the price ceiling to
+// the fare of the whole journey. second may hold the trains of several days,
+// a train arriving late then connects to the first one of the next morning.
+func FindConnections(first, second []model.TrainLegs, filter SeatFilter, minTransfer time.Duration) []Connection {
+	legFilter := filter
+	legFilter.MaxPrice = 0
+	firstSeats := FindAvailableSeats(first, legFilter)
+	legFilter.EarliestDeparture, legFilter.LatestDeparture = "", ""
+	secondSeats := FindAvailableSeats(second, legFilter)
+
+	var connections []Connection
+	for _, a := range firstSeats {
+		arrival := ArrivalTime(a.Train)
+		if arrival.IsZero() {
+			continue
+		}
+
+		var next *SeatAvailability
+		for i, b := range secondSeats {
+			if b.DepartureTime.Before(arrival.Add(minTransfer)) {
+				continue
+			}
+			if next == nil || b.DepartureTime.Before(next.DepartureTime) {
+				next = &secondSeats[i]
+			}
+		}
+		if next == nil {
+			continue
+		}
+
+		connection := Connection{First: a, Second: *next, Transfer: next.DepartureTime.Sub(arrival)}
+		if filter.MaxPrice > 0 {
+			if fare, ok := connection.Fare(); !ok || fare.Amount > filter.MaxPrice {
+				continue
+			}
+		}
+		connections = append(connections, connection)
+	}
+	return connections
+}
+
+func cheapest(fares map[string]Fare) (fare Fare, ok bool) {
+	for _, f := range fares {
+		if !ok || f.Amount < fare.Amount {
+			fare, ok = f, true
+		}
+	}
+	return fare, ok
+}
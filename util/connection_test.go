@@ -0,0 +1,123 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"tcddbot/model"
+)
+
+// legs returns a response with one YHT per departure/arrival pair, each with
+// seats in economy. Times are in the API's "2006-01-02T15:04:05" layout.
+func legs(times ...[2]string) []model.TrainLegs {
+	var trains []model.Trains
+	for i, t := range times {
+		trains = append(trains, model.Trains{
+			Name: "YHT " + t[0],
+			Type: "YHT",
+			TrainSegments: []model.TrainSegments{
+				{DepartureTime: t[0], ArrivalTime: t[1]},
+			},
+			CabinClassAvailabilities: []model.CabinClassAvailabilities{
+				{CabinClass: model.CabinClass{ID: i, Code: "Y1", Name: "EKONOMİ"}, AvailabilityCount: 4},
+			},
+		})
+	}
+	return []model.TrainLegs{{TrainAvailabilities: []model.TrainAvailabilities{{Trains: trains}}}}
+}
+
+func departures(connections []Connection) []string {
+	var out []string
+	for _, connection := range connections {
+		out = append(out, connection.First.DepartureTime.Format("15:04")+"-"+connection.Second.DepartureTime.Format("15:04"))
+	}
+	return out
+}
+
+func TestFindConnectionsMinTransfer(t *testing.T) {
+	first := legs([2]string{"2030-06-01T07:00:00", "2030-06-01T10:00:00"})
+	tests := []struct {
+		name      string
+		departure string
+		want      int
+	}{
+		{"exactly minTransfer", "2030-06-01T10:20:00", 1},
+		{"a minute short", "2030-06-01T10:19:00", 0},
+		{"before the arrival", "2030-06-01T09:30:00", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			second := legs([2]string{tt.departure, "2030-06-01T12:00:00"})
+			connections := FindConnections(first, second, SeatFilter{}, 20*time.Minute)
+			if len(connections) != tt.want {
+				t.Fatalf("got %d connections, want %d", len(connections), tt.want)
+			}
+			if tt.want == 1 && connections[0].Transfer != 20*time.Minute {
+				t.Fatalf("transfer: got %v, want 20m", connections[0].Transfer)
+			}
+		})
+	}
+}
+
+func TestFindConnectionsEarliestSecondTrain(t *testing.T) {
+	first := legs(
+		[2]string{"2030-06-01T07:00:00", "2030-06-01T10:00:00"},
+		[2]string{"2030-06-01T12:00:00", "2030-06-01T15:00:00"},
+	)
+	second := legs(
+		[2]string{"2030-06-01T17:00:00", "2030-06-01T19:00:00"},
+		[2]string{"2030-06-01T11:00:00", "2030-06-01T13:00:00"},
+		[2]string{"2030-06-01T15:10:00", "2030-06-01T17:00:00"},
+	)
+
+	got := departures(FindConnections(first, second, SeatFilter{}, 20*time.Minute))
+	want := []string{"07:00-11:00", "12:00-17:00"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestFindConnectionsAfterMidnight(t *testing.T) {
+	// Arrives at 23:30 Istanbul time, the last train of the day has left
+	first := legs([2]string{"2030-06-01T17:00:00", "2030-06-01T20:30:00"})
+	second := legs(
+		[2]string{"2030-06-01T19:00:00", "2030-06-01T21:00:00"},
+		[2]string{"2030-06-02T03:30:00", "2030-06-02T05:30:00"},
+	)
+
+	connections := FindConnections(first, second, SeatFilter{}, 20*time.Minute)
+	if len(connections) != 1 {
+		t.Fatalf("got %d connections, want 1", len(connections))
+	}
+	if got := connections[0].Transfer; got != 7*time.Hour {
+		t.Fatalf("transfer: got %v, want 7h", got)
+	}
+}
+
+func TestFindConnectionsDepartureWindowAppliesToFirstLeg(t *testing.T) {
+	// 07:00 and 10:00 UTC are 10:00 and 13:00 in Istanbul
+	first := legs(
+		[2]string{"2030-06-01T07:00:00", "2030-06-01T08:00:00"},
+		[2]string{"2030-06-01T10:00:00", "2030-06-01T11:00:00"},
+	)
+	second := legs([2]string{"2030-06-01T12:00:00", "2030-06-01T13:00:00"})
+
+	filter := SeatFilter{EarliestDeparture: "09:00", LatestDeparture: "11:00"}
+	got := departures(FindConnections(first, second, filter, 20*time.Minute))
+	if len(got) != 1 || got[0] != "07:00-12:00" {
+		t.Fatalf("got %v, want [07:00-12:00]", got)
+	}
+}
+
+func TestLatestArrival(t *testing.T) {
+	trainLegs := legs(
+		[2]string{"2030-06-01T07:00:00", "2030-06-01T10:00:00"},
+		[2]string{"2030-06-01T17:00:00", "2030-06-01T20:30:00"},
+	)
+	if got := LatestArrival(trainLegs).Format("15:04"); got != "20:30" {
+		t.Fatalf("got %s, want 20:30", got)
+	}
+	if got := LatestArrival(nil); !got.IsZero() {
+		t.Fatalf("no trains: got %v, want zero", got)
+	}
+}
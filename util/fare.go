@@ -0,0 +1,135 @@
+package util
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"tcddbot/model"
+	"time"
+)
+
+// Fare is the price of one seat.
+type Fare struct {
+	Amount   float64
+	Currency string
+}
+
+// String formats the fare the Turkish way, e.g. "1187,50 TRY".
+func (f Fare) String() string {
+	return FormatAmount(f.Amount) + " " + f.Currency
+}
+
+// FormatAmount formats a price with a decimal comma, dropping zero kuruş.
+func FormatAmount(amount float64) string {
+	if amount == math.Trunc(amount) {
+		return strconv.FormatFloat(amount, 'f', 0, 64)
+	}
+	return strings.Replace(strconv.FormatFloat(amount, 'f', 2, 64), ".", ",", 1)
+}
+
+// CabinFare returns the cheapest fare of a cabin class that still has seats
+// for the whole group. The fare info of the train is preferred, the pricing
+// lists of its cars are the fallback. ok is false when neither prices the
+// cabin class.
+func CabinFare(train model.Trains, cabinClass model.CabinClass, seats int) (fare Fare, ok bool) {
+	seats = max(seats, 1)
+	currency := train.MinPrice.PriceCurrency
+	if currency == "" {
+		currency = "TRY"
+	}
+
+	consider := func(amount float64, priceCurrency string) {
+		if amount <= 0 || (ok && amount >= fare.Amount) {
+			return
+		}
+		if priceCurrency == "" {
+			priceCurrency = currency
+		}
+		fare, ok = Fare{Amount: amount, Currency: priceCurrency}, true
+	}
+
+	var cabinMinPrice float64
+	for _, fareInfo := range train.AvailableFareInfo {
+		for _, cabin := range fareInfo.CabinClasses {
+			if !sameCabinClass(cabin.CabinClass, cabinClass) {
+				continue
+			}
+			if cabin.MinPrice > 0 && (cabinMinPrice == 0 || cabin.MinPrice < cabinMinPrice) {
+				cabinMinPrice = cabin.MinPrice
+			}
+			for _, bookingClass := range cabin.BookingClassAvailabilities {
+				if bookingClass.Availability >= seats {
+					consider(bookingClass.Price, "")
+				}
+			}
+		}
+	}
+	if ok {
+		return fare, true
+	}
+
+	for _, car := range train.Cars {
+		for _, availability := range car.Availabilities {
+			for _, pricing := range availability.PricingList {
+				if pricing.CabinClassID != cabinClass.ID && !sameCabinClass(availability.CabinClass, cabinClass) {
+					continue
+				}
+				if pricing.Availability >= seats {
+					consider(pricing.FareBasis.Price.PriceAmount, pricing.FareBasis.Price.PriceCurrency)
+				}
+			}
+		}
+	}
+	if ok {
+		return fare, true
+	}
+
+	// Booking classes may lag behind the cabin count, the cabin minimum is
+	// still the cheapest fare on sale
+	if cabinMinPrice > 0 {
+		return Fare{Amount: cabinMinPrice, Currency: currency}, true
+	}
+	return Fare{}, false
+}
+
+// Quote is the cheapest fare on sale in one cabin class of a train.
+type Quote struct {
+	Train         model.Trains
+	DepartureTime time.Time
+	CabinClass    model.CabinClass
+	Fare          Fare
+}
+
+// Quotes returns the cheapest single seat fare of every train and cabin class
+// of a response. Sold out and unpriced cabin classes are left out.
+func Quotes(trainLegs []model.TrainLegs) []Quote {
+	var quotes []Quote
+	for _, trainLeg := range trainLegs {
+		for _, trainAvailability := range trainLeg.TrainAvailabilities {
+			for _, train := range trainAvailability.Trains {
+				if len(train.TrainSegments) == 0 {
+					continue
+				}
+				departureTime, _ := time.Parse("2006-01-02T15:04:05", train.TrainSegments[0].DepartureTime)
+
+				for _, cabinClassAvailability := range train.CabinClassAvailabilities {
+					cabinClass := cabinClassAvailability.CabinClass
+					if cabinClass.Name == WheelchairCabinClass || cabinClassAvailability.AvailabilityCount <= 0 {
+						continue
+					}
+					if fare, ok := CabinFare(train, cabinClass, 1); ok {
+						quotes = append(quotes, Quote{Train: train, DepartureTime: departureTime, CabinClass: cabinClass, Fare: fare})
+					}
+				}
+			}
+		}
+	}
+	return quotes
+}
+
+func sameCabinClass(a, b model.CabinClass) bool {
+	if a.Code != "" && b.Code != "" {
+		return a.Code == b.Code
+	}
+	return a.ID != 0 && a.ID == b.ID
+}
@@ -0,0 +1,87 @@
+package util
+
+import (
+	"testing"
+
+	"tcddbot/model"
+)
+
+// fareInfo prices a cabin class of a train with booking classes of the given
+// price and availability, cheapest not necessarily first.
+func fareInfo(cabinClass model.CabinClass, minPrice float64, bookings ...model.BookingClassAvailabilities) model.AvailableFareInfo {
+	return model.AvailableFareInfo{CabinClasses: []model.CabinClasses{
+		{CabinClass: cabinClass, MinPrice: minPrice, BookingClassAvailabilities: bookings},
+	}}
+}
+
+func booking(price float64, availability int) model.BookingClassAvailabilities {
+	return model.BookingClassAvailabilities{Price: price, Availability: availability}
+}
+
+func TestCabinFare(t *testing.T) {
+	carPricing := []model.Cars{{Availabilities: []model.Availabilities{{
+		CabinClass: economy,
+		PricingList: []model.PricingList{
+			{CabinClassID: economy.ID, Availability: 1, FareBasis: model.FareBasis{Price: model.Price{PriceAmount: 600}}},
+			{CabinClassID: economy.ID, Availability: 5, FareBasis: model.FareBasis{Price: model.Price{PriceAmount: 749.9}}},
+		},
+	}}}}
+
+	tests := []struct {
+		name  string
+		train model.Trains
+		seats int
+		want  string // Empty when the class is not priced
+	}{
+		{"cheapest booking class", model.Trains{AvailableFareInfo: []model.AvailableFareInfo{
+			fareInfo(economy, 0, booking(1250, 3), booking(749.9, 2), booking(0, 9)),
+		}}, 1, "749,90 TRY"},
+		{"booking class for the whole group", model.Trains{AvailableFareInfo: []model.AvailableFareInfo{
+			fareInfo(economy, 0, booking(1250, 3), booking(749.9, 2)),
+		}}, 3, "1250 TRY"},
+		{"fare info of another class", model.Trains{AvailableFareInfo: []model.AvailableFareInfo{
+			fareInfo(business, 0, booking(500, 3)),
+		}}, 1, ""},
+		{"car pricing", model.Trains{Cars: carPricing}, 1, "600 TRY"},
+		{"car pricing for the whole group", model.Trains{Cars: carPricing}, 2, "749,90 TRY"},
+		{"cabin minimum", model.Trains{AvailableFareInfo: []model.AvailableFareInfo{
+			fareInfo(economy, 500, booking(450, 0)),
+		}}, 1, "500 TRY"},
+		{"currency of the train", model.Trains{MinPrice: model.MinPrice{PriceCurrency: "EUR"}, AvailableFareInfo: []model.AvailableFareInfo{
+			fareInfo(economy, 0, booking(12.5, 1)),
+		}}, 1, "12,50 EUR"},
+		{"not priced", model.Trains{}, 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fare, ok := CabinFare(tt.train, economy, tt.seats)
+			if !ok {
+				if tt.want != "" {
+					t.Fatalf("got no fare, want %s", tt.want)
+				}
+				return
+			}
+			if got := fare.String(); got != tt.want {
+				t.Fatalf("got %s, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   string
+	}{
+		{500, "500"},
+		{1250, "1250"},
+		{749.9, "749,90"},
+		{1187.5, "1187,50"},
+		{0.05, "0,05"},
+	}
+	for _, tt := range tests {
+		if got := FormatAmount(tt.amount); got != tt.want {
+			t.Errorf("FormatAmount(%v): got %q, want %q", tt.amount, got, tt.want)
+		}
+	}
+}
@@ -1,49 +1,188 @@
 package util
 
 import (
-    "tcddbot/model"
-    "time"
+	"tcddbot/model"
+	"time"
 )
 
+// WheelchairCabinClass is reserved for passengers with reduced mobility and
+// never counts as an available seat.
+const WheelchairCabinClass = "TEKERLEKLİ SANDALYE"
+
 type SeatAvailability struct {
-    Train            model.Trains
-    DepartureTime    time.Time
-    AvailableSeats   map[string]int // Changed to map cabin class names to seat counts
-    IsYHT           bool
-}
-
-func FindAvailableSeats(trainLegs []model.TrainLegs) []SeatAvailability {
-    var results []SeatAvailability
-    
-    if len(trainLegs) == 0 {
-        return results
-    }
-
-    for _, trainLeg := range trainLegs {
-        for _, trainAvailability := range trainLeg.TrainAvailabilities {
-            for _, train := range trainAvailability.Trains {
-                seatsByClass := make(map[string]int)
-                for _, cabinClassAvailability := range train.CabinClassAvailabilities {
-                    if cabinClassAvailability.CabinClass.Name == "TEKERLEKLİ SANDALYE" {
-                        continue
-                    }
-                    if cabinClassAvailability.AvailabilityCount > 0 {
-                        seatsByClass[cabinClassAvailability.CabinClass.Name] = cabinClassAvailability.AvailabilityCount
-                    }
-                }
-
-                if len(seatsByClass) > 0 {
-                    departureTime, _ := time.Parse("2006-01-02T15:04:05", train.TrainSegments[0].DepartureTime)
-                    results = append(results, SeatAvailability{
-                        Train:          train,
-                        DepartureTime:  departureTime,
-                        AvailableSeats: seatsByClass,
-                        IsYHT:         train.Type == "YHT",
-                    })
-                }
-            }
-        }
-    }
-
-    return results
+	Train          model.Trains
+	DepartureTime  time.Time
+	AvailableSeats map[string]int  // Changed to map cabin class names to seat counts
+	Fares          map[string]Fare // Cheapest eligible fare by cabin class name, if priced
+	IsYHT          bool
+}
+
+// Istanbul is the time zone of departure time windows. Turkey has stayed on
+// UTC+3 all year since 2016, which is the fallback without tzdata.
+var Istanbul = loadIstanbul()
+
+func loadIstanbul() *time.Location {
+	loc, err := time.LoadLocation("Europe/Istanbul")
+	if err != nil {
+		return time.FixedZone("Europe/Istanbul", 3*60*60)
+	}
+	return loc
+}
+
+// SeatFilter narrows down which seats count as a hit for a subscription.
+type SeatFilter struct {
+	CabinClasses []string // Accepted cabin class codes, empty accepts all
+
+	// Departure time window as HH:MM in Istanbul time, empty is open ended
+	EarliestDeparture string
+	LatestDeparture   string
+
+	// Seats the group needs in a single train and cabin class, 0 means 1
+	Seats int
+
+	// Highest acceptable fare per passenger, 0 accepts any. Cabin classes
+	// without a known price never pass a ceiling.
+	MaxPrice float64
+}
+
+// Accepts reports whether the filter lets a cabin class of a train departing
+// at departureTime through, regardless of seats and price.
+func (f SeatFilter) Accepts(departureTime time.Time, cabinClass model.CabinClass) bool {
+	return f.acceptsDeparture(departureTime) && f.acceptsCabin(cabinClass)
+}
+
+func (f SeatFilter) acceptsDeparture(departureTime time.Time) bool {
+	clock := departureTime.In(Istanbul).Format("15:04")
+	if f.EarliestDeparture != "" && clock < f.EarliestDeparture {
+		return false
+	}
+	if f.LatestDeparture != "" && clock > f.LatestDeparture {
+		return false
+	}
+	return true
+}
+
+func (f SeatFilter) acceptsCabin(cabinClass model.CabinClass) bool {
+	if len(f.CabinClasses) == 0 {
+		return true
+	}
+	for _, code := range f.CabinClasses {
+		if code == cabinClass.Code {
+			return true
+		}
+	}
+	return false
+}
+
+func FindAvailableSeats(trainLegs []model.TrainLegs, filter SeatFilter) []SeatAvailability {
+	var results []SeatAvailability
+
+	if len(trainLegs) == 0 {
+		return results
+	}
+
+	for _, trainLeg := range trainLegs {
+		for _, trainAvailability := range trainLeg.TrainAvailabilities {
+			// A seat on one train of a TCDD connection is not a journey,
+			// connections are found with FindConnections instead
+			if trainAvailability.Connection {
+				continue
+			}
+			for _, train := range trainAvailability.Trains {
+				if len(train.TrainSegments) == 0 {
+					continue
+				}
+				departureTime, _ := time.Parse("2006-01-02T15:04:05", train.TrainSegments[0].DepartureTime)
+				if !filter.acceptsDeparture(departureTime) {
+					continue
+				}
+
+				seatsByClass := make(map[string]int)
+				fares := make(map[string]Fare)
+				for _, cabinClassAvailability := range train.CabinClassAvailabilities {
+					if cabinClassAvailability.CabinClass.Name == WheelchairCabinClass {
+						continue
+					}
+					if !filter.acceptsCabin(cabinClassAvailability.CabinClass) {
+						continue
+					}
+					if cabinClassAvailability.AvailabilityCount < max(filter.Seats, 1) {
+						continue
+					}
+
+					fare, priced := CabinFare(train, cabinClassAvailability.CabinClass, filter.Seats)
+					if filter.MaxPrice > 0 && (!priced || fare.Amount > filter.MaxPrice) {
+						continue
+					}
+					if priced {
+						fares[cabinClassAvailability.CabinClass.Name] = fare
+					}
+					seatsByClass[cabinClassAvailability.CabinClass.Name] = cabinClassAvailability.AvailabilityCount
+				}
+
+				if len(seatsByClass) > 0 {
+					results = append(results, SeatAvailability{
+						Train:          train,
+						DepartureTime:  departureTime,
+						AvailableSeats: seatsByClass,
+						Fares:          fares,
+						IsYHT:          train.Type == "YHT",
+					})
+				}
+			}
+		}
+	}
+
+	return results
+}
+
+// CabinClasses lists the cabin classes offered on the trains of a response,
+// sold out or not, in the order they first appear.
+func CabinClasses(trainLegs []model.TrainLegs) []model.CabinClass {
+	var classes []model.CabinClass
+	seen := make(map[string]bool)
+
+	add := func(cabinClass model.CabinClass) {
+		if cabinClass.Code == "" || cabinClass.Name == WheelchairCabinClass || seen[cabinClass.Code] {
+			return
+		}
+		seen[cabinClass.Code] = true
+		classes = append(classes, cabinClass)
+	}
+
+	for _, trainLeg := range trainLegs {
+		for _, trainAvailability := range trainLeg.TrainAvailabilities {
+			for _, train := range trainAvailability.Trains {
+				// Sold out classes are only listed in the fare info
+				for _, fareInfo := range train.AvailableFareInfo {
+					for _, cabinClass := range fareInfo.CabinClasses {
+						add(cabinClass.CabinClass)
+					}
+				}
+				for _, cabinClassAvailability := range train.CabinClassAvailabilities {
+					add(cabinClassAvailability.CabinClass)
+				}
+			}
+		}
+	}
+
+	return classes
+}
+
+// TotalSeats counts every seat on sale in a response, whatever the filter.
+// A change between two searches means the route has seats moving.
+func TotalSeats(trainLegs []model.TrainLegs) int {
+	total := 0
+	for _, trainLeg := range trainLegs {
+		for _, trainAvailability := range trainLeg.TrainAvailabilities {
+			for _, train := range trainAvailability.Trains {
+				for _, cabinClassAvailability := range train.CabinClassAvailabilities {
+					if cabinClassAvailability.CabinClass.Name != WheelchairCabinClass {
+						total += cabinClassAvailability.AvailabilityCount
+					}
+				}
+			}
+		}
+	}
+	return total
 }
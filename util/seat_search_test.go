@@ -0,0 +1,142 @@
+package util
+
+import (
+	"slices"
+	"sort"
+	"testing"
+
+	"tcddbot/model"
+)
+
+var (
+	economy    = model.CabinClass{ID: 1, Code: "Y1", Name: "EKONOMİ"}
+	business   = model.CabinClass{ID: 2, Code: "C", Name: "BUSİNESS"}
+	wheelchair = model.CabinClass{ID: 3, Code: "TS", Name: WheelchairCabinClass}
+)
+
+// cabinTrain returns a response with one train leaving at departure that has
+// the given seats by cabin class.
+func cabinTrain(departure string, seats map[model.CabinClass]int) []model.TrainLegs {
+	train := model.Trains{
+		Name:          "YHT " + departure,
+		Type:          "YHT",
+		TrainSegments: []model.TrainSegments{{DepartureTime: departure, ArrivalTime: departure}},
+	}
+	for _, cabinClass := range []model.CabinClass{economy, business, wheelchair} {
+		if count, ok := seats[cabinClass]; ok {
+			train.CabinClassAvailabilities = append(train.CabinClassAvailabilities,
+				model.CabinClassAvailabilities{CabinClass: cabinClass, AvailabilityCount: count})
+		}
+	}
+	return []model.TrainLegs{{TrainAvailabilities: []model.TrainAvailabilities{{Trains: []model.Trains{train}}}}}
+}
+
+// seatClasses returns the names of the cabin classes found, sorted.
+func seatClasses(seats []SeatAvailability) []string {
+	var names []string
+	for _, seat := range seats {
+		for name := range seat.AvailableSeats {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFindAvailableSeatsCabinClasses(t *testing.T) {
+	trainLegs := cabinTrain("2030-06-01T07:00:00", map[model.CabinClass]int{economy: 4, business: 2, wheelchair: 2})
+	tests := []struct {
+		name    string
+		classes []string
+		want    []string
+	}{
+		{"any class", nil, []string{"BUSİNESS", "EKONOMİ"}},
+		{"economy", []string{"Y1"}, []string{"EKONOMİ"}},
+		{"business", []string{"C"}, []string{"BUSİNESS"}},
+		{"both", []string{"Y1", "C"}, []string{"BUSİNESS", "EKONOMİ"}},
+		// Wheelchair seats are never a hit, even when asked for
+		{"wheelchair", []string{"TS"}, nil},
+		{"class not on the train", []string{"B"}, nil},
+		// Classes are matched by code, not by name
+		{"by name", []string{"EKONOMİ"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := seatClasses(FindAvailableSeats(trainLegs, SeatFilter{CabinClasses: tt.classes}))
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindAvailableSeatsSoldOutClass(t *testing.T) {
+	trainLegs := cabinTrain("2030-06-01T07:00:00", map[model.CabinClass]int{economy: 0, business: 3})
+	tests := []struct {
+		name    string
+		classes []string
+		seats   int
+		want    []string
+	}{
+		{"only the sold out class", []string{"Y1"}, 0, nil},
+		{"any class", nil, 0, []string{"BUSİNESS"}},
+		{"group fits", []string{"C"}, 3, []string{"BUSİNESS"}},
+		{"group too large", []string{"C"}, 4, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := seatClasses(FindAvailableSeats(trainLegs, SeatFilter{CabinClasses: tt.classes, Seats: tt.seats}))
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCabinClasses(t *testing.T) {
+	trainLegs := cabinTrain("2030-06-01T07:00:00", map[model.CabinClass]int{economy: 4, wheelchair: 1})
+	// A sold out class only shows in the fare info
+	train := &trainLegs[0].TrainAvailabilities[0].Trains[0]
+	train.AvailableFareInfo = []model.AvailableFareInfo{{CabinClasses: []model.CabinClasses{{CabinClass: business}, {CabinClass: economy}}}}
+
+	var got []string
+	for _, cabinClass := range CabinClasses(trainLegs) {
+		got = append(got, cabinClass.Code)
+	}
+	if want := []string{"C", "Y1"}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestFindAvailableSeatsDepartureWindow(t *testing.T) {
+	// Departures are given in UTC, windows are Istanbul time (UTC+3)
+	tests := []struct {
+		name             string
+		departure        string
+		earliest, latest string
+		want             bool
+	}{
+		{"open window", "2030-06-01T04:00:00", "", "", true},
+		{"inside", "2030-06-01T05:00:00", "07:00", "09:30", true},
+		{"at the start", "2030-06-01T04:00:00", "07:00", "09:30", true},
+		{"a minute early", "2030-06-01T03:59:00", "07:00", "09:30", false},
+		{"at the end", "2030-06-01T06:30:00", "07:00", "09:30", true},
+		{"a minute late", "2030-06-01T06:31:00", "07:00", "09:30", false},
+		{"only a start", "2030-06-01T20:00:00", "07:00", "", true},
+		{"only an end", "2030-06-01T07:00:00", "", "09:30", false},
+		// The evening preset ends at 23:59 and still takes the last train of the day
+		{"23:59", "2030-06-01T20:59:00", "18:00", "23:59", true},
+		{"23:59 and seconds", "2030-06-01T20:59:30", "18:00", "23:59", true},
+		{"midnight", "2030-06-01T21:00:00", "18:00", "23:59", false},
+		{"midnight with an open end", "2030-06-01T21:00:00", "00:00", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trainLegs := cabinTrain(tt.departure, map[model.CabinClass]int{economy: 4})
+			filter := SeatFilter{EarliestDeparture: tt.earliest, LatestDeparture: tt.latest}
+			if got := len(FindAvailableSeats(trainLegs, filter)) == 1; got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
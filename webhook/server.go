@@ -0,0 +1,212 @@
+// Package webhook receives Telegram updates over HTTP(S) instead of
+// long-polling getUpdates.
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"tcddbot/config"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// SecretTokenHeader carries the secret_token registered with setWebhook.
+const SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// maxUpdateSize bounds the request body; real updates are a few KB.
+const maxUpdateSize = 1 << 20
+
+// queueSize is how many updates wait for each worker before ServeHTTP blocks.
+const queueSize = 64
+
+// Server registers the webhook with Telegram and dispatches incoming updates
+// on WebhookWorkers workers. Every update of a chat goes to the same worker,
+// so a chat sees its updates handled one at a time and in order, as with
+// long polling.
+type Server struct {
+	bot      *tgbotapi.BotAPI
+	cfg      *config.Config
+	dispatch func(context.Context, tgbotapi.Update)
+
+	mu      sync.RWMutex
+	queues  []chan tgbotapi.Update
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func New(bot *tgbotapi.BotAPI, cfg *config.Config, dispatch func(context.Context, tgbotapi.Update)) *Server {
+	workers := cfg.WebhookWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	queues := make([]chan tgbotapi.Update, workers)
+	for i := range queues {
+		queues[i] = make(chan tgbotapi.Update, queueSize)
+	}
+	return &Server{
+		bot:      bot,
+		cfg:      cfg,
+		dispatch: dispatch,
+		queues:   queues,
+	}
+}
+
+// Path is the URL path the webhook is served on.
+func (s *Server) Path() string {
+	if s.cfg.WebhookPathSecret == "" {
+		return "/telegram/webhook"
+	}
+	return "/telegram/" + s.cfg.WebhookPathSecret
+}
+
+// Run registers the webhook, serves updates until ctx is done, then removes
+// the webhook and waits for in-flight updates to finish.
+func (s *Server) Run(ctx context.Context) error {
+	// Updates already accepted are finished even while shutting down
+	s.start(context.WithoutCancel(ctx))
+
+	mux := http.NewServeMux()
+	mux.Handle(s.Path(), s)
+	srv := &http.Server{
+		Addr:              s.cfg.WebhookListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		var err error
+		if s.cfg.WebhookCertFile != "" {
+			err = srv.ListenAndServeTLS(s.cfg.WebhookCertFile, s.cfg.WebhookKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		serveErr <- err
+	}()
+
+	if err := s.setWebhook(); err != nil {
+		srv.Close()
+		s.stop()
+		return fmt.Errorf("set webhook: %w", err)
+	}
+	log.Printf("Webhook listening on %s%s", s.cfg.WebhookListenAddr, s.Path())
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-serveErr:
+	}
+
+	if _, delErr := s.bot.Request(tgbotapi.DeleteWebhookConfig{}); delErr != nil {
+		log.Printf("Error deleting webhook: %v", delErr)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+	s.stop()
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// start runs a worker per queue, each handling its updates in order.
+func (s *Server) start(ctx context.Context) {
+	for _, queue := range s.queues {
+		s.wg.Add(1)
+		go func(queue chan tgbotapi.Update) {
+			defer s.wg.Done()
+			for update := range queue {
+				s.dispatch(ctx, update)
+			}
+		}(queue)
+	}
+}
+
+// stop turns new updates away and waits for the queued ones to finish.
+func (s *Server) stop() {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		for _, queue := range s.queues {
+			close(queue)
+		}
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// queue returns the queue of the chat the update belongs to
+func (s *Server) queue(update tgbotapi.Update) chan tgbotapi.Update {
+	var chatID int64
+	switch {
+	case update.Message != nil:
+		chatID = update.Message.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		chatID = update.CallbackQuery.Message.Chat.ID
+	case update.CallbackQuery != nil:
+		chatID = update.CallbackQuery.From.ID
+	}
+	return s.queues[uint64(chatID)%uint64(len(s.queues))]
+}
+
+func (s *Server) setWebhook() error {
+	// tgbotapi's WebhookConfig predates secret_token, so build the call by hand
+	params := tgbotapi.Params{}
+	params["url"] = s.cfg.WebhookURL + s.Path()
+	params["secret_token"] = s.cfg.WebhookSecretToken
+	if err := params.AddInterface("allowed_updates", []string{"message", "callback_query"}); err != nil {
+		return err
+	}
+
+	_, err := s.bot.MakeRequest("setWebhook", params)
+	return err
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// An empty token never matches, config.Load requires one in webhook mode
+	token := r.Header.Get(SecretTokenHeader)
+	if s.cfg.WebhookSecretToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.WebhookSecretToken)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update); err != nil {
+		log.Printf("Error decoding webhook update: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Acknowledge once queued, Telegram retries updates that take too long
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.stopped {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	select {
+	case s.queue(update) <- update:
+	case <-r.Context().Done():
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tcddbot/config"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const testSecretToken = "test-secret"
+
+// post delivers an update the way Telegram does and returns the status.
+func post(server *Server, token, body string) int {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set(SecretTokenHeader, token)
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestUpdatesOfAChatAreHandledInOrder(t *testing.T) {
+	var mu sync.Mutex
+	handled := make(map[int64][]int)
+	server := New(nil, &config.Config{WebhookWorkers: 4, WebhookSecretToken: testSecretToken}, func(ctx context.Context, update tgbotapi.Update) {
+		// The first update of every chat is the slowest, a later one must
+		// still wait for it
+		if update.UpdateID%10 == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		mu.Lock()
+		handled[update.Message.Chat.ID] = append(handled[update.Message.Chat.ID], update.UpdateID)
+		mu.Unlock()
+	})
+	server.start(context.Background())
+
+	for i := 0; i < 5; i++ {
+		for chatID := int64(1); chatID <= 3; chatID++ {
+			body := fmt.Sprintf(`{"update_id":%d,"message":{"message_id":%d,"chat":{"id":%d},"text":"x"}}`, i, i, chatID)
+			if status := post(server, testSecretToken, body); status != http.StatusOK {
+				t.Fatalf("update %d of chat %d: status %d", i, chatID, status)
+			}
+		}
+	}
+	server.stop()
+
+	for chatID := int64(1); chatID <= 3; chatID++ {
+		if got := fmt.Sprint(handled[chatID]); got != "[0 1 2 3 4]" {
+			t.Errorf("chat %d handled %s, want [0 1 2 3 4]", chatID, got)
+		}
+	}
+}
+
+func TestStoppedServerRejectsUpdates(t *testing.T) {
+	server := New(nil, &config.Config{WebhookSecretToken: testSecretToken}, func(context.Context, tgbotapi.Update) {
+		t.Error("update dispatched after stop")
+	})
+	server.start(context.Background())
+	server.stop()
+
+	if status := post(server, testSecretToken, `{"update_id":1}`); status != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestSecretTokenRequired(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		sent       string
+		want       int
+	}{
+		{"matching token", testSecretToken, testSecretToken, http.StatusOK},
+		{"wrong token", testSecretToken, "guess", http.StatusUnauthorized},
+		{"no token sent", testSecretToken, "", http.StatusUnauthorized},
+		{"no token configured", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := New(nil, &config.Config{WebhookSecretToken: tt.configured}, func(context.Context, tgbotapi.Update) {})
+			server.start(context.Background())
+			defer server.stop()
+
+			if status := post(server, tt.sent, `{"update_id":1}`); status != tt.want {
+				t.Fatalf("status %d, want %d", status, tt.want)
+			}
+		})
+	}
+}
@@ -2,35 +2,112 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"tcddbot/model"
+	"tcddbot/util"
 	"time"
 )
 
+// Job is a single upstream availability check for one route and date,
+// shared by every subscriber watching that route.
 type Job struct {
-	ChatID           int64
 	DepartureStation int
 	ArrivalStation   int
+	ViaStation       int // Station to change trains at, 0 for direct trains
 	TravelDate       string
-	LastNotified     time.Time // Add this field to track last notification
+	Passengers       model.Passengers // Sent with the search, part of the grouping key
+	Subscribers      []Subscriber
 }
 
+// Key identifies the upstream check a job makes. Two jobs with the same key
+// are never in the pool at once.
+func (j Job) Key() string {
+	return fmt.Sprintf("%d-%d-%d-%s-%s", j.DepartureStation, j.ArrivalStation, j.ViaStation, j.TravelDate, j.Passengers.OrDefault())
+}
+
+// Subscriber is a subscription waiting on the result of a Job.
+type Subscriber struct {
+	SubscriptionID int64
+	ChatID         int64
+	LastNotified   time.Time // Add this field to track last notification
+	AlertPrice     float64   // Fare later price drops on the job's date are measured against, 0 if none
+	DateRange      bool      // The subscription watches several days, Job.TravelDate is one of them
+	KeepWatching   bool      // A YHT match only settles Job.TravelDate, not the whole subscription
+	RoundTripID    int64     // Shared by both legs of a round trip, 0 for one way
+	ReturnLeg      bool      // The job checks the way back of a round trip
+	BothLegs       bool      // Report only when the return leg has seats too
+	Nearby         bool      // The job checks a sibling of a subscribed station
+	HomeDeparture  int       // Stations the subscription was made for, set with Nearby
+	HomeArrival    int
+	Filter         util.SeatFilter
+}
+
+var (
+	// ErrQueueFull is returned by AddJob when every queue slot is taken.
+	ErrQueueFull = errors.New("worker queue is full")
+	// ErrInFlight is returned by AddJob while a job with the same key is
+	// still queued or running.
+	ErrInFlight = errors.New("job already in flight")
+	// ErrStopped is returned by AddJob after Stop.
+	ErrStopped = errors.New("worker pool stopped")
+)
+
+// Stats is a snapshot of the pool counters. Queued and Running are current
+// values, the others count jobs since the pool was created.
+type Stats struct {
+	Queued    int
+	Running   int64
+	Succeeded int64
+	Failed    int64
+	Dropped   int64 // Turned away because the queue was full
+	Deduped   int64 // Turned away because the same check was in flight
+}
+
+// Pool runs jobs on a fixed number of workers. Enqueueing never blocks: a
+// job is turned away when the queue is full or the same check is already
+// waiting or running.
 type Pool struct {
 	workers  int
 	jobQueue chan Job
-	results  chan error
+	timeout  time.Duration
 	wg       sync.WaitGroup
 	handler  func(context.Context, Job) error
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+	stopped  bool
+	onError  func(Job, error)
+
+	running   atomic.Int64
+	succeeded atomic.Int64
+	failed    atomic.Int64
+	dropped   atomic.Int64
+	deduped   atomic.Int64
 }
 
-func NewPool(workers int, queueSize int, handler func(context.Context, Job) error) *Pool {
+// NewPool creates a pool of workers calling handler for every job. Each call
+// gets a context cancelled after timeout, 0 means no deadline.
+func NewPool(workers int, queueSize int, timeout time.Duration, handler func(context.Context, Job) error) *Pool {
 	return &Pool{
 		workers:  workers,
 		jobQueue: make(chan Job, queueSize),
-		results:  make(chan error, queueSize),
+		timeout:  timeout,
 		handler:  handler,
+		inFlight: make(map[string]bool),
 	}
 }
 
+// OnError registers fn to be called with every job that failed. fn runs on
+// the worker goroutine, a slow fn holds up that worker.
+func (p *Pool) OnError(fn func(Job, error)) {
+	p.mu.Lock()
+	p.onError = fn
+	p.mu.Unlock()
+}
+
 func (p *Pool) Start(ctx context.Context) {
 	for i := 0; i < p.workers; i++ {
 		p.wg.Add(1)
@@ -38,27 +115,103 @@ func (p *Pool) Start(ctx context.Context) {
 	}
 }
 
+// Stop stops accepting jobs and waits for the workers to finish the queue,
+// or to return once the context given to Start is done.
 func (p *Pool) Stop() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.stopped = true
 	close(p.jobQueue)
+	p.mu.Unlock()
+
 	p.wg.Wait()
-	close(p.results)
 }
 
-func (p *Pool) AddJob(job Job) {
-	p.jobQueue <- job
+// AddJob queues a job without blocking. It returns ErrInFlight, ErrQueueFull
+// or ErrStopped when the job was not queued.
+func (p *Pool) AddJob(job Job) error {
+	key := job.Key()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.stopped:
+		return ErrStopped
+	case p.inFlight[key]:
+		p.deduped.Add(1)
+		return ErrInFlight
+	}
+
+	select {
+	case p.jobQueue <- job:
+		p.inFlight[key] = true
+		return nil
+	default:
+		p.dropped.Add(1)
+		return ErrQueueFull
+	}
+}
+
+// Stats returns the current counters.
+func (p *Pool) Stats() Stats {
+	return Stats{
+		Queued:    len(p.jobQueue),
+		Running:   p.running.Load(),
+		Succeeded: p.succeeded.Load(),
+		Failed:    p.failed.Load(),
+		Dropped:   p.dropped.Load(),
+		Deduped:   p.deduped.Load(),
+	}
 }
 
 func (p *Pool) worker(ctx context.Context) {
 	defer p.wg.Done()
 
 	for job := range p.jobQueue {
-		select {
-		case <-ctx.Done():
+		if ctx.Err() != nil {
 			return
-		default:
-			if err := p.handler(ctx, job); err != nil {
-				p.results <- err
-			}
 		}
+		p.run(ctx, job)
+	}
+}
+
+func (p *Pool) run(ctx context.Context, job Job) {
+	p.running.Add(1)
+	err := p.handle(ctx, job)
+	p.running.Add(-1)
+
+	p.mu.Lock()
+	delete(p.inFlight, job.Key())
+	onError := p.onError
+	p.mu.Unlock()
+
+	if err == nil {
+		p.succeeded.Add(1)
+		return
+	}
+	p.failed.Add(1)
+	if onError != nil {
+		onError(job, err)
+	}
+}
+
+// handle calls the handler under the job deadline. A panicking job fails
+// like any other instead of taking the worker down.
+func (p *Pool) handle(ctx context.Context, job Job) (err error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
 	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return p.handler(ctx, job)
 }
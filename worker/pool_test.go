@@ -0,0 +1,219 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testJob(date string) Job {
+	return Job{DepartureStation: 98, ArrivalStation: 1323, TravelDate: date}
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolDedupesJobsInFlight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	pool := NewPool(1, 4, 0, func(ctx context.Context, job Job) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	})
+	pool.Start(context.Background())
+	defer pool.Stop()
+
+	if err := pool.AddJob(testJob("01-06-2030")); err != nil {
+		t.Fatalf("add job: %v", err)
+	}
+	<-started
+
+	// Running, a second check of the same route is turned away
+	if err := pool.AddJob(testJob("01-06-2030")); !errors.Is(err, ErrInFlight) {
+		t.Fatalf("add running job: got %v, want ErrInFlight", err)
+	}
+	// Another day is another check
+	if err := pool.AddJob(testJob("02-06-2030")); err != nil {
+		t.Fatalf("add job of another day: %v", err)
+	}
+	// Queued, the same
+	if err := pool.AddJob(testJob("02-06-2030")); !errors.Is(err, ErrInFlight) {
+		t.Fatalf("add queued job: got %v, want ErrInFlight", err)
+	}
+
+	close(release)
+	waitFor(t, "both jobs to finish", func() bool { return pool.Stats().Succeeded == 2 })
+
+	// Done, it may be queued again
+	if err := pool.AddJob(testJob("01-06-2030")); err != nil {
+		t.Fatalf("add finished job again: %v", err)
+	}
+	waitFor(t, "the job to finish again", func() bool { return pool.Stats().Succeeded == 3 })
+
+	if got := pool.Stats().Deduped; got != 2 {
+		t.Fatalf("Deduped: got %d, want 2", got)
+	}
+}
+
+func TestPoolDropsWhenQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	pool := NewPool(1, 1, 0, func(ctx context.Context, job Job) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	})
+	pool.Start(context.Background())
+	defer pool.Stop()
+	defer close(release)
+
+	// One job runs, one waits in the only queue slot
+	if err := pool.AddJob(testJob("01-06-2030")); err != nil {
+		t.Fatalf("add job: %v", err)
+	}
+	<-started
+	if err := pool.AddJob(testJob("02-06-2030")); err != nil {
+		t.Fatalf("add queued job: %v", err)
+	}
+
+	for _, date := range []string{"03-06-2030", "04-06-2030"} {
+		if err := pool.AddJob(testJob(date)); !errors.Is(err, ErrQueueFull) {
+			t.Fatalf("add job %s: got %v, want ErrQueueFull", date, err)
+		}
+	}
+
+	stats := pool.Stats()
+	if stats.Dropped != 2 || stats.Queued != 1 || stats.Running != 1 {
+		t.Fatalf("stats: got %+v, want 2 dropped, 1 queued, 1 running", stats)
+	}
+}
+
+func TestPoolJobDeadline(t *testing.T) {
+	errs := make(chan error, 1)
+	pool := NewPool(1, 1, 20*time.Millisecond, func(ctx context.Context, job Job) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("job context has no deadline")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	pool.OnError(func(job Job, err error) { errs <- err })
+	pool.Start(context.Background())
+	defer pool.Stop()
+
+	if err := pool.AddJob(testJob("01-06-2030")); err != nil {
+		t.Fatalf("add job: %v", err)
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("error: got %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("job ran past its deadline")
+	}
+	waitFor(t, "the failure to be counted", func() bool { return pool.Stats().Failed == 1 })
+}
+
+func TestPoolOnError(t *testing.T) {
+	boom := errors.New("boom")
+	var mu sync.Mutex
+	var failed []string
+	pool := NewPool(2, 4, time.Second, func(ctx context.Context, job Job) error {
+		if job.TravelDate == "02-06-2030" {
+			return boom
+		}
+		return nil
+	})
+	pool.OnError(func(job Job, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !errors.Is(err, boom) {
+			t.Errorf("error of %s: got %v, want boom", job.TravelDate, err)
+		}
+		failed = append(failed, job.TravelDate)
+	})
+	pool.Start(context.Background())
+
+	for _, date := range []string{"01-06-2030", "02-06-2030", "03-06-2030"} {
+		if err := pool.AddJob(testJob(date)); err != nil {
+			t.Fatalf("add job %s: %v", date, err)
+		}
+	}
+	pool.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failed) != 1 || failed[0] != "02-06-2030" {
+		t.Fatalf("OnError calls: got %v, want [02-06-2030]", failed)
+	}
+	if stats := pool.Stats(); stats.Succeeded != 2 || stats.Failed != 1 {
+		t.Fatalf("stats: got %+v, want 2 succeeded, 1 failed", stats)
+	}
+}
+
+func TestPoolRecoversPanics(t *testing.T) {
+	errs := make(chan error, 1)
+	pool := NewPool(1, 4, 0, func(ctx context.Context, job Job) error {
+		if job.TravelDate == "01-06-2030" {
+			panic("bad response")
+		}
+		return nil
+	})
+	pool.OnError(func(job Job, err error) { errs <- err })
+	pool.Start(context.Background())
+
+	if err := pool.AddJob(testJob("01-06-2030")); err != nil {
+		t.Fatalf("add job: %v", err)
+	}
+	if err := pool.AddJob(testJob("02-06-2030")); err != nil {
+		t.Fatalf("add job: %v", err)
+	}
+	pool.Stop()
+
+	// The worker survived and ran the next job
+	if stats := pool.Stats(); stats.Failed != 1 || stats.Succeeded != 1 || stats.Running != 0 {
+		t.Fatalf("stats: got %+v, want 1 failed, 1 succeeded", stats)
+	}
+	if err := <-errs; !strings.Contains(err.Error(), "bad response") {
+		t.Fatalf("error: got %v, want the panic value", err)
+	}
+	// The panicked job is not left in flight
+	if inFlight := len(pool.inFlight); inFlight != 0 {
+		t.Fatalf("jobs in flight after Stop: %d", inFlight)
+	}
+}
+
+func TestPoolStop(t *testing.T) {
+	pool := NewPool(1, 1, 0, func(ctx context.Context, job Job) error { return nil })
+	pool.Start(context.Background())
+	pool.Stop()
+	// Stopping twice is harmless
+	pool.Stop()
+
+	if err := pool.AddJob(testJob("01-06-2030")); !errors.Is(err, ErrStopped) {
+		t.Fatalf("add job after Stop: got %v, want ErrStopped", err)
+	}
+	if stats := pool.Stats(); stats.Dropped != 0 || stats.Queued != 0 {
+		t.Fatalf("stats after a rejected job: %+v", stats)
+	}
+}
@@ -0,0 +1,231 @@
+package worker
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Scheduler decides when each job is checked next. Jobs wait in a priority
+// queue ordered by their next check time and are handed out while the
+// request budget of the current minute lasts.
+type Scheduler struct {
+	minInterval time.Duration
+	budget      int
+	interval    func(Job) time.Duration
+
+	mu          sync.Mutex
+	queue       schedule
+	entries     map[string]*entry
+	windowStart time.Time
+	used        int
+	spent       bool // A due job waits for the next minute's budget
+}
+
+// SchedulerStats is a snapshot of the scheduler for the admin.
+type SchedulerStats struct {
+	Jobs   int // Jobs scheduled
+	Due    int // Jobs whose check time has passed but wait for budget
+	Used   int // Requests spent in the current minute
+	Budget int // Requests allowed per minute, 0 for no limit
+}
+
+type entry struct {
+	job   Job
+	next  time.Time
+	churn float64 // Between 0 and 1, how often the seat count changed lately
+	seats int     // Seats seen at the last check, -1 before the first
+	index int
+}
+
+// NewScheduler creates a scheduler checking each job every interval(job),
+// sooner while its seats keep changing but never more often than
+// minInterval. budget caps the upstream requests per minute, 0 disables it.
+func NewScheduler(minInterval time.Duration, budget int, interval func(Job) time.Duration) *Scheduler {
+	return &Scheduler{
+		minInterval: minInterval,
+		budget:      budget,
+		interval:    interval,
+		entries:     make(map[string]*entry),
+	}
+}
+
+// Sync replaces the scheduled jobs with jobs. New jobs are due right away,
+// known ones keep their check time but take the new subscribers.
+func (s *Scheduler) Sync(jobs []Job, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		key := job.Key()
+		seen[key] = true
+		if e, ok := s.entries[key]; ok {
+			e.job = job
+			continue
+		}
+		e := &entry{job: job, next: now, seats: -1}
+		s.entries[key] = e
+		heap.Push(&s.queue, e)
+	}
+
+	for key, e := range s.entries {
+		if !seen[key] {
+			heap.Remove(&s.queue, e.index)
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Due returns the jobs to check now, most overdue first, and schedules
+// their next check. Jobs the budget has no room for stay due.
+func (s *Scheduler) Due(now time.Time) []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.windowStart) >= time.Minute {
+		s.windowStart = now
+		s.used = 0
+		s.spent = false
+	}
+
+	var jobs []Job
+	for s.queue.Len() > 0 {
+		e := s.queue[0]
+		if e.next.After(now) {
+			break
+		}
+		// A job costing more than the whole budget still goes out alone
+		cost := jobCost(e.job)
+		if s.budget > 0 && s.used > 0 && s.used+cost > s.budget {
+			s.spent = true
+			break
+		}
+		s.used += cost
+		jobs = append(jobs, e.job)
+
+		e.next = now.Add(s.nextInterval(e))
+		heap.Fix(&s.queue, e.index)
+	}
+	return jobs
+}
+
+// Retry makes a job that could not be queued due again after minInterval.
+// The job made no request, so what Due charged for it is given back.
+func (s *Scheduler) Retry(job Job, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.windowStart) < time.Minute {
+		s.used = max(s.used-jobCost(job), 0)
+		s.spent = false
+	}
+	if e, ok := s.entries[job.Key()]; ok {
+		e.next = now.Add(s.minInterval)
+		heap.Fix(&s.queue, e.index)
+	}
+}
+
+// Observe records the seats a check of the job saw on sale. Routes whose
+// seats keep changing are checked more often.
+func (s *Scheduler) Observe(job Job, seats int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[job.Key()]
+	if !ok {
+		return
+	}
+	changed := 0.0
+	if e.seats >= 0 && e.seats != seats {
+		changed = 1
+	}
+	e.churn = (e.churn + changed) / 2
+	e.seats = seats
+}
+
+// NextCheck returns when Due will next have a job to hand out, zero when
+// nothing is scheduled.
+func (s *Scheduler) NextCheck(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.queue.Len() == 0 {
+		return time.Time{}
+	}
+	next := s.queue[0].next
+	// Spent budget comes back with the next minute
+	windowEnd := s.windowStart.Add(time.Minute)
+	if s.spent && now.Before(windowEnd) && next.Before(windowEnd) {
+		next = windowEnd
+	}
+	return next
+}
+
+// Stats returns the current state of the scheduler.
+func (s *Scheduler) Stats(now time.Time) SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := SchedulerStats{Jobs: len(s.entries), Budget: s.budget}
+	if now.Sub(s.windowStart) < time.Minute {
+		stats.Used = s.used
+	}
+	for _, e := range s.queue {
+		if !e.next.After(now) {
+			stats.Due++
+		}
+	}
+	return stats
+}
+
+// nextInterval shortens the base interval of a job up to four times while
+// its seats are changing
+func (s *Scheduler) nextInterval(e *entry) time.Duration {
+	interval := time.Duration(float64(s.interval(e.job)) / (1 + 3*e.churn))
+	return max(interval, s.minInterval)
+}
+
+// jobCost is the number of upstream requests a check of the job makes at most
+func jobCost(job Job) int {
+	cost := 1
+	if job.ViaStation != 0 {
+		// The second leg is searched on the travel date and, after a late
+		// first leg, on the next day too
+		cost += 2
+	}
+	for _, sub := range job.Subscribers {
+		// The outbound leg checks the way back too
+		if sub.BothLegs {
+			cost++
+			break
+		}
+	}
+	return cost
+}
+
+// schedule is a min-heap of entries by next check time
+type schedule []*entry
+
+func (q schedule) Len() int           { return len(q) }
+func (q schedule) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+
+func (q schedule) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *schedule) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *schedule) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return e
+}
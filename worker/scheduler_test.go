@@ -0,0 +1,190 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+var schedulerStart = time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func everyFourMinutes(Job) time.Duration { return 4 * time.Minute }
+
+func scheduledJobs(dates ...string) []Job {
+	var jobs []Job
+	for _, date := range dates {
+		jobs = append(jobs, testJob(date))
+	}
+	return jobs
+}
+
+func TestSchedulerBudget(t *testing.T) {
+	s := NewScheduler(time.Second, 2, everyFourMinutes)
+	s.Sync(scheduledJobs("01-06-2030", "02-06-2030", "03-06-2030"), schedulerStart)
+
+	if got := len(s.Due(schedulerStart)); got != 2 {
+		t.Fatalf("due: got %d jobs, want the budget of 2", got)
+	}
+	stats := s.Stats(schedulerStart)
+	if stats.Used != 2 || stats.Due != 1 {
+		t.Fatalf("stats: got %+v, want 2 used and 1 due", stats)
+	}
+	if got := len(s.Due(schedulerStart.Add(30 * time.Second))); got != 0 {
+		t.Fatalf("due within the minute: got %d jobs, want 0", got)
+	}
+	// The waiting job is put off until the budget comes back
+	if got, want := s.NextCheck(schedulerStart.Add(30*time.Second)), schedulerStart.Add(time.Minute); !got.Equal(want) {
+		t.Fatalf("next check: got %v, want %v", got, want)
+	}
+}
+
+func TestSchedulerBudgetResetsEveryMinute(t *testing.T) {
+	s := NewScheduler(time.Second, 1, everyFourMinutes)
+	s.Sync(scheduledJobs("01-06-2030", "02-06-2030"), schedulerStart)
+
+	if got := len(s.Due(schedulerStart)); got != 1 {
+		t.Fatalf("due: got %d jobs, want 1", got)
+	}
+	if got := len(s.Due(schedulerStart.Add(59 * time.Second))); got != 0 {
+		t.Fatalf("due before a minute passed: got %d jobs, want 0", got)
+	}
+	if got := len(s.Due(schedulerStart.Add(time.Minute))); got != 1 {
+		t.Fatalf("due a minute later: got %d jobs, want 1", got)
+	}
+}
+
+func TestSchedulerJobOverBudgetGoesAlone(t *testing.T) {
+	s := NewScheduler(time.Second, 1, everyFourMinutes)
+	expensive := testJob("01-06-2030")
+	expensive.ViaStation = 20
+	s.Sync([]Job{expensive, testJob("02-06-2030")}, schedulerStart)
+
+	jobs := s.Due(schedulerStart)
+	if len(jobs) != 1 || jobs[0].Key() != expensive.Key() {
+		t.Fatalf("due: got %v, want the connection alone", jobs)
+	}
+}
+
+func TestJobCost(t *testing.T) {
+	direct := testJob("01-06-2030")
+	via := testJob("01-06-2030")
+	via.ViaStation = 20
+	roundTrip := testJob("01-06-2030")
+	roundTrip.Subscribers = []Subscriber{{ChatID: 1}, {ChatID: 2, BothLegs: true}, {ChatID: 3, BothLegs: true}}
+
+	tests := []struct {
+		name string
+		job  Job
+		want int
+	}{
+		{"direct", direct, 1},
+		{"connection with a next day second leg", via, 3},
+		{"outbound leg checking the way back", roundTrip, 2},
+	}
+	for _, tt := range tests {
+		if got := jobCost(tt.job); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSchedulerNoBudget(t *testing.T) {
+	s := NewScheduler(time.Second, 0, everyFourMinutes)
+	s.Sync(scheduledJobs("01-06-2030", "02-06-2030", "03-06-2030"), schedulerStart)
+
+	if got := len(s.Due(schedulerStart)); got != 3 {
+		t.Fatalf("due: got %d jobs, want 3", got)
+	}
+}
+
+func TestSchedulerChurnShortensInterval(t *testing.T) {
+	s := NewScheduler(time.Second, 0, everyFourMinutes)
+	job := testJob("01-06-2030")
+	s.Sync([]Job{job}, schedulerStart)
+
+	now := schedulerStart
+	s.Due(now)
+	if got, want := s.NextCheck(now), now.Add(4*time.Minute); !got.Equal(want) {
+		t.Fatalf("next check of a new job: got %v, want %v", got, want)
+	}
+
+	// The first observation has nothing to compare with
+	s.Observe(job, 5)
+	now = now.Add(4 * time.Minute)
+	s.Due(now)
+	if got, want := s.NextCheck(now), now.Add(4*time.Minute); !got.Equal(want) {
+		t.Fatalf("next check after the first observation: got %v, want %v", got, want)
+	}
+
+	// Churn 0.5 checks 2.5 times as often
+	s.Observe(job, 6)
+	now = now.Add(4 * time.Minute)
+	s.Due(now)
+	if got, want := s.NextCheck(now), now.Add(96*time.Second); !got.Equal(want) {
+		t.Fatalf("next check after seats changed: got %v, want %v", got, want)
+	}
+
+	// Settled seats slow it down again
+	s.Observe(job, 6)
+	now = now.Add(96 * time.Second)
+	s.Due(now)
+	if got, want := s.NextCheck(now), now.Add(time.Duration(float64(everyFourMinutes(job))/1.75)); !got.Equal(want) {
+		t.Fatalf("next check after seats settled: got %v, want %v", got, want)
+	}
+}
+
+func TestSchedulerMinInterval(t *testing.T) {
+	s := NewScheduler(3*time.Minute, 0, everyFourMinutes)
+	job := testJob("01-06-2030")
+	s.Sync([]Job{job}, schedulerStart)
+
+	s.Observe(job, 1)
+	s.Observe(job, 2)
+	s.Observe(job, 3)
+	s.Due(schedulerStart)
+	if got, want := s.NextCheck(schedulerStart), schedulerStart.Add(3*time.Minute); !got.Equal(want) {
+		t.Fatalf("next check: got %v, want %v", got, want)
+	}
+}
+
+func TestSchedulerRetry(t *testing.T) {
+	s := NewScheduler(10*time.Second, 1, everyFourMinutes)
+	s.Sync(scheduledJobs("01-06-2030", "02-06-2030"), schedulerStart)
+
+	jobs := s.Due(schedulerStart)
+	if len(jobs) != 1 {
+		t.Fatalf("due: got %d jobs, want 1", len(jobs))
+	}
+
+	// The job could not be queued, its budget goes to the next one
+	s.Retry(jobs[0], schedulerStart)
+	if used := s.Stats(schedulerStart).Used; used != 0 {
+		t.Fatalf("used after retry: got %d, want 0", used)
+	}
+	next := s.Due(schedulerStart)
+	if len(next) != 1 || next[0].Key() == jobs[0].Key() {
+		t.Fatalf("due after retry: got %v, want the other job", next)
+	}
+
+	// And is due again after minInterval, once the budget allows
+	later := schedulerStart.Add(time.Minute)
+	retried := s.Due(later)
+	if len(retried) != 1 || retried[0].Key() != jobs[0].Key() {
+		t.Fatalf("due a minute later: got %v, want the retried job", retried)
+	}
+}
+
+func TestSchedulerSync(t *testing.T) {
+	s := NewScheduler(time.Second, 0, everyFourMinutes)
+	s.Sync(scheduledJobs("01-06-2030", "02-06-2030"), schedulerStart)
+	s.Due(schedulerStart)
+
+	// Known jobs keep their check time, new ones are due right away
+	s.Sync(scheduledJobs("02-06-2030", "03-06-2030"), schedulerStart.Add(time.Minute))
+	jobs := s.Due(schedulerStart.Add(time.Minute))
+	if len(jobs) != 1 || jobs[0].TravelDate != "03-06-2030" {
+		t.Fatalf("due after sync: got %v, want the new job", jobs)
+	}
+	if got := s.Stats(schedulerStart.Add(time.Minute)).Jobs; got != 2 {
+		t.Fatalf("jobs after sync: got %d, want 2", got)
+	}
+}